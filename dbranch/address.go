@@ -0,0 +1,169 @@
+package dbranch
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+//
+// cardano shelley addresses
+//
+
+const bech32_charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	check := uint32(1)
+	for _, value := range values {
+		top := check >> 25
+		check = (check&0x1ffffff)<<5 ^ uint32(value)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				check ^= generator[i]
+			}
+		}
+	}
+	return check
+}
+
+func bech32Decode(encoded string) (string, []byte, error) {
+	/* returns the human readable part and data as 8 bit bytes, cardano addresses are longer than the 90 character bip-173 limit */
+	if strings.ToLower(encoded) != encoded && strings.ToUpper(encoded) != encoded {
+		return "", nil, errors.New("invalid bech32: mixed case")
+	}
+	encoded = strings.ToLower(encoded)
+
+	separator := strings.LastIndexByte(encoded, '1')
+	if separator < 1 || separator+7 > len(encoded) {
+		return "", nil, errors.New("invalid bech32: missing separator or checksum")
+	}
+
+	hrp := encoded[:separator]
+	values := []byte{}
+	for _, c := range hrp {
+		values = append(values, byte(c>>5))
+	}
+	values = append(values, 0)
+	for _, c := range hrp {
+		values = append(values, byte(c&31))
+	}
+
+	data := []byte{}
+	for _, c := range encoded[separator+1:] {
+		index := strings.IndexRune(bech32_charset, c)
+		if index < 0 {
+			return "", nil, errors.New("invalid bech32 character: " + string(c))
+		}
+		data = append(data, byte(index))
+	}
+
+	if bech32Polymod(append(values, data...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+
+	// convert 5 bit groups to bytes, dropping the checksum
+	var accumulator, bits uint
+	decoded := []byte{}
+	for _, value := range data[:len(data)-6] {
+		accumulator = accumulator<<5 | uint(value)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			decoded = append(decoded, byte(accumulator>>bits))
+		}
+	}
+	if bits >= 5 || accumulator&(1<<bits-1) != 0 {
+		return "", nil, errors.New("invalid bech32 padding")
+	}
+
+	return hrp, decoded, nil
+}
+
+func decodeCardanoAddress(address string) ([]byte, error) {
+	/* accepts bech32 (addr1..., addr_test1...) or hex as returned by CIP-30 wallets */
+	if strings.HasPrefix(address, "addr") {
+		hrp, data, err := bech32Decode(address)
+		if err != nil {
+			return nil, err
+		}
+		if hrp != "addr" && hrp != "addr_test" {
+			return nil, errors.New("not a cardano address: " + hrp)
+		}
+		return data, nil
+	}
+
+	data, err := hex.DecodeString(address)
+	if err != nil {
+		return nil, errors.New("address must be bech32 or hex")
+	}
+	return data, nil
+}
+
+func paymentKeyHash(address []byte) ([]byte, error) {
+	// shelley address types 0, 2, 4 and 6 start with a payment key hash, the others are scripts or byron
+	if len(address) < 29 {
+		return nil, errors.New("address too short")
+	}
+
+	address_type := address[0] >> 4
+	if address_type > 7 || address_type%2 != 0 {
+		return nil, errors.New("address does not have a payment key")
+	}
+
+	return address[1:29], nil
+}
+
+func blake2b224(data []byte) []byte {
+	hash, _ := blake2b.New(28, nil)
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func bech32Encode(hrp string, data []byte) string {
+	// convert bytes to 5 bit groups
+	var accumulator, bits uint
+	values := []byte{}
+	for _, b := range data {
+		accumulator = accumulator<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(accumulator>>bits)&31)
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(accumulator<<(5-bits))&31)
+	}
+
+	expanded := []byte{}
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c>>5))
+	}
+	expanded = append(expanded, 0)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c&31))
+	}
+
+	check := bech32Polymod(append(append(expanded, values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(check>>(5*(5-i)))&31)
+	}
+
+	var encoded strings.Builder
+	encoded.WriteString(hrp + "1")
+	for _, value := range values {
+		encoded.WriteByte(bech32_charset[value])
+	}
+	return encoded.String()
+}
+
+func encodeCardanoAddress(address []byte) string {
+	// the low nibble of the header is the network id, 1 for mainnet
+	if len(address) > 0 && address[0]&0x0f == 1 {
+		return bech32Encode("addr", address)
+	}
+	return bech32Encode("addr_test", address)
+}
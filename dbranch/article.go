@@ -6,8 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -15,19 +15,38 @@ import (
 	ipfs "github.com/ipfs/go-ipfs-api"
 )
 
-var shell *ipfs.Shell
-
 const CuratedDir = "/dBranch/curated"
 const PublishedDir = "/dBranch/published"
 const IndexFile = "/dBranch/index.json"
 
-func init() {
-	host := os.Getenv("IPFS_HOST")
-	if host == "" {
-		host = "localhost:5001"
+//
+// ipfs calls that honor a context, the shell's own versions of these use context.Background
+//
+
+func (node *Node) ipfsCat(ctx context.Context, ipfs_path string) (io.ReadCloser, error) {
+	// the reader must be closed before ctx is cancelled
+	resp, err := node.shell.Request("cat", ipfs_path).Send(ctx)
+	if err != nil {
+		return nil, err
 	}
-	shell = ipfs.NewShell(host)
-	shell.SetTimeout(15 * time.Second)
+	if resp.Error != nil {
+		resp.Close()
+		return nil, resp.Error
+	}
+	return resp.Output, nil
+}
+
+func (node *Node) ipfsPin(ctx context.Context, ipfs_path string) error {
+	return node.shell.Request("pin/add", ipfs_path).Option("recursive", true).Exec(ctx, nil)
+}
+
+func (node *Node) ipfsUnpin(ctx context.Context, ipfs_path string) error {
+	return node.shell.Request("pin/rm", ipfs_path).Option("recursive", true).Exec(ctx, nil)
+}
+
+func (node *Node) ipfsPins(ctx context.Context) (map[string]ipfs.PinInfo, error) {
+	var raw struct{ Keys map[string]ipfs.PinInfo }
+	return raw.Keys, node.shell.Request("pin/ls").Exec(ctx, &raw)
 }
 
 //
@@ -41,19 +60,22 @@ type Article struct {
 }
 
 type ArticleMetadata struct {
-	Type     string `json:"type"`
-	Title    string `json:"title"`
-	SubTitle string `json:"sub_title"`
-	Author   string `json:"author"`
+	Type     string   `json:"type"`
+	Title    string   `json:"title"`
+	SubTitle string   `json:"sub_title"`
+	Author   string   `json:"author"`
+	Tags     []string `json:"tags,omitempty"`
+	Language string   `json:"language,omitempty"` // ex: en, es
 }
 
 type ArticleRecord struct {
 	Name          string    `json:"name"`
-	Size          uint64    `json:"size"`
+	Size          uint64    `json:"size"` // dag size of article plus attachments
 	CID           string    `json:"cid"`
 	DateAdded     time.Time `json:"date_added"`                // date curated in UTC
 	DatePublished time.Time `json:"date_published"`            // publish date in UTC
 	CardanoTxHash string    `json:"cardano_tx_hash,omitempty"` // cardano transaction id
+	Attachments   []string  `json:"attachments,omitempty"`     // cids of media pinned with the article
 }
 
 type ArticleIndexItem struct {
@@ -66,22 +88,24 @@ type ArticleIndex struct {
 	PublishedArticles []*ArticleIndexItem `json:"published"`
 }
 
-func statIpfsPath(path string) (*ipfs.FilesStatObject, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (node *Node) statIpfsPath(ctx context.Context, path string) (*ipfs.FilesStatObject, error) {
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
 	defer cancel()
-	return shell.FilesStat(ctx, path)
+	return node.shell.FilesStat(ctx, path)
 }
 
-func loadArticleRecord(path string) (*ArticleRecord, error) {
+func (node *Node) loadArticleRecord(ctx context.Context, path string) (*ArticleRecord, error) {
 	// init
 	record := &ArticleRecord{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
 	defer cancel()
 
 	// read and decode record
-	record_raw, err := shell.FilesRead(ctx, path, ipfs.FilesLs.Stat(true))
-	if err != nil {
+	record_raw, err := node.shell.FilesRead(ctx, path, ipfs.FilesLs.Stat(true))
+	if ipfsNotExist(err) {
+		return record, fmt.Errorf("%w: %s", ErrRecordNotFound, path)
+	} else if err != nil {
 		return record, err
 	}
 
@@ -93,16 +117,18 @@ func loadArticleRecord(path string) (*ArticleRecord, error) {
 	return record, nil
 }
 
-func loadArticle(path string) (*Article, error) {
+func (node *Node) loadArticle(ctx context.Context, path string) (*Article, error) {
 	// init
 	article := &Article{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
 	defer cancel()
 
 	// read and decode article
-	article_raw, err := shell.FilesRead(ctx, path, ipfs.FilesLs.Stat(true))
-	if err != nil {
+	article_raw, err := node.shell.FilesRead(ctx, path, ipfs.FilesLs.Stat(true))
+	if ipfsNotExist(err) {
+		return article, fmt.Errorf("%w: %s", ErrArticleNotFound, path)
+	} else if err != nil {
 		return article, err
 	}
 
@@ -110,14 +136,14 @@ func loadArticle(path string) (*Article, error) {
 	return article, err
 }
 
-func GetArticleByMFSPath(path string) (*Article, error) {
+func (node *Node) GetArticleByMFSPath(ctx context.Context, path string) (*Article, error) {
 	/* get an article and record by MFS path */
-	article, err := loadArticle(path)
+	article, err := node.loadArticle(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
-	record, err := loadArticleRecord(path + ".json")
+	record, err := node.loadArticleRecord(ctx, path+".json")
 	if err != nil {
 		return nil, err
 	}
@@ -126,12 +152,15 @@ func GetArticleByMFSPath(path string) (*Article, error) {
 	return article, nil
 }
 
-func cidIsPinned(cid string) (bool, error) {
+func (node *Node) cidIsPinned(ctx context.Context, cid string) (bool, error) {
 	if strings.HasPrefix(cid, "/ipfs/") {
 		cid = cid[6:]
 	}
 
-	pins, err := shell.Pins()
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
+	defer cancel()
+
+	pins, err := node.ipfsPins(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -141,30 +170,57 @@ func cidIsPinned(cid string) (bool, error) {
 	return pinned, nil
 }
 
-func GetArticleByCID(article_cid string, load_record bool) (*Article, error) {
+func (node *Node) GetArticleByCID(ctx context.Context, article_cid string, load_record bool) (*Article, error) {
+	blocked, err := node.IsBlocked(BlockCID, article_cid)
+	if err != nil {
+		return nil, err
+	}
+
+	if blocked != nil {
+		return nil, fmt.Errorf("article is %w", ErrBlocked)
+	}
+
 	// check if pinned because the Cat command will search the network if it is not local, potentially resulting in a timeout
-	pinned, err := cidIsPinned(article_cid)
+	pinned, err := node.cidIsPinned(ctx, article_cid)
 	if err != nil {
 		return nil, err
 	}
 
 	if !pinned {
-		return nil, errors.New("article not found")
+		if !node.gatewayFallbackEnabled() {
+			return nil, ErrArticleNotFound
+		}
+
+		data, err := node.fetchArticleFallback(ctx, article_cid)
+		if err != nil {
+			return nil, err
+		}
+
+		article, err := node.DecodeArticle(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		// an unpinned article can still have a record, e.g. if it was removed from ipfs but not from the index
+		if load_record {
+			record, err := node.GetRecordForArticle(ctx, article_cid)
+			if err != nil && !errors.Is(err, ErrRecordNotFound) {
+				return nil, err
+			}
+			article.Record = record
+		}
+		return article, nil
 	}
 
 	// load and decode article
-	resp, err := shell.Cat("/ipfs/" + article_cid)
+	article, err := node.catArticle(ctx, "/ipfs/"+article_cid)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Close()
-
-	article := &Article{}
-	err = json.NewDecoder(resp).Decode(&article)
 
 	// load article record if requested
 	if load_record {
-		record, err := GetRecordForArticle(article_cid)
+		record, err := node.GetRecordForArticle(ctx, article_cid)
 		if err != nil {
 			return nil, err
 		}
@@ -174,8 +230,21 @@ func GetArticleByCID(article_cid string, load_record bool) (*Article, error) {
 	return article, nil
 }
 
-func GetRecordForArticle(article_cid string) (*ArticleRecord, error) {
-	index, err := LoadArticleIndex()
+func (node *Node) catArticle(ctx context.Context, ipfs_path string) (*Article, error) {
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
+	defer cancel()
+
+	resp, err := node.ipfsCat(ctx, ipfs_path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	return node.DecodeArticle(resp)
+}
+
+func (node *Node) GetRecordForArticle(ctx context.Context, article_cid string) (*ArticleRecord, error) {
+	index, err := node.LoadArticleIndex(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -188,19 +257,19 @@ func GetRecordForArticle(article_cid string) (*ArticleRecord, error) {
 		}
 	}
 
-	return nil, errors.New("record not found")
+	return nil, ErrRecordNotFound
 
 }
 
-func listArticles(path string) ([]string, error) {
+func (node *Node) listArticles(ctx context.Context, path string) ([]string, error) {
 	// init
 	list := []string{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
 	defer cancel()
 
 	// get listing
-	ls, err := shell.FilesLs(ctx, path)
+	ls, err := node.shell.FilesLs(ctx, path)
 	if err != nil {
 		return list, err
 	}
@@ -215,49 +284,117 @@ func listArticles(path string) ([]string, error) {
 	return list, nil
 }
 
-func AddRecordToLocal(directory string, record *ArticleRecord, copy_article bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func auditActionForDirectory(directory string) string {
+	switch directory {
+	case CuratedDir:
+		return "curate"
+	case PublishedDir:
+		return "publish"
+	case QuarantineDir:
+		return "quarantine"
+	default:
+		return "add"
+	}
+}
+
+func (node *Node) AddRecordToLocal(ctx context.Context, directory string, record *ArticleRecord, copy_article bool, actor *AuditActor) error {
+	directory, err := node.addRecordToLocal(ctx, directory, record, copy_article, actor)
+	node.audit(actor, auditActionForDirectory(directory), record, "", err)
+	return err
+}
+
+func (node *Node) addRecordToLocal(ctx context.Context, directory string, record *ArticleRecord, copy_article bool, actor *AuditActor) (string, error) {
+	// returns the directory the article was added to, which is changed if the article is quarantined
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsCopyTimeout)
 	defer cancel()
 
 	ipfs_source := path.Join("/ipfs", record.CID)
 	article_path := path.Join(directory, record.Name)
 	record_path := article_path + ".json"
 
+	var attachments_size uint64
+
+	// once the article is copied and pinned any failure undoes it so no half curated article is left behind
+	rollback := func() {
+		if !copy_article {
+			return
+		}
+		// not ctx, which may be what failed, ie. the copy timeout
+		ctx, cancel := context.WithTimeout(context.Background(), node.config.IpfsTimeout)
+		defer cancel()
+		node.unpinArticle(ctx, record.Name, append(record.Attachments, record.CID))
+		node.shell.FilesRm(ctx, article_path, true)
+		node.shell.FilesRm(ctx, record_path, true)
+	}
+
 	//
-	// optionally copy article to ipfs files (mfs)
+	// optionally copy article and attachments to ipfs files (mfs)
 	//
 
 	if copy_article {
 
-		ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
+		err := node.checkBlocklist(record, "")
+		if err != nil {
+			node.recordRejection(record, err)
+			return directory, err
+		}
 
-		err := shell.FilesCp(ctx, ipfs_source, article_path)
+		// validate before anything is written so invalid articles never end up partially curated
+		article, err := node.catArticle(ctx, ipfs_source)
+		if errors.Is(err, ErrUnknownArticleType) && node.config.UnknownTypePolicy == "quarantine" {
+			// hold articles of unknown types outside the curated dir until a type is registered for them
+			log.Printf("quarantining article: %s: %s\n", record.Name, err)
+			directory = QuarantineDir
+			article_path = path.Join(directory, record.Name)
+			record_path = article_path + ".json"
+
+			err = node.shell.FilesMkdir(ctx, directory, ipfs.FilesMkdir.Parents(true))
+			if err != nil {
+				return directory, err
+			}
+		} else if err != nil {
+			node.recordRejection(record, err)
+			return directory, err
+		}
+
+		err = node.shell.FilesCp(ctx, ipfs_source, article_path)
 		if err != nil {
-			return err
+			return directory, err
 		}
 
 		log.Printf("copied article: %s to: %s\n", ipfs_source, article_path)
 
 		// pin article because FilesCp does not copy the entire contents of the file, just the root node of the DAG
-		err = shell.Pin(record.CID)
+		err = node.ipfsPin(ctx, record.CID)
 		if err != nil {
-			return err
+			node.shell.FilesRm(ctx, article_path, true)
+			return directory, err
 		}
 
 		log.Printf("pinned CID: %s\n", record.CID)
+
+		// quarantined articles failed validation so there is no article to search for attachments
+		if article != nil {
+			record.Attachments, attachments_size, err = node.pinAttachments(ctx, record.Name, article)
+			if err != nil {
+				rollback()
+				return directory, errors.New("error pinning attachments for: " + record.Name + ": " + err.Error())
+			}
+		}
 	}
 
 	//
 	// set meteadata
 	//
 
-	stat, err := shell.FilesStat(ctx, article_path)
+	stat, err := node.shell.FilesStat(ctx, article_path)
 	if err != nil {
-		return err
+		rollback()
+		return directory, err
 	}
 
-	record.Size = stat.Size
+	// the dag size, which attachments are measured by too since they may be directories
+	record.Size = stat.CumulativeSize + attachments_size
 	record.DateAdded = time.Now().UTC()
 
 	//
@@ -266,51 +403,93 @@ func AddRecordToLocal(directory string, record *ArticleRecord, copy_article bool
 
 	mashalled_record, err := json.Marshal(record)
 	if err != nil {
-		return err
+		rollback()
+		return directory, err
 	}
 
 	json_reader := bytes.NewReader(mashalled_record)
-	err = shell.FilesWrite(ctx, record_path, json_reader, ipfs.FilesWrite.Create(true))
+	err = node.shell.FilesWrite(ctx, record_path, json_reader, ipfs.FilesWrite.Create(true))
 	if err != nil {
-		return errors.New("Error writing article record to: " + record_path + ": " + err.Error())
+		rollback()
+		return directory, errors.New("Error writing article record to: " + record_path + ": " + err.Error())
 	}
 
 	log.Printf("wrote artricle record to: %s\n", record_path)
 
+	if copy_article {
+		// replication failures are retried by the daemon so they don't fail curation
+		err = node.ReplicateArticle(ctx, record)
+		if err != nil {
+			log.Printf("could not replicate article: %s: %s\n", record.Name, err)
+		}
+	}
+
+	// frontends are notified before the index refresh so they can fetch the new article right away
+	node.events.Publish(&Event{Type: EventArticleAdded, Section: sectionForDirectory(directory), Record: record, Metadata: node.loadArticleMetadata(ctx, article_path)})
+
 	// refresh article index
-	err = RefreshArticleIndex()
+	err = node.RefreshArticleIndex(ctx, actor)
 	if err != nil {
-		return err
+		return directory, err
 	}
 
 	log.Println("article successfully added: " + record.Name)
-	return nil
+	return directory, nil
+}
+
+func (node *Node) ListQuarantinedArticles(ctx context.Context) ([]string, error) {
+	names, err := node.listArticles(ctx, QuarantineDir)
+	if ipfsNotExist(err) {
+		return []string{}, nil
+	}
+	return names, err
+}
+
+func (node *Node) RemoveRecordFromLocal(ctx context.Context, name string, actor *AuditActor) error {
+	record, err := node.removeRecordFromLocal(ctx, name, actor)
+	if record == nil {
+		record = &ArticleRecord{Name: name}
+	}
+	node.audit(actor, "remove", record, "", err)
+	return err
 }
 
-func RemoveRecordFromLocal(name string) error {
+func (node *Node) removeRecordFromLocal(ctx context.Context, name string, actor *AuditActor) (*ArticleRecord, error) {
 	log.Printf("removing article: %s\n", name)
 
 	// init
 	article_path := path.Join(CuratedDir, name)
 	record_path := article_path + ".json"
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsCopyTimeout)
 	defer cancel()
 
+	record, err := node.loadArticleRecord(ctx, record_path)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrArticleNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	metadata := node.loadArticleMetadata(ctx, article_path)
+
 	// delete files
-	err := shell.FilesRm(ctx, record_path, true)
+	err = node.shell.FilesRm(ctx, record_path, true)
 	if err != nil {
-		return err
+		return record, err
 	}
 
-	err = shell.FilesRm(ctx, article_path, true)
+	err = node.shell.FilesRm(ctx, article_path, true)
 	if err != nil {
-		return err
+		return record, err
 	}
 
+	node.unpinArticle(ctx, name, append(record.Attachments, record.CID))
+
 	log.Printf("removed article: %s\n", name)
+	node.events.Publish(&Event{Type: EventArticleRemoved, Section: sectionForDirectory(CuratedDir), Record: record, Metadata: metadata})
 
-	return RefreshArticleIndex()
+	return record, node.RefreshArticleIndex(ctx, actor)
 }
 
 //
@@ -321,22 +500,22 @@ func NewArticleIndex() *ArticleIndex {
 	return &ArticleIndex{CuratedArticles: []*ArticleIndexItem{}, PublishedArticles: []*ArticleIndexItem{}}
 }
 
-func GenerateArticleIndex() (*ArticleIndex, error) {
+func (node *Node) GenerateArticleIndex(ctx context.Context) (*ArticleIndex, error) {
 
 	index := NewArticleIndex()
 
 	paths := []string{CuratedDir, PublishedDir}
 	for _, directory := range paths {
-		names, err := listArticles(directory)
+		names, err := node.listArticles(ctx, directory)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, name := range names {
 
-			article, err := GetArticleByMFSPath(path.Join(directory, name))
+			article, err := node.GetArticleByMFSPath(ctx, path.Join(directory, name))
 			if err != nil {
-				if err.Error() == "files/read: file does not exist" {
+				if errors.Is(err, ErrRecordNotFound) {
 					// record does not exist for a published article (ie. is hasn't been signed yet)
 					continue
 				} else {
@@ -357,18 +536,18 @@ func GenerateArticleIndex() (*ArticleIndex, error) {
 	return index, nil
 }
 
-func LoadArticleIndex() (*ArticleIndex, error) {
+func (node *Node) LoadArticleIndex(ctx context.Context) (*ArticleIndex, error) {
 	fmt.Printf("loading article index\n")
 	index := NewArticleIndex()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
 	defer cancel()
 	fmt.Printf("reading article index\n")
-	content, err := shell.FilesRead(ctx, IndexFile)
+	content, err := node.shell.FilesRead(ctx, IndexFile)
 	fmt.Printf("read article index\n")
 
 	if err != nil {
-		if err.Error() == "files/read: file does not exist" {
+		if ipfsNotExist(err) {
 			return index, nil
 		} else {
 			return index, err
@@ -384,9 +563,9 @@ func LoadArticleIndex() (*ArticleIndex, error) {
 	return index, nil
 }
 
-func writeArticleIndex(index *ArticleIndex) error {
+func (node *Node) writeArticleIndex(ctx context.Context, index *ArticleIndex) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsCopyTimeout)
 	defer cancel()
 
 	output := new(bytes.Buffer)
@@ -395,7 +574,7 @@ func writeArticleIndex(index *ArticleIndex) error {
 		return errors.New("failed to encode article index: " + err.Error())
 	}
 
-	err = shell.FilesWrite(ctx, IndexFile, output, ipfs.FilesWrite.Create(true), ipfs.FilesWrite.Truncate(true))
+	err = node.shell.FilesWrite(ctx, IndexFile, output, ipfs.FilesWrite.Create(true), ipfs.FilesWrite.Truncate(true))
 	if err != nil {
 		return errors.New("failed to write article index: " + err.Error())
 	}
@@ -403,17 +582,19 @@ func writeArticleIndex(index *ArticleIndex) error {
 	return nil
 }
 
-func RefreshArticleIndex() error {
-	index, err := GenerateArticleIndex()
-	if err != nil {
-		return err
+func (node *Node) RefreshArticleIndex(ctx context.Context, actor *AuditActor) error {
+	index, err := node.GenerateArticleIndex(ctx)
+	if err == nil {
+		err = node.writeArticleIndex(ctx, index)
 	}
 
-	err = writeArticleIndex(index)
 	if err != nil {
+		node.audit(actor, "index_refresh", nil, "", err)
 		return err
 	}
 
+	node.audit(actor, "index_refresh", nil, fmt.Sprintf("%d curated, %d published", len(index.CuratedArticles), len(index.PublishedArticles)), nil)
+	node.events.Publish(&Event{Type: EventIndexRefreshed})
 	log.Println("refreshed article index")
 	return nil
 }
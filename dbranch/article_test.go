@@ -0,0 +1,35 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+const test_article = `{"metadata": {"type": "news", "title": "Title", "author": "Author"}, "contents": {"ops": [{"insert": "text\n"}]}}`
+
+func TestAddRecordRollback(t *testing.T) {
+	// a failure after the article is copied and pinned leaves nothing behind
+	for _, failing := range []string{"files/stat", "files/write"} {
+		t.Run(failing, func(t *testing.T) {
+			fake, shell := newFakeIpfs(t)
+			node := newTestNode(t, nil, shell)
+
+			fake.respond("cat", json.RawMessage(test_article))
+			fake.respond("files/stat", map[string]interface{}{"Hash": "QmArticle", "Size": 100, "CumulativeSize": 120})
+			fake.fail(failing, "ipfs failed")
+
+			record := &ArticleRecord{Name: "article", CID: "QmArticle"}
+			err := node.AddRecordToLocal(context.Background(), CuratedDir, record, true, nil)
+			if err == nil {
+				t.Fatal("expected curating to fail")
+			}
+
+			for _, call := range []string{"files/cp /ipfs/QmArticle /dBranch/curated/article", "pin/add QmArticle", "pin/rm /ipfs/QmArticle", "files/rm /dBranch/curated/article", "files/rm /dBranch/curated/article.json"} {
+				if !fake.called(call) {
+					t.Errorf("expected ipfs call: %s, got: %v", call, fake.calls)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package dbranch
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+//
+// article type registry
+//
+
+type ArticleType struct {
+	Name        string
+	Validate    func(contents map[string]interface{}) error // check contents conform to the type's schema
+	Render      func(article *Article) (string, error)      // render article as html
+	PlainText   func(article *Article) string               // text used for search and summaries
+	Attachments func(article *Article) []string             // links to media referenced by the article
+}
+
+var articleTypes = map[string]*ArticleType{}
+
+const QuarantineDir = "/dBranch/quarantine"
+
+func init() {
+	for _, name := range []string{"information", "news", "opinion"} {
+		RegisterArticleType(&ArticleType{
+			Name:        name,
+			Validate:    validateDeltaContents,
+			Render:      renderDeltaArticle,
+			PlainText:   deltaArticleText,
+			Attachments: deltaArticleAttachments,
+		})
+	}
+
+	RegisterArticleType(&ArticleType{
+		Name:        "audio",
+		Validate:    validateAudioContents,
+		Render:      renderAudioArticle,
+		PlainText:   audioArticleText,
+		Attachments: audioArticleAttachments,
+	})
+
+	RegisterArticleType(&ArticleType{
+		Name:        "photo_essay",
+		Validate:    validatePhotoEssayContents,
+		Render:      renderPhotoEssayArticle,
+		PlainText:   photoEssayArticleText,
+		Attachments: photoEssayArticleAttachments,
+	})
+}
+
+func RegisterArticleType(article_type *ArticleType) {
+	if article_type.Name == "" || article_type.Validate == nil || article_type.Render == nil || article_type.PlainText == nil || article_type.Attachments == nil {
+		panic("dbranch: incomplete article type registration: " + article_type.Name)
+	}
+	articleTypes[article_type.Name] = article_type
+}
+
+func LookupArticleType(name string) (*ArticleType, error) {
+	article_type, exists := articleTypes[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownArticleType, name)
+	}
+	return article_type, nil
+}
+
+func ListArticleTypes() []string {
+	names := []string{}
+	for name := range articleTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func RenderArticle(article *Article) (string, error) {
+	article_type, err := LookupArticleType(article.Metadata.Type)
+	if err != nil {
+		return "", err
+	}
+	return article_type.Render(article)
+}
+
+func ArticlePlainText(article *Article) (string, error) {
+	article_type, err := LookupArticleType(article.Metadata.Type)
+	if err != nil {
+		return "", err
+	}
+	return article_type.PlainText(article), nil
+}
+
+func ArticleAttachments(article *Article) ([]string, error) {
+	article_type, err := LookupArticleType(article.Metadata.Type)
+	if err != nil {
+		return nil, err
+	}
+	return article_type.Attachments(article), nil
+}
+
+func SummarizeText(text string, max_length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if max_length <= 0 || len(text) <= max_length {
+		return text
+	}
+
+	// cut at the last space before the limit to avoid splitting a word
+	cut := strings.LastIndex(text[:max_length], " ")
+	if cut <= 0 {
+		cut = max_length
+	}
+	return text[:cut] + "..."
+}
+
+func renderArticleHeader(article *Article) string {
+	header := "<h1>" + html.EscapeString(article.Metadata.Title) + "</h1>\n"
+	if article.Metadata.SubTitle != "" {
+		header += "<h2>" + html.EscapeString(article.Metadata.SubTitle) + "</h2>\n"
+	}
+	header += "<p class=\"author\">" + html.EscapeString(article.Metadata.Author) + "</p>\n"
+	return header
+}
+
+func articleHeaderText(article *Article) string {
+	return strings.TrimSpace(article.Metadata.Title + "\n" + article.Metadata.SubTitle)
+}
+
+// media links may point to ipfs, convert them to paths a local gateway can serve
+// anything but http, https and ipfs links is dropped, ie. javascript: and data: urls, since articles come from anyone
+func mediaSource(link string) string {
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "/ipfs/") {
+		return link
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return link
+	case "ipfs":
+		return "/ipfs/" + strings.TrimPrefix(link[len("ipfs:"):], "//")
+	}
+	return ""
+}
+
+//
+// delta articles: information, news, opinion
+//
+
+func validateDeltaContents(contents map[string]interface{}) error {
+	// contents are a quill delta: {"ops": [{"insert": ..., "attributes": {...}}, ...]}
+	ops, ok := contents["ops"].([]interface{})
+	if !ok {
+		return errors.New("contents.ops must be a list")
+	}
+
+	for index, op := range ops {
+		op_map, ok := op.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("contents.ops[%d] must be an object", index)
+		}
+
+		switch insert := op_map["insert"].(type) {
+		case string:
+		case map[string]interface{}:
+			if len(insert) == 0 {
+				return fmt.Errorf("contents.ops[%d].insert is an empty embed", index)
+			}
+		default:
+			return fmt.Errorf("contents.ops[%d].insert must be a string or embed object", index)
+		}
+
+		if attributes, exists := op_map["attributes"]; exists {
+			if _, ok := attributes.(map[string]interface{}); !ok {
+				return fmt.Errorf("contents.ops[%d].attributes must be an object", index)
+			}
+		}
+	}
+
+	return nil
+}
+
+func deltaOps(contents map[string]interface{}) []map[string]interface{} {
+	ops := []map[string]interface{}{}
+	raw_ops, _ := contents["ops"].([]interface{})
+	for _, op := range raw_ops {
+		if op_map, ok := op.(map[string]interface{}); ok {
+			ops = append(ops, op_map)
+		}
+	}
+	return ops
+}
+
+func renderDeltaInline(text string, attributes map[string]interface{}) string {
+	rendered := html.EscapeString(text)
+	if attributes["bold"] == true {
+		rendered = "<strong>" + rendered + "</strong>"
+	}
+	if attributes["italic"] == true {
+		rendered = "<em>" + rendered + "</em>"
+	}
+	if attributes["underline"] == true {
+		rendered = "<u>" + rendered + "</u>"
+	}
+	if link, ok := attributes["link"].(string); ok {
+		// links that aren't allowed by mediaSource are rendered as plain text
+		if href := mediaSource(link); href != "" {
+			rendered = "<a href=\"" + html.EscapeString(href) + "\">" + rendered + "</a>"
+		}
+	}
+	return rendered
+}
+
+func renderDeltaEmbed(embed map[string]interface{}) string {
+	if src, ok := embed["image"].(string); ok {
+		return "<img src=\"" + html.EscapeString(mediaSource(src)) + "\">"
+	}
+	if src, ok := embed["video"].(string); ok {
+		return "<video controls src=\"" + html.EscapeString(mediaSource(src)) + "\"></video>"
+	}
+	if src, ok := embed["audio"].(string); ok {
+		return "<audio controls src=\"" + html.EscapeString(mediaSource(src)) + "\"></audio>"
+	}
+	return ""
+}
+
+func renderDeltaLine(line string, attributes map[string]interface{}) string {
+	if level, ok := attributes["header"].(float64); ok && level >= 1 && level <= 6 {
+		return fmt.Sprintf("<h%d>%s</h%d>\n", int(level)+1, line, int(level)+1)
+	}
+	if _, ok := attributes["list"].(string); ok {
+		return "<li>" + line + "</li>\n"
+	}
+	if attributes["blockquote"] == true {
+		return "<blockquote>" + line + "</blockquote>\n"
+	}
+	if line == "" {
+		return ""
+	}
+	return "<p>" + line + "</p>\n"
+}
+
+func renderDeltaArticle(article *Article) (string, error) {
+	// quill applies block formats (headers, lists) to the newline that ends a line, so build up each line then wrap it
+	output := &strings.Builder{}
+	output.WriteString(renderArticleHeader(article))
+
+	line := ""
+	list := ""
+
+	closeList := func() {
+		if list != "" {
+			output.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+
+	for _, op := range deltaOps(article.Contents) {
+		attributes, _ := op["attributes"].(map[string]interface{})
+
+		switch insert := op["insert"].(type) {
+		case map[string]interface{}:
+			line += renderDeltaEmbed(insert)
+		case string:
+			parts := strings.Split(insert, "\n")
+			for index, part := range parts {
+				line += renderDeltaInline(part, attributes)
+				if index == len(parts)-1 {
+					break
+				}
+
+				// end of line, newline attributes format the line
+				list_type, is_list := attributes["list"].(string)
+				if is_list {
+					tag := "ul"
+					if list_type == "ordered" {
+						tag = "ol"
+					}
+					if list != tag {
+						closeList()
+						output.WriteString("<" + tag + ">\n")
+						list = tag
+					}
+				} else {
+					closeList()
+				}
+
+				output.WriteString(renderDeltaLine(line, attributes))
+				line = ""
+			}
+		}
+	}
+
+	closeList()
+	if line != "" {
+		output.WriteString(renderDeltaLine(line, nil))
+	}
+
+	return output.String(), nil
+}
+
+func deltaArticleText(article *Article) string {
+	text := &strings.Builder{}
+	text.WriteString(articleHeaderText(article))
+	text.WriteString("\n")
+	for _, op := range deltaOps(article.Contents) {
+		if insert, ok := op["insert"].(string); ok {
+			text.WriteString(insert)
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+func deltaArticleAttachments(article *Article) []string {
+	links := []string{}
+	for _, op := range deltaOps(article.Contents) {
+		if embed, ok := op["insert"].(map[string]interface{}); ok {
+			for _, key := range []string{"image", "video", "audio"} {
+				if src, ok := embed[key].(string); ok {
+					links = append(links, src)
+				}
+			}
+		}
+		if attributes, ok := op["attributes"].(map[string]interface{}); ok {
+			if link, ok := attributes["link"].(string); ok && strings.HasPrefix(link, "ipfs://") {
+				links = append(links, link)
+			}
+		}
+	}
+	return links
+}
+
+//
+// audio articles
+//
+
+func validateAudioContents(contents map[string]interface{}) error {
+	// contents: {"audio": "ipfs://...", "description": "...", "transcript": "..."}
+	audio, ok := contents["audio"].(string)
+	if !ok || audio == "" {
+		return errors.New("contents.audio must be a non empty string")
+	}
+
+	for _, key := range []string{"description", "transcript"} {
+		if value, exists := contents[key]; exists {
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("contents.%s must be a string", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func renderAudioArticle(article *Article) (string, error) {
+	audio, _ := article.Contents["audio"].(string)
+	description, _ := article.Contents["description"].(string)
+	transcript, _ := article.Contents["transcript"].(string)
+
+	output := renderArticleHeader(article)
+	output += "<audio controls src=\"" + html.EscapeString(mediaSource(audio)) + "\"></audio>\n"
+	if description != "" {
+		output += "<p>" + html.EscapeString(description) + "</p>\n"
+	}
+	if transcript != "" {
+		output += "<pre class=\"transcript\">" + html.EscapeString(transcript) + "</pre>\n"
+	}
+	return output, nil
+}
+
+func audioArticleText(article *Article) string {
+	description, _ := article.Contents["description"].(string)
+	transcript, _ := article.Contents["transcript"].(string)
+	return strings.TrimSpace(articleHeaderText(article) + "\n" + description + "\n" + transcript)
+}
+
+func audioArticleAttachments(article *Article) []string {
+	audio, _ := article.Contents["audio"].(string)
+	return []string{audio}
+}
+
+//
+// photo essay articles
+//
+
+func photoEssayPhotos(contents map[string]interface{}) []map[string]interface{} {
+	photos := []map[string]interface{}{}
+	raw_photos, _ := contents["photos"].([]interface{})
+	for _, photo := range raw_photos {
+		if photo_map, ok := photo.(map[string]interface{}); ok {
+			photos = append(photos, photo_map)
+		}
+	}
+	return photos
+}
+
+func validatePhotoEssayContents(contents map[string]interface{}) error {
+	// contents: {"introduction": "...", "photos": [{"src": "ipfs://...", "caption": "..."}, ...]}
+	raw_photos, ok := contents["photos"].([]interface{})
+	if !ok || len(raw_photos) == 0 {
+		return errors.New("contents.photos must be a non empty list")
+	}
+
+	for index, photo := range raw_photos {
+		photo_map, ok := photo.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("contents.photos[%d] must be an object", index)
+		}
+
+		src, ok := photo_map["src"].(string)
+		if !ok || src == "" {
+			return fmt.Errorf("contents.photos[%d].src must be a non empty string", index)
+		}
+
+		if caption, exists := photo_map["caption"]; exists {
+			if _, ok := caption.(string); !ok {
+				return fmt.Errorf("contents.photos[%d].caption must be a string", index)
+			}
+		}
+	}
+
+	if introduction, exists := contents["introduction"]; exists {
+		if _, ok := introduction.(string); !ok {
+			return errors.New("contents.introduction must be a string")
+		}
+	}
+
+	return nil
+}
+
+func renderPhotoEssayArticle(article *Article) (string, error) {
+	output := renderArticleHeader(article)
+
+	if introduction, ok := article.Contents["introduction"].(string); ok && introduction != "" {
+		output += "<p>" + html.EscapeString(introduction) + "</p>\n"
+	}
+
+	for _, photo := range photoEssayPhotos(article.Contents) {
+		src, _ := photo["src"].(string)
+		caption, _ := photo["caption"].(string)
+		output += "<figure><img src=\"" + html.EscapeString(mediaSource(src)) + "\">"
+		if caption != "" {
+			output += "<figcaption>" + html.EscapeString(caption) + "</figcaption>"
+		}
+		output += "</figure>\n"
+	}
+
+	return output, nil
+}
+
+func photoEssayArticleText(article *Article) string {
+	text := []string{articleHeaderText(article)}
+	if introduction, ok := article.Contents["introduction"].(string); ok {
+		text = append(text, introduction)
+	}
+	for _, photo := range photoEssayPhotos(article.Contents) {
+		if caption, ok := photo["caption"].(string); ok {
+			text = append(text, caption)
+		}
+	}
+	return strings.TrimSpace(strings.Join(text, "\n"))
+}
+
+func photoEssayArticleAttachments(article *Article) []string {
+	links := []string{}
+	for _, photo := range photoEssayPhotos(article.Contents) {
+		if src, ok := photo["src"].(string); ok {
+			links = append(links, src)
+		}
+	}
+	return links
+}
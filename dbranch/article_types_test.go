@@ -0,0 +1,74 @@
+package dbranch
+
+import (
+	"strings"
+	"testing"
+)
+
+func deltaArticle(ops ...interface{}) *Article {
+	return &Article{
+		Metadata: &ArticleMetadata{Type: "news", Title: "Title", Author: "Author"},
+		Contents: map[string]interface{}{"ops": ops},
+	}
+}
+
+func linkOp(text, link string) map[string]interface{} {
+	return map[string]interface{}{"insert": text, "attributes": map[string]interface{}{"link": link}}
+}
+
+func TestRenderDeltaLinks(t *testing.T) {
+	tests := []struct {
+		link string
+		href string // "" when the text must be rendered without a link
+	}{
+		{"https://example.com/a?b=1&c=2", "https://example.com/a?b=1&amp;c=2"},
+		{"http://example.com", "http://example.com"},
+		{"ipfs://QmTest/image.png", "/ipfs/QmTest/image.png"},
+		{"/ipfs/QmTest", "/ipfs/QmTest"},
+		{"javascript:alert(1)", ""},
+		{"JavaScript:alert(1)", ""},
+		{" javascript:alert(1)", ""},
+		{"java\tscript:alert(1)", ""},
+		{"data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==", ""},
+		{"vbscript:msgbox", ""},
+		{"ipfs:", "/ipfs/"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		rendered, err := RenderArticle(deltaArticle(linkOp("click <here>", test.link), map[string]interface{}{"insert": "\n"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(rendered, "click &lt;here&gt;") {
+			t.Errorf("%q: link text missing or unescaped: %s", test.link, rendered)
+		}
+		if test.href == "" {
+			if strings.Contains(rendered, "<a") {
+				t.Errorf("%q: rendered as a link: %s", test.link, rendered)
+			}
+		} else if !strings.Contains(rendered, `<a href="`+test.href+`">`) {
+			t.Errorf("%q: expected href %q: %s", test.link, test.href, rendered)
+		}
+	}
+}
+
+func TestRenderDeltaEmbeds(t *testing.T) {
+	rendered, err := RenderArticle(deltaArticle(
+		map[string]interface{}{"insert": map[string]interface{}{"image": "javascript:alert(1)"}},
+		map[string]interface{}{"insert": map[string]interface{}{"video": "data:video/mp4;base64,AAAA"}},
+		map[string]interface{}{"insert": map[string]interface{}{"image": "ipfs://QmImage"}},
+		map[string]interface{}{"insert": "\n"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(rendered, "javascript:") || strings.Contains(rendered, "data:") {
+		t.Errorf("unsafe source rendered: %s", rendered)
+	}
+	if !strings.Contains(rendered, `<img src="/ipfs/QmImage">`) {
+		t.Errorf("ipfs image missing: %s", rendered)
+	}
+}
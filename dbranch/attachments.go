@@ -0,0 +1,194 @@
+package dbranch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+)
+
+// media referenced by curated articles is copied into a folder per article under this dir
+const AttachmentsDir = "/dBranch/attachments"
+
+func attachmentsPath(name string) string {
+	return path.Join(AttachmentsDir, name)
+}
+
+func ipfsReference(value string) (string, bool) {
+	// returns the cid (and optional sub path) of an ipfs:// or /ipfs/ link
+	if strings.HasPrefix(value, "ipfs://") {
+		return strings.TrimPrefix(value, "ipfs://"), true
+	}
+	if strings.HasPrefix(value, "/ipfs/") {
+		return strings.TrimPrefix(value, "/ipfs/"), true
+	}
+	return "", false
+}
+
+// keys of embeds and link attributes, ipfs links anywhere else, ie. in text inserts or captions, are not pinned
+var attachment_keys = map[string]bool{"image": true, "video": true, "audio": true, "src": true, "link": true}
+
+func findIpfsReferences(value interface{}, refs []string) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if link, ok := item.(string); ok {
+				if ref, ok := ipfsReference(link); ok && ref != "" && attachment_keys[key] {
+					refs = append(refs, ref)
+				}
+				continue
+			}
+			refs = findIpfsReferences(item, refs)
+		}
+	case []interface{}:
+		for _, item := range value {
+			refs = findIpfsReferences(item, refs)
+		}
+	}
+	return refs
+}
+
+func ListArticleReferences(article *Article) []string {
+	// combine links found by walking the contents with those declared by the article type
+	refs := findIpfsReferences(article.Contents, []string{})
+
+	if article_type, err := LookupArticleType(article.Metadata.Type); err == nil {
+		for _, link := range article_type.Attachments(article) {
+			if ref, ok := ipfsReference(link); ok && ref != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if !seen[ref] {
+			seen[ref] = true
+			unique = append(unique, ref)
+		}
+	}
+
+	return unique
+}
+
+func (node *Node) pinAttachments(ctx context.Context, name string, article *Article) ([]string, uint64, error) {
+	// copy each referenced cid into the article's attachments folder and pin it, returns pinned paths and their total dag size
+	pinned := []string{}
+	var size uint64
+
+	refs := ListArticleReferences(article)
+	if len(refs) == 0 {
+		return pinned, 0, nil
+	}
+
+	for _, ref := range refs {
+		entry, err := node.IsBlocked(BlockCID, strings.SplitN(ref, "/", 2)[0])
+		if err != nil {
+			return pinned, size, err
+		}
+		if entry != nil {
+			return pinned, size, blockedError(entry)
+		}
+	}
+
+	// sizes are checked before anything is copied so large attachments aren't fetched
+	var total uint64
+	for _, ref := range refs {
+		stat, err := node.statIpfsPath(ctx, path.Join("/ipfs", ref))
+		if err != nil {
+			return pinned, size, err
+		}
+		total += stat.CumulativeSize
+		if total > uint64(node.config.MaxAttachmentsSize) {
+			return pinned, size, fmt.Errorf("%w: attachments exceed max size of %d bytes", ErrInvalidArticle, node.config.MaxAttachmentsSize)
+		}
+	}
+
+	// created once the checks pass so a refused article doesn't leave an empty folder
+	folder := attachmentsPath(name)
+	err := node.shell.FilesMkdir(ctx, folder, ipfs.FilesMkdir.Parents(true))
+	if err != nil {
+		return pinned, 0, err
+	}
+
+	for _, ref := range refs {
+		ipfs_source := path.Join("/ipfs", ref)
+		attachment_path := path.Join(folder, strings.ReplaceAll(ref, "/", "_"))
+
+		err = node.shell.FilesCp(ctx, ipfs_source, attachment_path)
+		if err != nil {
+			return pinned, size, err
+		}
+
+		err = node.ipfsPin(ctx, ipfs_source)
+		if err != nil {
+			return pinned, size, err
+		}
+		pinned = append(pinned, ref)
+
+		stat, err := node.shell.FilesStat(ctx, attachment_path)
+		if err != nil {
+			return pinned, size, err
+		}
+		size += stat.CumulativeSize
+
+		log.Printf("pinned attachment: %s to: %s\n", ipfs_source, attachment_path)
+	}
+
+	return pinned, size, nil
+}
+
+func (node *Node) referencedElsewhere(ctx context.Context, name string) map[string]bool {
+	// cids pinned for other articles in the index, these must stay pinned when an article is removed
+	referenced := map[string]bool{}
+
+	index, err := node.LoadArticleIndex(ctx)
+	if err != nil {
+		log.Printf("could not load article index to check shared pins: %s\n", err)
+		return referenced
+	}
+
+	for _, item := range append(index.CuratedArticles, index.PublishedArticles...) {
+		if item.Record.Name == name {
+			continue
+		}
+		referenced[item.Record.CID] = true
+		for _, ref := range item.Record.Attachments {
+			referenced[ref] = true
+		}
+	}
+
+	return referenced
+}
+
+func (node *Node) unpinArticle(ctx context.Context, name string, cids []string) {
+	referenced := node.referencedElsewhere(ctx, name)
+
+	for _, cid := range cids {
+		if referenced[cid] {
+			log.Printf("not unpinning: %s, it is referenced by another article\n", cid)
+			continue
+		}
+
+		err := node.ipfsUnpin(ctx, path.Join("/ipfs", cid))
+		if err != nil {
+			log.Printf("could not unpin: %s: %s\n", cid, err)
+		} else {
+			log.Printf("unpinned: %s\n", cid)
+		}
+
+		err = node.UnreplicateCID(ctx, cid)
+		if err != nil {
+			log.Printf("could not remove replicas of: %s: %s\n", cid, err)
+		}
+	}
+
+	err := node.shell.FilesRm(ctx, attachmentsPath(name), true)
+	if err != nil && !ipfsNotExist(err) {
+		log.Printf("could not remove attachments folder for: %s: %s\n", name, err)
+	}
+}
@@ -0,0 +1,102 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestListArticleReferences(t *testing.T) {
+	article := deltaArticle(
+		map[string]interface{}{"insert": "see ipfs://QmText or /ipfs/QmText2\n"},
+		map[string]interface{}{"insert": map[string]interface{}{"image": "ipfs://QmImage"}},
+		map[string]interface{}{"insert": map[string]interface{}{"video": "/ipfs/QmVideo/clip.mp4"}},
+		linkOp("a link", "ipfs://QmLink"),
+		linkOp("a web link", "https://example.com"),
+		map[string]interface{}{"insert": "caption", "attributes": map[string]interface{}{"alt": "ipfs://QmAlt"}},
+		map[string]interface{}{"insert": map[string]interface{}{"image": "ipfs://QmImage"}},
+	)
+
+	refs := ListArticleReferences(article)
+	sort.Strings(refs)
+	expected := []string{"QmImage", "QmLink", "QmVideo/clip.mp4"}
+	if len(refs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, refs)
+	}
+	for i := range expected {
+		if refs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, refs)
+		}
+	}
+
+	// photo essay captions aren't attachments either
+	essay := &Article{
+		Metadata: &ArticleMetadata{Type: "photo_essay", Title: "Title", Author: "Author"},
+		Contents: map[string]interface{}{
+			"introduction": "ipfs://QmIntro",
+			"photos":       []interface{}{map[string]interface{}{"src": "ipfs://QmPhoto", "caption": "/ipfs/QmCaption"}},
+		},
+	}
+	refs = ListArticleReferences(essay)
+	if len(refs) != 1 || refs[0] != "QmPhoto" {
+		t.Errorf("expected [QmPhoto], got %v", refs)
+	}
+}
+
+func TestPinAttachmentsChecks(t *testing.T) {
+	article := deltaArticle(map[string]interface{}{"insert": map[string]interface{}{"image": "ipfs://QmImage"}})
+
+	t.Run("oversize", func(t *testing.T) {
+		fake, shell := newFakeIpfs(t)
+		config := DefaultConfig()
+		config.MaxAttachmentsSize = 1000
+		node := newTestNode(t, config, shell)
+		fake.respond("files/stat", map[string]interface{}{"Hash": "QmImage", "CumulativeSize": 1001})
+
+		_, _, err := node.pinAttachments(context.Background(), "article", article)
+		if !errors.Is(err, ErrInvalidArticle) {
+			t.Errorf("expected an oversize error, got: %v", err)
+		}
+		if fake.called("files/mkdir /dBranch/attachments/article") || fake.called("files/cp /ipfs/QmImage /dBranch/attachments/article/QmImage") {
+			t.Errorf("attachments written for an oversize article: %v", fake.calls)
+		}
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		fake, shell := newFakeIpfs(t)
+		node := newTestNode(t, nil, shell)
+		err := node.AddBlock(&BlockEntry{Kind: BlockCID, Value: "QmImage"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = node.pinAttachments(context.Background(), "article", article)
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("expected a blocked error, got: %v", err)
+		}
+		if len(fake.calls) != 0 {
+			t.Errorf("ipfs called for a blocked attachment: %v", fake.calls)
+		}
+	})
+}
+
+func TestAddRecordSize(t *testing.T) {
+	fake, shell := newFakeIpfs(t)
+	node := newTestNode(t, nil, shell)
+
+	fake.respond("cat", json.RawMessage(`{"metadata": {"type": "news", "title": "Title", "author": "Author"}, "contents": {"ops": [{"insert": {"image": "ipfs://QmImage"}}]}}`))
+	fake.respond("files/stat", map[string]interface{}{"Hash": "QmStat", "Size": 100, "CumulativeSize": 120})
+
+	record := &ArticleRecord{Name: "article", CID: "QmArticle"}
+	err := node.AddRecordToLocal(context.Background(), CuratedDir, record, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the article and its attachment are both measured by dag size
+	if record.Size != 240 || len(record.Attachments) != 1 {
+		t.Errorf("expected a size of 240 with 1 attachment, got %d with %v", record.Size, record.Attachments)
+	}
+}
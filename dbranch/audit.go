@@ -0,0 +1,272 @@
+package dbranch
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+//
+// append-only audit log of curation actions, stored as json lines
+//
+
+type AuditEntry struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"` // ex: curate, publish, remove, sign, policy, stage, approve, reject, block, index_refresh
+	Actor    string    `json:"actor"`
+	Source   string    `json:"source"` // daemon, cli or api
+	Name     string    `json:"name,omitempty"`
+	CID      string    `json:"cid,omitempty"`
+	TxHash   string    `json:"tx_hash,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	PrevHash string    `json:"prev_hash,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+type AuditActor struct {
+	Name   string
+	Source string
+}
+
+func (node *Node) auditLogPath() string {
+	return path.Join(node.config.Dir, "audit.jsonl")
+}
+
+func hashAuditEntry(entry AuditEntry) string {
+	// hash is computed over the entry with the hash field empty, prev hash is included to chain entries
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func lastAuditEntry(file *os.File) (*AuditEntry, error) {
+	// read backwards from the end of the file until a complete line is found
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := stat.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	chunk := int64(4096)
+	for {
+		if chunk > size {
+			chunk = size
+		}
+
+		buffer := make([]byte, chunk)
+		_, err = file.ReadAt(buffer, size-chunk)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		buffer = bytes.TrimRight(buffer, "\n")
+		start := bytes.LastIndexByte(buffer, '\n')
+		if start >= 0 || chunk == size {
+			entry := &AuditEntry{}
+			err = json.Unmarshal(buffer[start+1:], entry)
+			if err != nil {
+				return nil, errors.New("error decoding last audit entry: " + err.Error())
+			}
+			return entry, nil
+		}
+
+		chunk *= 2
+	}
+}
+
+func (node *Node) writeAuditEntry(entry *AuditEntry) error {
+	node.audit_lock.Lock()
+	defer node.audit_lock.Unlock()
+
+	file, err := os.OpenFile(node.auditLogPath(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	last, err := lastAuditEntry(file)
+	if err != nil {
+		return err
+	}
+
+	entry.Seq = 1
+	if last != nil {
+		entry.Seq = last.Seq + 1
+		if node.config.AuditHashChain {
+			entry.PrevHash = last.Hash
+		}
+	}
+
+	if node.config.AuditHashChain {
+		entry.Hash = hashAuditEntry(*entry)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+func (node *Node) audit(actor *AuditActor, action string, record *ArticleRecord, detail string, action_err error) {
+	// audit failures are logged but never fail the action being audited
+	if actor == nil {
+		actor = &node.default_actor
+	}
+
+	entry := &AuditEntry{
+		Time:   time.Now().UTC(),
+		Action: action,
+		Actor:  actor.Name,
+		Source: actor.Source,
+		Detail: detail,
+	}
+
+	if record != nil {
+		entry.Name = record.Name
+		entry.CID = record.CID
+		entry.TxHash = record.CardanoTxHash
+	}
+
+	if action_err != nil {
+		entry.Error = action_err.Error()
+	}
+
+	err := node.writeAuditEntry(entry)
+	if err != nil {
+		log.Printf("could not write audit entry: %s\n", err)
+	}
+}
+
+//
+// reading the log
+//
+
+type AuditQuery struct {
+	Action string
+	Actor  string
+	Source string
+	Name   string
+	CID    string
+	TxHash string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // return at most this many of the most recent matches, 0 for all
+}
+
+func (query *AuditQuery) match(entry *AuditEntry) bool {
+	return (query.Action == "" || query.Action == entry.Action) &&
+		(query.Actor == "" || query.Actor == entry.Actor) &&
+		(query.Source == "" || query.Source == entry.Source) &&
+		(query.Name == "" || query.Name == entry.Name) &&
+		(query.CID == "" || query.CID == entry.CID) &&
+		(query.TxHash == "" || query.TxHash == entry.TxHash) &&
+		(query.Since.IsZero() || !entry.Time.Before(query.Since)) &&
+		(query.Until.IsZero() || entry.Time.Before(query.Until))
+}
+
+func (node *Node) readAuditLog(handle func(entry *AuditEntry) error) error {
+	file, err := os.Open(node.auditLogPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := &AuditEntry{}
+		err = json.Unmarshal(scanner.Bytes(), entry)
+		if err != nil {
+			return errors.New("error decoding audit log: " + err.Error())
+		}
+
+		err = handle(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (node *Node) QueryAuditLog(query *AuditQuery) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+
+	err := node.readAuditLog(func(entry *AuditEntry) error {
+		if query.match(entry) {
+			entries = append(entries, entry)
+			if query.Limit > 0 && len(entries) > query.Limit {
+				entries = entries[1:]
+			}
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+func (node *Node) VerifyAuditLog() (int, error) {
+	/* check the hash chain, returns the number of entries verified or an error describing the first broken entry */
+	count := 0
+	prev_hash := ""
+	chained := false
+
+	err := node.readAuditLog(func(entry *AuditEntry) error {
+		count++
+
+		if entry.Hash == "" {
+			// only entries written before the hash chain was enabled may be unhashed, otherwise
+			// entries could be inserted or edited by leaving out their hash
+			if chained {
+				return fmt.Errorf("audit entry %d: not hashed but follows hashed entries", entry.Seq)
+			}
+			return nil
+		}
+		chained = true
+
+		if entry.PrevHash != prev_hash {
+			return fmt.Errorf("audit entry %d: prev_hash does not match the previous entry", entry.Seq)
+		}
+
+		if hashAuditEntry(*entry) != entry.Hash {
+			return fmt.Errorf("audit entry %d: hash does not match its contents", entry.Seq)
+		}
+
+		prev_hash = entry.Hash
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,75 @@
+package dbranch
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeTestAuditEntries(t *testing.T, node *Node, actions ...string) {
+	t.Helper()
+	for _, action := range actions {
+		err := node.writeAuditEntry(&AuditEntry{Action: action, Actor: "test", Source: "cli"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	config := DefaultConfig()
+	config.AuditHashChain = false
+	node := newTestNode(t, config)
+
+	// entries from before the hash chain was enabled are accepted
+	writeTestAuditEntries(t, node, "curate", "publish")
+	node.config.AuditHashChain = true
+	writeTestAuditEntries(t, node, "remove", "sign", "block")
+
+	count, err := node.VerifyAuditLog()
+	if err != nil || count != 5 {
+		t.Fatalf("expected 5 verified entries, got %d: %v", count, err)
+	}
+
+	// the chain can't be escaped by leaving out hashes once it started
+	node.config.AuditHashChain = false
+	writeTestAuditEntries(t, node, "curate")
+	_, err = node.VerifyAuditLog()
+	if err == nil || !strings.Contains(err.Error(), "audit entry 6: not hashed") {
+		t.Errorf("expected an unhashed entry after hashed ones to fail, got: %v", err)
+	}
+}
+
+func TestVerifyAuditLogTampered(t *testing.T) {
+	node := newTestNode(t, nil)
+	writeTestAuditEntries(t, node, "curate", "publish", "remove")
+
+	data, err := os.ReadFile(node.auditLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	tests := []struct {
+		name     string
+		log      string
+		expected string
+	}{
+		{"edited", lines[0] + strings.Replace(lines[1], `"publish"`, `"curate"`, 1) + lines[2], "audit entry 2: hash does not match"},
+		{"removed", lines[0] + lines[2], "audit entry 3: prev_hash does not match"},
+		{"hash stripped", lines[0] + lines[1] + strings.Split(lines[2], `,"prev_hash"`)[0] + "}\n", "audit entry 3: not hashed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := os.WriteFile(node.auditLogPath(), []byte(test.log), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = node.VerifyAuditLog()
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected %q, got: %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package dbranch
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+//
+// admin api keys and tokens
+//
+
+const (
+	RoleModerator = "moderator" // review the moderation queue and manage blocklists
+	RoleOperator  = "operator"  // curate, publish and remove articles and refresh the index
+	RoleEditor    = "editor"    // sign and publish articles with the node's cardano wallet
+)
+
+type AdminKey struct {
+	Name    string   `json:"name"`               // recorded as the actor in the audit log for actions made with this key
+	Key     string   `json:"key,omitempty"`      // plain text key, prefer key_hash
+	KeyHash string   `json:"key_hash,omitempty"` // hex sha256 of the key
+	Roles   []string `json:"roles,omitempty"`    // keys without roles are moderators
+}
+
+type AdminClaims struct {
+	Roles   []string `json:"roles"`
+	Address string   `json:"address,omitempty"` // set for sessions from a wallet sign in
+	jwt.StandardClaims
+}
+
+func (node *Node) adminKeysPath() string {
+	return node.statePath(node.config.AdminKeysFile, "admin_keys.json")
+}
+
+func (node *Node) jwtSecretPath() string {
+	return node.statePath(node.config.JWTSecretFile, "jwt_secret")
+}
+
+func validRoles(roles []string) error {
+	for _, role := range roles {
+		if role != RoleModerator && role != RoleOperator && role != RoleEditor {
+			return invalidInput("invalid role: %s, must be one of: moderator, operator, editor", role)
+		}
+	}
+	return nil
+}
+
+func hashAdminKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (admin_key *AdminKey) matches(key string) bool {
+	if admin_key.KeyHash != "" {
+		return subtle.ConstantTimeCompare([]byte(admin_key.KeyHash), []byte(hashAdminKey(key))) == 1
+	}
+	return admin_key.Key != "" && subtle.ConstantTimeCompare([]byte(admin_key.Key), []byte(key)) == 1
+}
+
+func (admin_key *AdminKey) roles() []string {
+	if len(admin_key.Roles) == 0 {
+		return []string{RoleModerator}
+	}
+	return admin_key.Roles
+}
+
+func (node *Node) loadAdminKeys() ([]AdminKey, error) {
+	keys := []AdminKey{}
+
+	data, err := os.ReadFile(node.adminKeysPath())
+	if os.IsNotExist(err) {
+		return keys, nil
+	} else if err != nil {
+		return keys, err
+	}
+
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return keys, errors.New("error decoding admin keys file: " + err.Error())
+	}
+
+	return keys, nil
+}
+
+func (node *Node) saveAdminKeys(keys []AdminKey) error {
+	data, err := json.MarshalIndent(keys, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(node.adminKeysPath(), data, 0600)
+}
+
+func (node *Node) ListAdminKeys() ([]AdminKey, error) {
+	/* list admin keys with the key values removed */
+	keys, err := node.loadAdminKeys()
+	for index := range keys {
+		keys[index].Key = ""
+		keys[index].KeyHash = ""
+		keys[index].Roles = keys[index].roles()
+	}
+	return keys, err
+}
+
+func randomSecret(size int) (string, error) {
+	buffer := make([]byte, size)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+func (node *Node) AddAdminKey(name string, roles []string) (string, error) {
+	/* generate a new key for name, only the hash is stored so the returned key can't be shown again */
+	if name == "" {
+		return "", invalidInput("missing key name")
+	}
+
+	err := validRoles(roles)
+	if err != nil {
+		return "", err
+	}
+
+	node.admin_keys_lock.Lock()
+	defer node.admin_keys_lock.Unlock()
+
+	keys, err := node.loadAdminKeys()
+	if err != nil {
+		return "", err
+	}
+
+	for _, admin_key := range keys {
+		if admin_key.Name == name {
+			return "", fmt.Errorf("admin key %w: %s", ErrAlreadyExists, name)
+		}
+	}
+
+	key, err := randomSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	keys = append(keys, AdminKey{Name: name, KeyHash: hashAdminKey(key), Roles: roles})
+	return key, node.saveAdminKeys(keys)
+}
+
+func (node *Node) RemoveAdminKey(name string) error {
+	node.admin_keys_lock.Lock()
+	defer node.admin_keys_lock.Unlock()
+
+	keys, err := node.loadAdminKeys()
+	if err != nil {
+		return err
+	}
+
+	remaining := []AdminKey{}
+	for _, admin_key := range keys {
+		if admin_key.Name != name {
+			remaining = append(remaining, admin_key)
+		}
+	}
+
+	if len(remaining) == len(keys) {
+		return fmt.Errorf("admin key %w: %s", ErrNotFound, name)
+	}
+
+	return node.saveAdminKeys(remaining)
+}
+
+//
+// jwt
+//
+
+func (node *Node) loadJWTSecret(create bool) ([]byte, error) {
+	secret, err := os.ReadFile(node.jwtSecretPath())
+	if os.IsNotExist(err) && create {
+		generated, err := randomSecret(32)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(generated), os.WriteFile(node.jwtSecretPath(), []byte(generated), 0600)
+	} else if err != nil {
+		return nil, err
+	}
+
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) == 0 {
+		return nil, errors.New("jwt secret is empty: " + node.jwtSecretPath())
+	}
+	return secret, nil
+}
+
+func (node *Node) IssueAdminToken(name string, roles []string, ttl time.Duration) (string, error) {
+	/* sign a jwt for name with roles, the signing secret is created on first use */
+	if name == "" {
+		return "", invalidInput("missing token subject")
+	}
+
+	err := validRoles(roles)
+	if err != nil {
+		return "", err
+	}
+
+	return node.signAdminClaims(newAdminClaims(name, roles, ttl))
+}
+
+func newAdminClaims(name string, roles []string, ttl time.Duration) *AdminClaims {
+	now := time.Now()
+	return &AdminClaims{
+		Roles: roles,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   name,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+}
+
+func (node *Node) signAdminClaims(claims *AdminClaims) (string, error) {
+	secret, err := node.loadJWTSecret(true)
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+func (node *Node) parseAdminToken(token string) (*AdminClaims, error) {
+	secret, err := node.loadJWTSecret(false)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &AdminClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(parsed *jwt.Token) (interface{}, error) {
+		if parsed.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method: " + parsed.Method.Alg())
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" || claims.ExpiresAt == 0 {
+		return nil, errors.New("token is missing subject or expiry")
+	}
+
+	// roles for wallet sessions come from the current config so removing an admin takes effect right away
+	if claims.Address != "" {
+		address, err := decodeCardanoAddress(claims.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		admin, err := node.walletAdmin(address)
+		if err != nil {
+			return nil, err
+		}
+
+		claims.Roles = []string{}
+		if admin != nil {
+			claims.Roles = admin.Roles
+		}
+	}
+
+	return claims, nil
+}
+
+//
+// middleware
+//
+
+func (node *Node) adminAuth() echo.MiddlewareFunc {
+	// expects header: Authorization: Bearer <key or jwt>
+	return middleware.KeyAuth(func(key string, e echo.Context) (bool, error) {
+		// jwts have three dot separated parts, keys never contain dots
+		if strings.Count(key, ".") == 2 {
+			claims, err := node.parseAdminToken(key)
+			if err != nil {
+				e.Logger().Warn("invalid admin token: " + err.Error())
+				return false, nil
+			}
+			if len(claims.Roles) == 0 {
+				// a session without roles is a regular user
+				return false, nil
+			}
+			setClaims(e, claims)
+			return true, nil
+		}
+
+		keys, err := node.loadAdminKeys()
+		if err != nil {
+			return false, err
+		}
+
+		for _, admin_key := range keys {
+			if admin_key.matches(key) {
+				e.Set("actor", admin_key.Name)
+				e.Set("roles", admin_key.roles())
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+}
+
+func (node *Node) sessionAuth() echo.MiddlewareFunc {
+	// any valid token, including wallet sessions without roles, expects header: Authorization: Bearer <jwt>
+	return middleware.KeyAuth(func(key string, e echo.Context) (bool, error) {
+		claims, err := node.parseAdminToken(key)
+		if err != nil {
+			return false, nil
+		}
+		setClaims(e, claims)
+		return true, nil
+	})
+}
+
+func setClaims(e echo.Context, claims *AdminClaims) {
+	e.Set("actor", claims.Subject)
+	e.Set("roles", claims.Roles)
+	e.Set("claims", claims)
+}
+
+func requireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(e echo.Context) error {
+			roles, _ := e.Get("roles").([]string)
+			for _, granted := range roles {
+				if granted == role {
+					return next(e)
+				}
+			}
+			return e.JSON(http.StatusForbidden, &errorMsg{Error: "requires role: " + role, Code: CodeForbidden})
+		}
+	}
+}
+
+func requestActor(e echo.Context) *AuditActor {
+	name, _ := e.Get("actor").(string)
+	return &AuditActor{Name: name, Source: "api"}
+}
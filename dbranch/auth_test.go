@@ -0,0 +1,139 @@
+package dbranch
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const blocklist_target = "/api/v0/admin/blocklist" // requires the moderator role
+
+func TestAdminKeyHashed(t *testing.T) {
+	node := newTestNode(t, nil)
+	key, err := node.AddAdminKey("moderator", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(node.adminKeysPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), key) || !strings.Contains(string(data), hashAdminKey(key)) {
+		t.Errorf("expected only the key hash to be stored: %s", data)
+	}
+
+	keys, err := node.ListAdminKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].KeyHash != "" || keys[0].Roles[0] != RoleModerator {
+		t.Errorf("expected the key without its hash and with the default role, got: %+v", keys)
+	}
+
+	// plain text keys from before hashing still work
+	plain := AdminKey{Name: "plain", Key: "plain-key"}
+	if !plain.matches("plain-key") || plain.matches("other-key") || plain.matches("") {
+		t.Error("expected a plain text key to match only itself")
+	}
+}
+
+func TestAdminRoles(t *testing.T) {
+	node := newTestNode(t, nil)
+	server := node.newCuratorServer()
+
+	moderator, _ := node.AddAdminKey("moderator", []string{RoleModerator})
+	operator, _ := node.AddAdminKey("operator", []string{RoleOperator})
+	default_role, _ := node.AddAdminKey("default", nil)
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"role granted", moderator, http.StatusOK},
+		{"keys without roles are moderators", default_role, http.StatusOK},
+		{"role missing", operator, http.StatusForbidden},
+		{"unknown key", "not-a-key", http.StatusUnauthorized},
+		{"no key", "", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := apiRequest(t, server, http.MethodGet, blocklist_target, test.token, nil)
+			if response.Code != test.code {
+				t.Errorf("expected: %d, got %d: %s", test.code, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	node := newTestNode(t, nil)
+	server := node.newCuratorServer()
+
+	token, err := node.IssueAdminToken("moderator", []string{RoleModerator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := node.signAdminClaims(newAdminClaims("moderator", []string{RoleModerator}, -time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, err := node.IssueAdminToken("operator", []string{RoleOperator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"valid", token, http.StatusOK},
+		{"expired", expired, http.StatusUnauthorized},
+		{"tampered", token[:len(token)-2] + "xx", http.StatusUnauthorized},
+		{"role missing", operator, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := apiRequest(t, server, http.MethodGet, blocklist_target, test.token, nil)
+			if response.Code != test.code {
+				t.Errorf("expected: %d, got %d: %s", test.code, response.Code, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestWalletSessionRoles(t *testing.T) {
+	// a wallet session gets its roles from the wallet admins file each request
+	node := newTestNode(t, nil)
+	server := node.newCuratorServer()
+
+	claims := newAdminClaims(cip30_address, nil, time.Hour)
+	claims.Address = cip30_address
+	token, err := node.signAdminClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response := apiRequest(t, server, http.MethodGet, "/api/v0/auth/session", token, nil); response.Code != http.StatusOK {
+		t.Errorf("expected a session without roles to be valid, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := apiRequest(t, server, http.MethodGet, blocklist_target, token, nil); response.Code != http.StatusUnauthorized {
+		t.Errorf("expected a session without roles to be refused, got %d: %s", response.Code, response.Body.String())
+	}
+
+	admins, _ := json.Marshal([]WalletAdmin{{Address: cip30_address, Roles: []string{RoleModerator}}})
+	err = os.WriteFile(node.walletAdminsPath(), admins, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := apiRequest(t, server, http.MethodGet, blocklist_target, token, nil); response.Code != http.StatusOK {
+		t.Errorf("expected the session to get the admin's roles, got %d: %s", response.Code, response.Body.String())
+	}
+}
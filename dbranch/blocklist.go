@@ -0,0 +1,298 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+//
+// blocklists of cids, tx hashes and addresses that are refused on every ingest path
+//
+
+const (
+	BlockCID     = "cid"
+	BlockTx      = "tx"
+	BlockAddress = "address"
+)
+
+type BlockEntry struct {
+	Kind    string     `json:"kind"` // cid, tx or address
+	Value   string     `json:"value"`
+	Reason  string     `json:"reason,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Source  string     `json:"source,omitempty"` // who added the entry or the list it was imported from
+	Added   time.Time  `json:"added"`
+}
+
+func (node *Node) blocklistPath() string {
+	return node.statePath(node.config.BlocklistFile, "blocklist.json")
+}
+
+// imported blocklists larger than this are refused
+const max_blocklist_size = 10 * 1024 * 1024
+
+func validBlockKind(kind string) bool {
+	return kind == BlockCID || kind == BlockTx || kind == BlockAddress
+}
+
+func blockKey(kind, value string) string {
+	/*
+		the value entries are matched by, cids match by their multihash so any version or base of a blocked cid is blocked
+		and a sub path is dropped, ie. the cid of an attachment ref, values that don't parse are matched as is
+	*/
+	if kind != BlockCID {
+		return value
+	}
+	value = strings.SplitN(strings.TrimPrefix(value, "/ipfs/"), "/", 2)[0]
+	parsed, err := cid.Decode(value)
+	if err != nil {
+		return value
+	}
+	return string(parsed.Hash())
+}
+
+func (entry *BlockEntry) matches(kind, value string) bool {
+	return entry.Kind == kind && blockKey(kind, entry.Value) == blockKey(kind, value)
+}
+
+func (entry *BlockEntry) expired() bool {
+	return entry.Expires != nil && time.Now().After(*entry.Expires)
+}
+
+func (node *Node) loadBlocklist() ([]*BlockEntry, error) {
+	entries := []*BlockEntry{}
+
+	data, err := os.ReadFile(node.blocklistPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return entries, errors.New("error decoding blocklist: " + err.Error())
+	}
+
+	return entries, nil
+}
+
+func (node *Node) saveBlocklist(entries []*BlockEntry) error {
+	data, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(node.blocklistPath(), data, 0644)
+}
+
+func (node *Node) ListBlocks() ([]*BlockEntry, error) {
+	node.blocklist_lock.Lock()
+	defer node.blocklist_lock.Unlock()
+	return node.loadBlocklist()
+}
+
+func (node *Node) AddBlock(entry *BlockEntry, actor *AuditActor) error {
+	err := node.addBlock(entry)
+	node.audit(actor, "block", nil, entry.Kind+": "+entry.Value, err)
+	return err
+}
+
+func (node *Node) addBlock(entry *BlockEntry) error {
+	if !validBlockKind(entry.Kind) {
+		return invalidInput("invalid block kind: %s, must be one of: cid, tx, address", entry.Kind)
+	}
+
+	if entry.Value == "" {
+		return invalidInput("missing value to block")
+	}
+
+	node.blocklist_lock.Lock()
+	defer node.blocklist_lock.Unlock()
+
+	entries, err := node.loadBlocklist()
+	if err != nil {
+		return err
+	}
+
+	if entry.Added.IsZero() {
+		entry.Added = time.Now().UTC()
+	}
+
+	// replace an existing entry for the same value so the reason and expiry can be updated
+	for index, existing := range entries {
+		if existing.matches(entry.Kind, entry.Value) {
+			entries[index] = entry
+			return node.saveBlocklist(entries)
+		}
+	}
+
+	return node.saveBlocklist(append(entries, entry))
+}
+
+func (node *Node) RemoveBlock(kind, value string, actor *AuditActor) error {
+	err := node.removeBlock(kind, value)
+	node.audit(actor, "unblock", nil, kind+": "+value, err)
+	return err
+}
+
+func (node *Node) removeBlock(kind, value string) error {
+	node.blocklist_lock.Lock()
+	defer node.blocklist_lock.Unlock()
+
+	entries, err := node.loadBlocklist()
+	if err != nil {
+		return err
+	}
+
+	remaining := []*BlockEntry{}
+	for _, entry := range entries {
+		if !entry.matches(kind, value) {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	if len(remaining) == len(entries) {
+		return fmt.Errorf("%w: no block found for %s: %s", ErrNotFound, kind, value)
+	}
+
+	return node.saveBlocklist(remaining)
+}
+
+func (node *Node) IsBlocked(kind, value string) (*BlockEntry, error) {
+	// returns the matching entry or nil if the value is not blocked, expired entries are ignored
+	if value == "" {
+		return nil, nil
+	}
+
+	entries, err := node.ListBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.matches(kind, value) && !entry.expired() {
+			return entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func blockedError(entry *BlockEntry) error {
+	if entry.Reason != "" {
+		return fmt.Errorf("%s is %w: %s: %s", entry.Kind, ErrBlocked, entry.Value, entry.Reason)
+	}
+	return fmt.Errorf("%s is %w: %s", entry.Kind, ErrBlocked, entry.Value)
+}
+
+func (node *Node) checkBlocklist(record *ArticleRecord, address string) error {
+	checks := [][2]string{{BlockCID, record.CID}, {BlockTx, record.CardanoTxHash}, {BlockAddress, address}}
+
+	// attachment refs may have a sub path, ie. QmX/clip.mp4, they are matched by their cid
+	for _, attachment := range record.Attachments {
+		checks = append(checks, [2]string{BlockCID, attachment})
+	}
+
+	for _, check := range checks {
+		entry, err := node.IsBlocked(check[0], check[1])
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			return blockedError(entry)
+		}
+	}
+
+	return nil
+}
+
+func (node *Node) ImportBlocklist(ctx context.Context, source string, actor *AuditActor) (int, error) {
+	/* import entries shared by another curator from a file path or http(s) url, returns the number of new entries */
+	count, err := node.importBlocklist(ctx, source)
+	node.audit(actor, "block_import", nil, fmt.Sprintf("%s: %d new entries", source, count), err)
+	return count, err
+}
+
+func (node *Node) importBlocklist(ctx context.Context, source string) (int, error) {
+	var reader io.ReadCloser
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := node.blocklist_client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s returned error: %s", ErrUpstream, source, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return 0, fmt.Errorf("%w: %s returned status: %s", ErrUpstream, source, resp.Status)
+		}
+		reader = resp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return 0, err
+		}
+		reader = file
+	}
+	defer reader.Close()
+
+	// read one byte past the limit so an oversized list is detected without reading all of it
+	data, err := io.ReadAll(io.LimitReader(reader, max_blocklist_size+1))
+	if err != nil {
+		return 0, fmt.Errorf("%w: error reading blocklist: %s", ErrUpstream, err)
+	}
+	if len(data) > max_blocklist_size {
+		return 0, fmt.Errorf("%w: blocklist is larger than %d bytes", ErrUpstream, max_blocklist_size)
+	}
+
+	imported := []*BlockEntry{}
+	err = json.Unmarshal(data, &imported)
+	if err != nil {
+		return 0, fmt.Errorf("%w: error decoding blocklist: %s", ErrUpstream, err)
+	}
+
+	node.blocklist_lock.Lock()
+	defer node.blocklist_lock.Unlock()
+
+	entries, err := node.loadBlocklist()
+	if err != nil {
+		return 0, err
+	}
+
+	existing := map[string]bool{}
+	for _, entry := range entries {
+		existing[entry.Kind+":"+blockKey(entry.Kind, entry.Value)] = true
+	}
+
+	count := 0
+	for _, entry := range imported {
+		key := entry.Kind + ":" + blockKey(entry.Kind, entry.Value)
+		if !validBlockKind(entry.Kind) || entry.Value == "" || entry.expired() || existing[key] {
+			continue
+		}
+
+		// keep the original source if the list was itself imported from elsewhere
+		if entry.Source == "" {
+			entry.Source = source
+		}
+		entry.Added = time.Now().UTC()
+
+		existing[key] = true
+		entries = append(entries, entry)
+		count++
+	}
+
+	return count, node.saveBlocklist(entries)
+}
@@ -0,0 +1,182 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const blocked_cid = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+
+func cidV1(t *testing.T, v0 string) string {
+	t.Helper()
+	parsed, err := cid.Decode(v0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(parsed.Type(), parsed.Hash()).String()
+}
+
+func TestBlocklistAddReplaceRemove(t *testing.T) {
+	node := newTestNode(t, nil)
+
+	err := node.AddBlock(&BlockEntry{Kind: "image", Value: "x"}, nil)
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected an invalid kind to be refused, got: %v", err)
+	}
+	err = node.AddBlock(&BlockEntry{Kind: BlockCID}, nil)
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected a missing value to be refused, got: %v", err)
+	}
+
+	err = node.AddBlock(&BlockEntry{Kind: BlockCID, Value: blocked_cid, Reason: "spam"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same cid in another version replaces the entry
+	err = node.AddBlock(&BlockEntry{Kind: BlockCID, Value: cidV1(t, blocked_cid), Reason: "malware"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := node.ListBlocks()
+	if len(entries) != 1 || entries[0].Reason != "malware" {
+		t.Fatalf("expected the entry to be replaced: %+v", entries)
+	}
+
+	err = node.RemoveBlock(BlockCID, blocked_cid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = node.RemoveBlock(BlockCID, blocked_cid, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected removing a missing block to be not found, got: %v", err)
+	}
+}
+
+func TestIsBlocked(t *testing.T) {
+	node := newTestNode(t, nil)
+	expired := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+
+	for _, entry := range []*BlockEntry{
+		{Kind: BlockCID, Value: blocked_cid, Expires: &later},
+		{Kind: BlockTx, Value: "aa11", Expires: &expired},
+		{Kind: BlockAddress, Value: "addr_test1blocked"},
+	} {
+		err := node.AddBlock(entry, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		kind    string
+		value   string
+		blocked bool
+	}{
+		{BlockCID, blocked_cid, true},
+		{BlockCID, cidV1(t, blocked_cid), true},
+		{BlockCID, blocked_cid + "/clip.mp4", true},
+		{BlockCID, "/ipfs/" + blocked_cid, true},
+		{BlockCID, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", false},
+		{BlockTx, blocked_cid, false},
+		{BlockTx, "aa11", false}, // expired
+		{BlockAddress, "addr_test1blocked", true},
+		{BlockCID, "", false},
+	}
+
+	for _, test := range tests {
+		entry, err := node.IsBlocked(test.kind, test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (entry != nil) != test.blocked {
+			t.Errorf("%s %s: expected blocked: %t", test.kind, test.value, test.blocked)
+		}
+	}
+}
+
+func TestCheckBlocklist(t *testing.T) {
+	node := newTestNode(t, nil)
+	err := node.AddBlock(&BlockEntry{Kind: BlockCID, Value: blocked_cid, Reason: "spam"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a block on an attachment's cid matches refs with a sub path
+	record := &ArticleRecord{Name: "article", CID: "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", Attachments: []string{cidV1(t, blocked_cid) + "/clip.mp4"}}
+	err = node.checkBlocklist(record, "")
+	if !errors.Is(err, ErrBlocked) || !strings.Contains(err.Error(), "spam") {
+		t.Errorf("expected the attachment to be blocked, got: %v", err)
+	}
+
+	record.Attachments = nil
+	if err = node.checkBlocklist(record, "addr_test1"); err != nil {
+		t.Errorf("expected the record to pass, got: %v", err)
+	}
+}
+
+func TestImportBlocklist(t *testing.T) {
+	node := newTestNode(t, nil)
+	err := node.AddBlock(&BlockEntry{Kind: BlockCID, Value: blocked_cid}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	shared, _ := json.Marshal([]*BlockEntry{
+		{Kind: BlockCID, Value: cidV1(t, blocked_cid)}, // already blocked
+		{Kind: BlockTx, Value: "bb22", Reason: "scam"},
+		{Kind: BlockAddress, Value: "addr_test1old", Expires: &expired},
+		{Kind: "image", Value: "x"},
+		{Kind: BlockAddress, Value: "addr_test1other", Source: "another curator"},
+	})
+
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(shared)
+	}))
+	defer list.Close()
+
+	count, err := node.ImportBlocklist(context.Background(), list.URL, nil)
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 new entries, got %d: %v", count, err)
+	}
+	entry, _ := node.IsBlocked(BlockAddress, "addr_test1other")
+	if entry == nil || entry.Source != "another curator" {
+		t.Errorf("expected the original source to be kept: %+v", entry)
+	}
+	entry, _ = node.IsBlocked(BlockTx, "bb22")
+	if entry == nil || entry.Source != list.URL {
+		t.Errorf("expected the list url as source: %+v", entry)
+	}
+
+	// importing again from a file adds nothing
+	file := path.Join(t.TempDir(), "blocklist.json")
+	os.WriteFile(file, shared, 0644)
+	count, err = node.ImportBlocklist(context.Background(), file, nil)
+	if err != nil || count != 0 {
+		t.Errorf("expected no new entries, got %d: %v", count, err)
+	}
+
+	// lists past the size limit are refused before decoding
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("["))
+		w.Write([]byte(strings.Repeat(" ", max_blocklist_size)))
+	}))
+	defer large.Close()
+
+	_, err = node.ImportBlocklist(context.Background(), large.URL, nil)
+	if !errors.Is(err, ErrUpstream) || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected an oversize list to be refused, got: %v", err)
+	}
+}
@@ -1,74 +1,19 @@
 package dbranch
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
+	"path"
 	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
-var db *sql.DB
-
-func fileValue(file string) string {
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		panic(err)
-	}
-	return string(data)
-}
-
-func init() {
-	var err error
-
-	db_host, db_name, pw, user := "", "", "", ""
-
-	db_host = os.Getenv("POSTGRES_DB_HOST")
-	if db_host == "" {
-		db_host = "localhost"
-	}
-
-	db_file := os.Getenv("POSTGRES_DB_FILE")
-	if db_file == "" {
-		db_name = "cexplorer"
-	} else {
-		db_name = fileValue(db_file)
-	}
-
-	pw_file := os.Getenv("POSTGRES_PASSWORD_FILE")
-	if pw_file == "" {
-		pw = fileValue("../secrets/postgres_password")
-	} else {
-		pw = fileValue(pw_file)
-	}
-
-	user_file := os.Getenv("POSTGRES_USER_FILE")
-	if user_file == "" {
-		user = "postgres"
-	} else {
-		user = fileValue(user_file)
-	}
-
-	ssl := os.Getenv("POSTGRES_SSL_MODE")
-	if ssl == "" {
-		ssl = "disable"
-	}
-
-	// conn_str := fmt.Sprintf("user=%s dbname=%s password=%s sslmode=%s", user, db_name, pw, ssl)
-	conn_str := fmt.Sprintf("postgresql://%s:%s@%s/%s?sslmode=%s", user, pw, db_host, db_name, ssl)
-
-	db, err = sql.Open("postgres", conn_str)
-	if err != nil {
-		panic(err)
-	}
-}
-
 //
 // db status
 //
@@ -101,31 +46,47 @@ type DBOverview struct {
 
 // methods
 
-func CardanoDBPing() error {
-	return db.Ping()
-}
+func (node *Node) CardanoDBPing(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, node.config.DBTimeout)
+	defer cancel()
 
-func WaitForCardanoDB() {
+	start := time.Now()
+	err := node.db.PingContext(ctx)
+	observeBackend("postgres", "ping", start, err)
+	return err
+}
 
+func (node *Node) WaitForCardanoDB(ctx context.Context) error {
+	// returns ctx.Err() if ctx is done before the db is ready
 	for {
 		log.Println("checking if cardano db is ready")
-		err := CardanoDBPing()
+		err := node.CardanoDBPing(ctx)
 		if err == nil {
 			break
 		}
-		time.Sleep(time.Second * 5)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * 5):
+		}
 	}
 	log.Println("cardano db is ready")
+	return nil
 }
 
-func CardanoDBMeta() (*DBMeta, error) {
+func (node *Node) CardanoDBMeta(ctx context.Context) (*DBMeta, error) {
 	db_meta := &DBMeta{}
 
-	rows, err := db.Query("select * from meta")
-	defer rows.Close()
+	ctx, cancel := context.WithTimeout(ctx, node.config.DBTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := node.db.QueryContext(ctx, "select * from meta")
+	observeBackend("postgres", "meta", start, err)
 	if err != nil {
 		return db_meta, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		err = rows.Scan(&db_meta.ID, &db_meta.StartTime, &db_meta.NetworkName, &db_meta.Version)
@@ -138,19 +99,26 @@ func CardanoDBMeta() (*DBMeta, error) {
 	return db_meta, nil
 }
 
-func CardanoDBSyncStatus() (*DBSyncStatus, error) {
+func (node *Node) CardanoDBSyncStatus(ctx context.Context) (*DBSyncStatus, error) {
 	status := &DBSyncStatus{}
 
-	err := db.QueryRow(`select
+	ctx, cancel := context.WithTimeout(ctx, node.config.DBTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := node.db.QueryRowContext(ctx, `select
 	100 * (extract (epoch from (max (time) at time zone 'UTC')) - extract (epoch from (min (time) at time zone 'UTC')))
 	/ (extract (epoch from (now () at time zone 'UTC')) - extract (epoch from (min (time) at time zone 'UTC')))
    	as sync_percent from block;`).Scan(&status.Percent)
+	observeBackend("postgres", "sync_percent", start, err)
 
 	if err != nil {
 		return status, err
 	}
 
-	err = db.QueryRow("select max(time) from block;").Scan(&status.LastBlockTime)
+	start = time.Now()
+	err = node.db.QueryRowContext(ctx, "select max(time) from block;").Scan(&status.LastBlockTime)
+	observeBackend("postgres", "last_block_time", start, err)
 	if err != nil {
 		return status, err
 	}
@@ -161,36 +129,44 @@ func CardanoDBSyncStatus() (*DBSyncStatus, error) {
 	return status, nil
 }
 
-func CardanoDBBlockStatus() (*DBBlockStatus, error) {
+func (node *Node) CardanoDBBlockStatus(ctx context.Context) (*DBBlockStatus, error) {
 	status := &DBBlockStatus{}
 
-	err := db.QueryRow("SELECT max(block_no) from block;").Scan(&status.LastChainBlockNumber)
+	ctx, cancel := context.WithTimeout(ctx, node.config.DBTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := node.db.QueryRowContext(ctx, "SELECT max(block_no) from block;").Scan(&status.LastChainBlockNumber)
+	observeBackend("postgres", "last_block_number", start, err)
 	if err != nil {
 		return status, err
 	}
 
-	status.LastDaemonBlockNumber = loadLastBlock()
+	status.LastDaemonBlockNumber, err = node.LastDaemonBlock()
+	if err != nil {
+		return status, err
+	}
 	status.Difference = int(status.LastChainBlockNumber) - int(status.LastDaemonBlockNumber)
 
 	return status, nil
 }
 
-func CardanoDBOverview() (*DBOverview, error) {
+func (node *Node) CardanoDBOverview(ctx context.Context) (*DBOverview, error) {
 	overview := &DBOverview{}
 
-	meta, err := CardanoDBMeta()
+	meta, err := node.CardanoDBMeta(ctx)
 	if err != nil {
 		return overview, err
 	}
 	overview.Meta = *meta
 
-	sync_status, err := CardanoDBSyncStatus()
+	sync_status, err := node.CardanoDBSyncStatus(ctx)
 	if err != nil {
 		return overview, err
 	}
 	overview.SyncStatus = *sync_status
 
-	block_status, err := CardanoDBBlockStatus()
+	block_status, err := node.CardanoDBBlockStatus(ctx)
 	if err != nil {
 		return overview, err
 	}
@@ -207,10 +183,12 @@ type CardanoArticleRecord struct {
 	Name          string       `json:"name"`
 	Location      string       `json:"location"`
 	Address       string       `json:"address"`
+	StakeAddress  string       `json:"stake_address,omitempty"`
 	BlockNumber   uint         `json:"block_number"`
 	TxId          int64        `json:"tx_id"`
 	TxHash        string       `json:"tx_hash"`
 	TxHashRaw     sql.RawBytes `json:"tx_hash_raw"`
+	Index         int          `json:"index"` // position in the tx's label 451 list, 0 for txs signing one article
 	DatePublished time.Time    `json:"date_published"`
 }
 
@@ -221,6 +199,7 @@ func formatRecordRows(rows *sql.Rows) ([]CardanoArticleRecord, error) {
 
 	for rows.Next() {
 		record := CardanoArticleRecord{}
+		stake_address := sql.NullString{}
 		err := rows.Scan(
 			&record.Name,
 			&record.Location,
@@ -229,10 +208,13 @@ func formatRecordRows(rows *sql.Rows) ([]CardanoArticleRecord, error) {
 			&record.TxHashRaw,
 			&record.DatePublished,
 			&record.BlockNumber,
+			&stake_address,
+			&record.Index,
 		)
 		if err != nil {
 			return records, err
 		}
+		record.StakeAddress = stake_address.String
 		record.TxHash = hex.EncodeToString(record.TxHashRaw)
 		records = append(records, record)
 	}
@@ -270,11 +252,21 @@ func SinceBlockFilter(block_number uint) RecordFilter {
 
 // methods
 
-func ListCardanoRecords(filters ...RecordFilter) ([]CardanoArticleRecord, error) {
-
-	query := `SELECT tx_metadata.json->>'name', tx_metadata.json->>'loc', tx_out.address, tx.id, tx.hash, block.time, block.block_no
-	FROM ((tx_metadata INNER JOIN tx ON tx_metadata.tx_id = tx.id) INNER JOIN block ON tx.block_id = block.id) INNER JOIN tx_out ON tx.id = tx_out.tx_id
-	WHERE tx_metadata.key = '451' AND tx_metadata.json->>'name' IS NOT NULL AND tx_metadata.json->>'loc' IS NOT NULL AND tx_out.index = 0`
+func (node *Node) ListCardanoRecords(ctx context.Context, filters ...RecordFilter) ([]CardanoArticleRecord, error) {
+
+	// label 451 is either one {name, loc} map or a list of them for txs signing several articles, each is a row
+	// a loc longer than 64 bytes is a list of chunks, see metadataText
+	query := `SELECT entry.value->>'name',
+		CASE WHEN jsonb_typeof(entry.value->'loc') = 'array'
+			THEN (SELECT string_agg(chunk.value, '' ORDER BY chunk.index) FROM jsonb_array_elements_text(entry.value->'loc') WITH ORDINALITY AS chunk(value, index))
+			ELSE entry.value->>'loc' END,
+		tx_out.address, tx.id, tx.hash, block.time, block.block_no, stake_address.view, entry.index - 1
+	FROM (((tx_metadata INNER JOIN tx ON tx_metadata.tx_id = tx.id) INNER JOIN block ON tx.block_id = block.id) INNER JOIN tx_out ON tx.id = tx_out.tx_id)
+	LEFT JOIN stake_address ON tx_out.stake_address_id = stake_address.id
+	CROSS JOIN LATERAL jsonb_array_elements(
+		CASE WHEN jsonb_typeof(tx_metadata.json) = 'array' THEN tx_metadata.json ELSE jsonb_build_array(tx_metadata.json) END
+	) WITH ORDINALITY AS entry(value, index)
+	WHERE tx_metadata.key = '451' AND entry.value->>'name' IS NOT NULL AND entry.value->>'loc' IS NOT NULL AND tx_out.index = 0`
 	args := []any{}
 	var err error
 
@@ -285,48 +277,157 @@ func ListCardanoRecords(filters ...RecordFilter) ([]CardanoArticleRecord, error)
 		}
 	}
 
-	rows, err := db.Query(query, args...)
-	defer rows.Close()
+	ctx, cancel := context.WithTimeout(ctx, node.config.DBTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := node.db.QueryContext(ctx, query, args...)
+	observeBackend("postgres", "list_records", start, err)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	return formatRecordRows(rows)
 }
 
-func CurateRecordByCardanoTxHash(tx_hash string) (*ArticleRecord, error) {
-	return addRecordByCardanoTxHash(CuratedDir, tx_hash, true)
+func (node *Node) CurateRecordsByCardanoTxHash(ctx context.Context, tx_hash string, actor *AuditActor) ([]*ArticleRecord, error) {
+	return node.addRecordsByCardanoTxHash(ctx, CuratedDir, tx_hash, true, actor)
 }
 
-func PublishRecordByCardanoTxHash(tx_hash string) (*ArticleRecord, error) {
-	return addRecordByCardanoTxHash(PublishedDir, tx_hash, false)
+func (node *Node) PublishRecordsByCardanoTxHash(ctx context.Context, tx_hash string, actor *AuditActor) ([]*ArticleRecord, error) {
+	return node.addRecordsByCardanoTxHash(ctx, PublishedDir, tx_hash, false, actor)
 }
 
-func addRecordByCardanoTxHash(mfs_directory string, tx_hash string, copy_article bool) (*ArticleRecord, error) {
-	records, err := ListCardanoRecords(TxHashFilter(tx_hash))
+func (node *Node) StageRecordsByCardanoTxHash(ctx context.Context, tx_hash string, actor *AuditActor) ([]*ArticleRecord, error) {
+	records, err := node.cardanoRecordsByTxHash(ctx, tx_hash)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(records) == 0 {
-		return nil, errors.New("no record found for hash: " + tx_hash)
+	return eachCardanoRecord(records, func(record *CardanoArticleRecord) (*ArticleRecord, error) {
+		article, err := cardanoArticleRecord(record)
+		if err != nil {
+			return nil, err
+		}
+
+		err = node.checkBlocklist(article, record.Address)
+		if err != nil {
+			node.audit(actor, "stage", article, "", err)
+			return article, err
+		}
+
+		return article, node.StageArticle(ctx, article, actor)
+	})
+}
+
+func (node *Node) cardanoRecordsByTxHash(ctx context.Context, tx_hash string) ([]CardanoArticleRecord, error) {
+	/* the records of a tx, one for each article it signs */
+	records, err := node.ListCardanoRecords(ctx, TxHashFilter(tx_hash))
+	if err != nil {
+		return nil, err
 	}
 
-	record := records[0]
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w for hash: %s", ErrRecordNotFound, tx_hash)
+	}
+	return records, nil
+}
 
-	// return addCardanoRecordToLocal(mfs_directory, &record[0], copy_article)
+func cardanoArticleRecord(record *CardanoArticleRecord) (*ArticleRecord, error) {
 	if !strings.HasPrefix(record.Location, "ipfs://") {
-		return nil, errors.New("invalid location: " + record.Location)
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLocation, record.Location)
 	}
 
-	article := &ArticleRecord{
+	return &ArticleRecord{
 		Name:          record.Name,
 		CID:           strings.Replace(record.Location, "ipfs://", "", 1),
 		DatePublished: record.DatePublished,
 		CardanoTxHash: record.TxHash,
+	}, nil
+}
+
+func eachCardanoRecord(records []CardanoArticleRecord, add func(record *CardanoArticleRecord) (*ArticleRecord, error)) ([]*ArticleRecord, error) {
+	/*
+		add each record of a tx, one failing doesn't stop the others
+		returns the records that were added and the first error, prefixed with the article name when the tx signs more than one
+	*/
+	articles := []*ArticleRecord{}
+	var first_err error
+
+	for i := range records {
+		article, err := add(&records[i])
+		if err == nil {
+			articles = append(articles, article)
+		}
+		if err != nil && first_err == nil {
+			first_err = err
+			if len(records) > 1 {
+				first_err = fmt.Errorf("%s: %w", records[i].Name, err)
+			}
+		}
+	}
+
+	return articles, first_err
+}
+
+func (node *Node) cardanoRecordAdded(ctx context.Context, record *CardanoArticleRecord) (bool, error) {
+	/*
+		whether the article of record was already curated, quarantined or staged from its tx
+		the wire listener curates txs before the poller reaches their block, copying them again would fail
+	*/
+	for _, directory := range []string{CuratedDir, QuarantineDir, PendingDir} {
+		existing, err := node.loadArticleRecord(ctx, path.Join(directory, record.Name+".json"))
+		if errors.Is(err, ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if existing.CardanoTxHash == record.TxHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (node *Node) addRecordsByCardanoTxHash(ctx context.Context, mfs_directory string, tx_hash string, copy_article bool, actor *AuditActor) ([]*ArticleRecord, error) {
+	records, err := node.cardanoRecordsByTxHash(ctx, tx_hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return eachCardanoRecord(records, func(record *CardanoArticleRecord) (*ArticleRecord, error) {
+		return node.addCardanoRecord(ctx, mfs_directory, record, copy_article, actor)
+	})
+}
+
+func (node *Node) addCardanoRecord(ctx context.Context, mfs_directory string, record *CardanoArticleRecord, copy_article bool, actor *AuditActor) (*ArticleRecord, error) {
+	article, err := cardanoArticleRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.checkBlocklist(article, record.Address)
+	if err != nil {
+		node.recordRejection(article, err)
+		node.audit(actor, auditActionForDirectory(mfs_directory), article, "", err)
+		return article, err
+	}
+
+	// published articles are our own, only curated articles are subject to the curation policy
+	if copy_article {
+		decision, err := node.applyCurationPolicy(ctx, record, article, actor)
+		if err != nil {
+			return article, err
+		}
+
+		if decision.Action == PolicyReview {
+			log.Printf("holding article for review: %s: %s\n", article.Name, decision.Reasons[len(decision.Reasons)-1])
+			return article, node.StageArticle(ctx, article, actor)
+		}
 	}
 
-	err = AddRecordToLocal(mfs_directory, article, copy_article)
+	err = node.AddRecordToLocal(ctx, mfs_directory, article, copy_article, actor)
 	if err != nil {
 		return article, err
 	}
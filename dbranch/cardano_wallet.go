@@ -1,335 +1,552 @@
 package dbranch
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
-	"net/http"
 	"os"
 	"path"
-	"syscall"
+	"strings"
 	"time"
 
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
 	_ "github.com/lib/pq"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 //
-// http
+// published articles
 //
 
-var client = &http.Client{}
-var wallet_host string
+// metadata label articles are published under, the value is a map with name and loc keys or a list of them
+const article_metadata_label = "451"
 
-func init() {
-	client = &http.Client{Timeout: 30 * time.Second}
-	wallet_host = os.Getenv("CARDANO_WALLET_HOST")
-	if wallet_host == "" {
-		wallet_host = "http://localhost:8090"
-	}
+type ArticleTransaction struct {
+	TransactionID string `json:"transaction_id"`
+	Index         int    `json:"index"` // position in the tx's label 451 list, 0 for txs signing one article
+	Name          string `json:"name"`
+	Location      string `json:"loc"`
+	Status        string `json:"status"`
 }
 
-func getRequest(endpoint string) (interface{}, error) {
-	url := wallet_host + endpoint
-
-	var data interface{}
+//
+// wallet apis
+//
 
-	resp, err := client.Get(url)
+func (node *Node) WalletIds(ctx context.Context) ([]string, error) {
+	wallets, err := node.wallet.ListWallets(ctx)
 	if err != nil {
-		return data, errors.New(url + " returned error: " + err.Error())
+		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return data, errors.New("error decoding config file: " + err.Error())
+	wallet_ids := []string{}
+	for _, w := range wallets {
+		wallet_ids = append(wallet_ids, w.ID)
 	}
-
-	return data, nil
+	return wallet_ids, nil
 }
 
-type walletError struct {
-	Message string `json:"message"`
-	Code    string `json:"code"`
+func (node *Node) WalletAddresses(ctx context.Context, wallet_id string) ([]wallet.Address, error) {
+	return node.wallet.ListAddresses(ctx, wallet_id, "")
 }
 
 //
-// cardano primitives
+// article signing
 //
 
-type cborString struct {
-	String string `json:"string"`
+// an article in the label 451 metadata of a tx
+type SignedArticle struct {
+	Name     string `json:"name"`
+	Location string `json:"loc"`
 }
 
-type cborKeyValue struct {
-	Key   cborString `json:"k"`
-	Value cborString `json:"v"`
+func (article *SignedArticle) record(tx_hash string) *ArticleRecord {
+	return &ArticleRecord{Name: article.Name, CID: strings.TrimPrefix(article.Location, "ipfs://"), CardanoTxHash: tx_hash}
 }
 
-type cborMap struct {
-	Map []cborKeyValue `json:"map"`
+func articleMetadataEntry(article SignedArticle) wallet.MetadataValue {
+	return wallet.MetadataMap(
+		wallet.MetadataPair{Key: wallet.MetadataString("name"), Value: wallet.MetadataString(article.Name)},
+		wallet.MetadataPair{Key: wallet.MetadataString("loc"), Value: metadataText(article.Location)},
+	)
 }
 
-type CardanoAddress struct {
-	ID    string `json:"id"`
-	State string `json:"state"`
+func metadataText(text string) wallet.MetadataValue {
+	// text longer than the ledger's limit on metadata strings is a list of chunks, like cip-25, ie. ipfs:// and a cidv1
+	if len(text) <= max_metadata_string {
+		return wallet.MetadataString(text)
+	}
+
+	chunks := []wallet.MetadataValue{}
+	for len(text) > max_metadata_string {
+		chunks = append(chunks, wallet.MetadataString(text[:max_metadata_string]))
+		text = text[max_metadata_string:]
+	}
+	return wallet.MetadataValue{List: append(chunks, wallet.MetadataString(text))}
 }
 
-type CardanoTransaction struct {
-	ID        string                     `json:"id"`
-	Direction string                     `json:"direction"`
-	Status    string                     `json:"status"`
-	Metadata  articleTransactionMetadata `json:"metadata"`
+func metadataTextValue(value wallet.MetadataValue) string {
+	/* the text of a string or of a list of chunks from metadataText */
+	if value.List == nil {
+		return value.Text()
+	}
+
+	text := ""
+	for _, chunk := range value.List {
+		text += chunk.Text()
+	}
+	return text
 }
 
-type transactionAmount struct {
-	Quantity int    `json:"quantity"`
-	Unit     string `json:"unit"`
+func articleMetadata(articles []SignedArticle) wallet.Metadata {
+	/* one article is a {name, loc} map like the txs signed before batches, more than one is a list of them */
+	if len(articles) == 1 {
+		return wallet.Metadata{article_metadata_label: articleMetadataEntry(articles[0])}
+	}
+
+	entries := []wallet.MetadataValue{}
+	for _, article := range articles {
+		entries = append(entries, articleMetadataEntry(article))
+	}
+	return wallet.Metadata{article_metadata_label: {List: entries}}
 }
 
-type transactionPayment struct {
-	Address string            `json:"address"`
-	Amount  transactionAmount `json:"amount"`
+func signedArticlesFromMetadata(metadata wallet.Metadata) []SignedArticle {
+	label, ok := metadata[article_metadata_label]
+	if !ok {
+		return nil
+	}
+
+	entries := label.List
+	if entries == nil {
+		entries = []wallet.MetadataValue{label}
+	}
+
+	articles := []SignedArticle{}
+	for _, entry := range entries {
+		name, _ := entry.Get("name")
+		location, _ := entry.Get("loc")
+		if name.Text() == "" || metadataTextValue(location) == "" {
+			continue
+		}
+		articles = append(articles, SignedArticle{Name: name.Text(), Location: metadataTextValue(location)})
+	}
+	return articles
 }
 
-type transactionRequest struct {
-	Passphrase string                     `json:"passphrase"`
-	Payments   []transactionPayment       `json:"payments"`
-	Metadata   articleTransactionMetadata `json:"metadata"`
+func (node *Node) ListSignedArticles(ctx context.Context, wallet_id string) ([]ArticleTransaction, error) {
+	transactions, err := node.wallet.ListTransactions(ctx, wallet_id, wallet.TransactionQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	articles := []ArticleTransaction{}
+
+	// filter non articles, txs signing a batch have an entry for each article
+	for _, tx := range transactions {
+		if tx.Status != wallet.TxInLedger || tx.Direction != wallet.TxOutgoing {
+			continue
+		}
+
+		for index, article := range signedArticlesFromMetadata(tx.Metadata) {
+			articles = append(articles, ArticleTransaction{
+				tx.ID,
+				index,
+				article.Name,
+				article.Location,
+				tx.Status,
+			})
+		}
+	}
+
+	return articles, nil
 }
 
-//
-// published articles
-//
+type SignRequest struct {
+	WalletID string `json:"wallet_id"`
+	Address  string `json:"address"`
+	Path     string `json:"path"`             // mfs path of the article on this node's ipfs
+	Amount   int64  `json:"amount,omitempty"` // lovelace sent to address, 0 for Config.SignAmount
+}
 
-type articleTransactionMetadata struct {
-	Label cborMap `json:"451"`
+type BatchSignRequest struct {
+	WalletID string   `json:"wallet_id"`
+	Address  string   `json:"address"`
+	Paths    []string `json:"paths"`            // mfs paths of the articles on this node's ipfs
+	Amount   int64    `json:"amount,omitempty"` // lovelace sent to address in each tx, 0 for Config.SignAmount
 }
 
-type ArticleTransaction struct {
-	TransactionID string `json:"transaction_id"`
-	Name          string `json:"name"`
-	Location      string `json:"loc"`
-	Status        string `json:"status"`
+// what signing articles in one tx will cost, amounts are in lovelace
+type SignatureEstimate struct {
+	Articles     []SignedArticle `json:"articles"`
+	WalletID     string          `json:"wallet_id"`
+	Address      string          `json:"address"`
+	Amount       int64           `json:"amount"`
+	MetadataSize int             `json:"metadata_size"` // estimated bytes of the tx's metadata
+	MinUTxO      int64           `json:"min_utxo"`
+	EstimatedMin int64           `json:"estimated_min_fee"`
+	EstimatedMax int64           `json:"estimated_max_fee"`
+	Deposit      int64           `json:"deposit"`
+
+	// set by EstimateSignature from the tx the wallet constructed
+	Fee           int64                 `json:"fee,omitempty"`
+	Transaction   string                `json:"transaction,omitempty"`
+	CoinSelection *wallet.CoinSelection `json:"coin_selection,omitempty"`
+
+	payment *wallet.PaymentRequest
 }
 
-//
-// wallet apis
-//
+// the ledger's limit on metadata strings, longer locations are split with metadataText
+const max_metadata_string = 64
 
-func WalletIds() ([]string, error) {
-	var wallet_ids []string
+func (node *Node) signedArticles(ctx context.Context, mfs_paths []string) ([]SignedArticle, error) {
+	/* the metadata entries for articles on this node's ipfs */
+	articles := []SignedArticle{}
+	for _, mfs_path := range mfs_paths {
+		if mfs_path == "" {
+			return nil, invalidInput("an article path is required")
+		}
 
-	resp, err := getRequest("/v2/wallets")
-	if err != nil {
-		return wallet_ids, err
-	}
+		_, article_name := path.Split(mfs_path)
+		stat, err := node.statIpfsPath(ctx, mfs_path)
+		if err != nil {
+			return nil, err
+		}
 
-	for _, wallet := range resp.([]interface{}) {
-		wallet_ids = append(wallet_ids, wallet.(map[string]interface{})["id"].(string))
+		article := SignedArticle{Name: article_name, Location: "ipfs://" + stat.Hash}
+		if len(article.Name) > max_metadata_string {
+			return nil, invalidInput("the name of %s must be at most %d bytes to fit in tx metadata", mfs_path, max_metadata_string)
+		}
+		articles = append(articles, article)
 	}
+	return articles, nil
+}
 
-	return wallet_ids, nil
+func cborTextSize(text string) int {
+	// a cbor text string is a header of 1 to 9 bytes depending on its length, then the text
+	switch length := len(text); {
+	case length < 24:
+		return 1 + length
+	case length < 256:
+		return 2 + length
+	case length < 65536:
+		return 3 + length
+	default:
+		return 5 + length
+	}
 }
 
-func WalletAddresses(wallet_id string) ([]CardanoAddress, error) {
-	var addresses []CardanoAddress
+func metadataTextSize(text string) int {
+	// see metadataText, a list of fewer than 24 chunks has a 1 byte header
+	if len(text) <= max_metadata_string {
+		return cborTextSize(text)
+	}
 
-	resp, err := getRequest("/v2/wallets/" + wallet_id + "/addresses")
-	if err != nil {
-		return addresses, err
+	size := 1
+	for len(text) > max_metadata_string {
+		size += cborTextSize(text[:max_metadata_string])
+		text = text[max_metadata_string:]
 	}
+	return size + cborTextSize(text)
+}
 
-	for _, address := range resp.([]interface{}) {
-		addr := CardanoAddress{
-			ID:    address.(map[string]interface{})["id"].(string),
-			State: address.(map[string]interface{})["state"].(string),
-		}
+func articleEntrySize(article SignedArticle) int {
+	// a map header, then the name and loc keys and values
+	return 1 + cborTextSize("name") + cborTextSize(article.Name) + cborTextSize("loc") + metadataTextSize(article.Location)
+}
 
-		addresses = append(addresses, addr)
+func batchSignedArticles(articles []SignedArticle, limit int) [][]SignedArticle {
+	/* split articles into txs whose metadata is at most limit bytes, the order is kept */
+	// the metadata map header, label 451 and the list header
+	const overhead = 1 + 3 + 3
+
+	batches := [][]SignedArticle{}
+	batch := []SignedArticle{}
+	size := overhead
+	for _, article := range articles {
+		entry := articleEntrySize(article)
+		if len(batch) > 0 && size+entry > limit {
+			batches = append(batches, batch)
+			batch = []SignedArticle{}
+			size = overhead
+		}
+		batch = append(batch, article)
+		size += entry
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
 	}
+	return batches
+}
 
-	return addresses, nil
+func metadataSize(articles []SignedArticle) int {
+	size := 1 + 3 + 3
+	for _, article := range articles {
+		size += articleEntrySize(article)
+	}
+	return size
 }
 
-func walletTransactions(wallet_id string) ([]CardanoTransaction, error) {
-	// init
-	transactions := make([]CardanoTransaction, 0)
+func (node *Node) prepareSignature(ctx context.Context, wallet_id, address string, amount int64, articles []SignedArticle) (*SignatureEstimate, error) {
+	/* the payment for signing articles in one tx and its fees, the amount is checked against the min-UTxO of the output */
+	if wallet_id == "" || address == "" {
+		return nil, invalidInput("a wallet id and address are required")
+	}
+	if amount == 0 {
+		amount = node.config.SignAmount
+	}
+	if amount < 0 {
+		return nil, invalidInput("invalid amount: %d", amount)
+	}
 
-	url := wallet_host + "/v2/wallets/" + wallet_id + "/transactions"
+	estimate := &SignatureEstimate{
+		Articles:     articles,
+		WalletID:     wallet_id,
+		Address:      address,
+		Amount:       amount,
+		MetadataSize: metadataSize(articles),
+		payment: &wallet.PaymentRequest{
+			Payments: []wallet.Payment{{Address: address, Amount: wallet.Lovelace(amount)}},
+			Metadata: articleMetadata(articles),
+		},
+	}
 
-	// request
-	resp, err := client.Get(url)
+	fees, err := node.wallet.PaymentFees(ctx, wallet_id, *estimate.payment)
 	if err != nil {
-		return transactions, errors.New(url + " returned error: " + err.Error())
+		return nil, err
+	}
+	estimate.EstimatedMin = fees.EstimatedMin.Quantity
+	estimate.EstimatedMax = fees.EstimatedMax.Quantity
+	estimate.Deposit = fees.Deposit.Quantity
+	if len(fees.MinimumCoins) > 0 {
+		estimate.MinUTxO = fees.MinimumCoins[0].Quantity
 	}
 
-	defer resp.Body.Close()
+	if estimate.Amount < estimate.MinUTxO {
+		return estimate, invalidInput("amount of %d lovelace is below the min-UTxO of %d lovelace for this output", estimate.Amount, estimate.MinUTxO)
+	}
+	return estimate, nil
+}
 
-	// decode
-	err = json.NewDecoder(resp.Body).Decode(&transactions)
+func (node *Node) constructSignature(ctx context.Context, estimate *SignatureEstimate) error {
+	/* add the fee and tx the wallet would submit to an estimate, nothing is signed or submitted */
+	constructed, err := node.wallet.ConstructTransaction(ctx, estimate.WalletID, *estimate.payment)
 	if err != nil {
-		return transactions, errors.New("error decoding config file: " + err.Error())
+		return err
 	}
-
-	return transactions, nil
+	estimate.Fee = constructed.Fee.Quantity
+	estimate.Transaction = constructed.Transaction
+	estimate.CoinSelection = &constructed.CoinSelection
+	return nil
 }
 
-//
-// article signing
-//
-
-func ListSignedArticles(wallet_id string) ([]ArticleTransaction, error) {
+func (node *Node) submitSignature(ctx context.Context, estimate *SignatureEstimate, passphrase string, actor *AuditActor) (*PendingSignature, error) {
+	/* sign and submit the tx for an estimate and track it until its records are published */
+	tx_request := wallet.TransactionRequest{
+		Passphrase: passphrase,
+		Payments:   estimate.payment.Payments,
+		Metadata:   estimate.payment.Metadata,
+	}
 
-	transactions, err := walletTransactions(wallet_id)
+	tx, err := node.wallet.CreateTransaction(ctx, estimate.WalletID, tx_request)
 	if err != nil {
+		for _, article := range estimate.Articles {
+			node.audit(actor, "sign", article.record(""), estimate.WalletID, err)
+		}
 		return nil, err
 	}
 
-	articles := []ArticleTransaction{}
+	for _, article := range estimate.Articles {
+		node.audit(actor, "sign", article.record(tx.ID), estimate.WalletID, nil)
+	}
 
-	// parse response and filter non articles
-	for _, transaction := range transactions {
-		if transaction.Status == "in_ledger" && transaction.Direction == "outgoing" {
-			if transaction.Metadata.Label.Map != nil {
-				name := ""
-				location := ""
-
-				for _, keyValue := range transaction.Metadata.Label.Map {
-					if keyValue.Key.String == "name" {
-						name = keyValue.Value.String
-					} else if keyValue.Key.String == "loc" {
-						location = keyValue.Value.String
-					}
-				}
-
-				if name == "" || location == "" {
-					continue
-				}
-
-				articles = append(articles, ArticleTransaction{
-					transaction.ID,
-					name,
-					location,
-					transaction.Status,
-				})
-			}
-		}
+	if actor == nil {
+		actor = &node.default_actor
+	}
+	now := time.Now().UTC()
+	signature := &PendingSignature{
+		TxHash:    tx.ID,
+		WalletID:  estimate.WalletID,
+		Articles:  estimate.Articles,
+		Actor:     actor.Name,
+		Source:    actor.Source,
+		Status:    SignatureSubmitted,
+		Submitted: now,
+		Expires:   now.Add(node.config.SignatureTimeout),
 	}
 
-	return articles, nil
+	// the tx was submitted either way, an error here means it has to be published by hand once it is in the ledger
+	err = node.trackSignature(signature)
+	if err != nil {
+		return signature, errors.New("signed articles in tx " + tx.ID + " but could not track it, publish it once it is in the ledger: " + err.Error())
+	}
+	return signature, nil
 }
 
-func SignArticle(wallet_id, address, mfs_path string) (*ArticleRecord, error) {
-	//
-	// init
-	//
-
-	_, article_name := path.Split(mfs_path)
-	stat, err := statIpfsPath(mfs_path)
+func (node *Node) EstimateSignature(ctx context.Context, request SignRequest) (*SignatureEstimate, error) {
+	/* fees, deposit and min-UTxO for signing an article, and the tx the wallet would submit, nothing is signed or submitted */
+	articles, err := node.signedArticles(ctx, []string{request.Path})
 	if err != nil {
 		return nil, err
 	}
 
-	ipfs_path := "ipfs://" + stat.Hash
+	estimate, err := node.prepareSignature(ctx, request.WalletID, request.Address, request.Amount, articles)
+	if err != nil {
+		return estimate, err
+	}
+	return estimate, node.constructSignature(ctx, estimate)
+}
 
-	// get user password
-	fmt.Printf("Sign article\n\tname: %s\n\tlocation: %s\n", article_name, ipfs_path)
-	fmt.Println("To sign enter cardano wallet password: ")
+func (node *Node) SignArticle(ctx context.Context, request SignRequest, passphrase string, actor *AuditActor) (*PendingSignature, error) {
+	/*
+		sign an article by sending a transaction from the wallet to request.Address with the article in its metadata
+		the signature is pending until the tx is in the ledger and db-sync, see WaitForSignature and ProcessPendingSignatures
+		see WalletPassphrase for passphrases that aren't entered by the user
+	*/
+	if passphrase == "" {
+		return nil, invalidInput("a wallet passphrase is required")
+	}
 
-	password, err := terminal.ReadPassword(int(syscall.Stdin))
+	articles, err := node.signedArticles(ctx, []string{request.Path})
 	if err != nil {
-		return nil, errors.New("error reading password: " + err.Error())
-	}
-	fmt.Println() // needed to clear the password from the terminal
-
-	// format request
-	body := transactionRequest{
-		Passphrase: string(password),
-		Payments: []transactionPayment{
-			{
-				Address: address,
-				Amount: transactionAmount{
-					Quantity: 1000000,
-					Unit:     "lovelace",
-				},
-			},
-		},
-		Metadata: articleTransactionMetadata{
-			Label: cborMap{
-				Map: []cborKeyValue{
-					{
-						Key:   cborString{String: "name"},
-						Value: cborString{String: article_name},
-					},
-					{
-						Key:   cborString{String: "loc"},
-						Value: cborString{String: ipfs_path},
-					},
-				},
-			},
-		},
+		return nil, err
 	}
 
-	jsonData, err := json.Marshal(body)
+	estimate, err := node.prepareSignature(ctx, request.WalletID, request.Address, request.Amount, articles)
 	if err != nil {
-		return nil, errors.New("Error encoding json body: " + err.Error())
+		return nil, err
+	}
+	return node.submitSignature(ctx, estimate, passphrase, actor)
+}
+
+func (node *Node) EstimateBatchSignature(ctx context.Context, request BatchSignRequest) ([]*SignatureEstimate, error) {
+	/* an estimate for each tx signing the articles takes, split so each tx's metadata fits Config.BatchMetadataLimit */
+	if len(request.Paths) == 0 {
+		return nil, invalidInput("at least one article path is required")
 	}
 
-	url := wallet_host + "/v2/wallets/" + wallet_id + "/transactions"
-	resp, err := client.Post(url, "application/json; charset=UTF-8", bytes.NewBuffer(jsonData))
+	articles, err := node.signedArticles(ctx, request.Paths)
 	if err != nil {
-		return nil, errors.New(url + " returned error: " + err.Error())
+		return nil, err
 	}
 
-	defer resp.Body.Close()
+	estimates := []*SignatureEstimate{}
+	for _, batch := range batchSignedArticles(articles, node.config.BatchMetadataLimit) {
+		estimate, err := node.prepareSignature(ctx, request.WalletID, request.Address, request.Amount, batch)
+		if err != nil {
+			return estimates, err
+		}
+		err = node.constructSignature(ctx, estimate)
+		if err != nil {
+			return estimates, err
+		}
+		estimates = append(estimates, estimate)
+	}
+	return estimates, nil
+}
+
+func (node *Node) SignArticles(ctx context.Context, request BatchSignRequest, passphrase string, actor *AuditActor) ([]*PendingSignature, error) {
+	/*
+		sign several articles with as few txs as their metadata fits in, see EstimateBatchSignature and SignArticle
+		every tx is checked before any is submitted, if submitting one fails the ones already submitted are returned with the error
+	*/
+	if passphrase == "" {
+		return nil, invalidInput("a wallet passphrase is required")
+	}
+	if len(request.Paths) == 0 {
+		return nil, invalidInput("at least one article path is required")
+	}
 
-	if resp.StatusCode == 202 {
-		// request status accepted
-		var data interface{}
+	articles, err := node.signedArticles(ctx, request.Paths)
+	if err != nil {
+		return nil, err
+	}
 
-		err = json.NewDecoder(resp.Body).Decode(&data)
+	estimates := []*SignatureEstimate{}
+	for _, batch := range batchSignedArticles(articles, node.config.BatchMetadataLimit) {
+		estimate, err := node.prepareSignature(ctx, request.WalletID, request.Address, request.Amount, batch)
 		if err != nil {
-			return nil, errors.New("error decoding config file: " + err.Error())
+			return nil, err
 		}
+		estimates = append(estimates, estimate)
+	}
 
-		tx_hash := data.(map[string]interface{})["id"].(string)
-		return PublishRecordByCardanoTxHash(tx_hash)
-
-	} else {
-		// handle error
-		var err_msg = walletError{}
-		err = json.NewDecoder(resp.Body).Decode(&err_msg)
+	signatures := []*PendingSignature{}
+	for _, estimate := range estimates {
+		signature, err := node.submitSignature(ctx, estimate, passphrase, actor)
+		if signature != nil {
+			signatures = append(signatures, signature)
+		}
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("got status %v and error decoding resp: %v", resp.Status, err.Error()))
+			return signatures, err
 		}
+	}
+	return signatures, nil
+}
 
-		return nil, errors.New(fmt.Sprintf("%v - %v - %v", resp.Status, err_msg.Code, err_msg.Message))
+//
+// passphrases for signing without a prompt
+//
+
+func (node *Node) walletKeyringPath(wallet_id string) string {
+	return path.Join(node.statePath(node.config.WalletKeyringDir, "keyring"), wallet_id)
+}
+
+func (node *Node) WalletPassphrase(wallet_id string) (string, error) {
+	/*
+		the passphrase configured for wallet_id, "" if there isn't one
+		DBRANCH_WALLET_PASSPHRASE or its file is used for every wallet, otherwise the wallet's file in the keyring dir
+	*/
+	if node.config.WalletPassphrase != "" {
+		return node.config.WalletPassphrase, nil
+	}
+
+	// wallet ids are hex, anything else could point outside the keyring dir
+	if wallet_id == "" || strings.Trim(wallet_id, "0123456789abcdefABCDEF") != "" {
+		return "", nil
+	}
+
+	keyring_file := node.walletKeyringPath(wallet_id)
+	info, err := os.Stat(keyring_file)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
 
+	// like ssh keys, a passphrase others can read is refused rather than used
+	if info.Mode().Perm()&0077 != 0 {
+		return "", errors.New("wallet passphrase file " + keyring_file + " is accessible by other users, it must be mode 600 or 400")
 	}
+
+	data, err := os.ReadFile(keyring_file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
 }
 
 //
 // Daemon
 //
 
-func Status() (string, error) {
-	resp, err := getRequest("/v2/network/information")
+func (node *Node) Status(ctx context.Context) (string, error) {
+	info, err := node.wallet.NetworkInformation(ctx)
 	if err != nil {
 		return "", err
 	}
-
-	sync := resp.(map[string]interface{})
-	status := sync["sync_progress"].(map[string]interface{})["status"].(string)
-	return status, nil
+	return info.SyncProgress.Status, nil
 }
 
-func WaitForCardanoWallet() {
-	status := ""
-	for status != "ready" {
-		status, _ = Status()
-		time.Sleep(time.Second * 5)
+func (node *Node) WaitForCardanoWallet(ctx context.Context) error {
+	// returns ctx.Err() if ctx is done before the wallet is synced
+	for {
+		status, _ := node.Status(ctx)
+		if status == "ready" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * 5):
+		}
 	}
 }
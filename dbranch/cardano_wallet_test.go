@@ -0,0 +1,137 @@
+package dbranch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+)
+
+// ipfs:// and a base32 cidv1, 66 bytes
+const cidv1_location = "ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+func testSignedArticles(count int) []SignedArticle {
+	articles := []SignedArticle{}
+	for i := 1; i <= count; i++ {
+		articles = append(articles, SignedArticle{Name: fmt.Sprintf("article-%d", i), Location: fmt.Sprintf("ipfs://QmArticle%d", i)})
+	}
+	return articles
+}
+
+func TestBatchSignedArticles(t *testing.T) {
+	// every test article has the same entry size, the metadata header is 7 bytes
+	entry := articleEntrySize(testSignedArticles(1)[0])
+
+	tests := []struct {
+		name     string
+		articles []SignedArticle
+		limit    int
+		sizes    []int
+	}{
+		{"none", nil, 8192, []int{}},
+		{"all fit", testSignedArticles(3), 8192, []int{3}},
+		{"exactly at the limit", testSignedArticles(3), 7 + 2*entry, []int{2, 1}},
+		{"one byte under the limit", testSignedArticles(3), 7 + 2*entry - 1, []int{1, 1, 1}},
+		{"single oversize entry", testSignedArticles(1), 10, []int{1}},
+		{"every entry oversize", testSignedArticles(3), 10, []int{1, 1, 1}},
+		{"long location", []SignedArticle{{Name: "long", Location: cidv1_location}}, 8192, []int{1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batches := batchSignedArticles(test.articles, test.limit)
+
+			sizes := []int{}
+			joined := []SignedArticle{}
+			for _, batch := range batches {
+				sizes = append(sizes, len(batch))
+				joined = append(joined, batch...)
+			}
+
+			if !reflect.DeepEqual(sizes, test.sizes) {
+				t.Errorf("expected batch sizes: %v, got: %v", test.sizes, sizes)
+			}
+			if len(test.articles) > 0 && !reflect.DeepEqual(joined, test.articles) {
+				t.Errorf("expected the order to be kept: %v, got: %v", test.articles, joined)
+			}
+		})
+	}
+}
+
+func TestSignedArticlesFromMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string // as returned by the wallet
+		articles []SignedArticle
+	}{
+		{
+			"map",
+			`{"451": {"map": [{"k": {"string": "name"}, "v": {"string": "first"}}, {"k": {"string": "loc"}, "v": {"string": "ipfs://QmFirst"}}]}}`,
+			[]SignedArticle{{Name: "first", Location: "ipfs://QmFirst"}},
+		},
+		{
+			"list",
+			`{"451": {"list": [
+				{"map": [{"k": {"string": "name"}, "v": {"string": "first"}}, {"k": {"string": "loc"}, "v": {"string": "ipfs://QmFirst"}}]},
+				{"map": [{"k": {"string": "name"}, "v": {"string": "second"}}, {"k": {"string": "loc"}, "v": {"string": "ipfs://QmSecond"}}]}
+			]}}`,
+			[]SignedArticle{{Name: "first", Location: "ipfs://QmFirst"}, {Name: "second", Location: "ipfs://QmSecond"}},
+		},
+		{
+			"chunked location",
+			`{"451": {"map": [{"k": {"string": "name"}, "v": {"string": "long"}}, {"k": {"string": "loc"}, "v": {"list": [
+				{"string": "ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbz"}, {"string": "di"}
+			]}}]}}`,
+			[]SignedArticle{{Name: "long", Location: cidv1_location}},
+		},
+		{
+			"entries without a name or loc are skipped",
+			`{"451": {"list": [
+				{"map": [{"k": {"string": "name"}, "v": {"string": "first"}}]},
+				{"map": [{"k": {"string": "name"}, "v": {"string": "second"}}, {"k": {"string": "loc"}, "v": {"string": "ipfs://QmSecond"}}]}
+			]}}`,
+			[]SignedArticle{{Name: "second", Location: "ipfs://QmSecond"}},
+		},
+		{"other labels", `{"674": {"string": "a message"}}`, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := wallet.Metadata{}
+			err := json.Unmarshal([]byte(test.metadata), &metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			articles := signedArticlesFromMetadata(metadata)
+			if !reflect.DeepEqual(articles, test.articles) {
+				t.Errorf("expected: %v, got: %v", test.articles, articles)
+			}
+		})
+	}
+}
+
+func TestArticleMetadataRoundTrip(t *testing.T) {
+	// what is signed reads back the same, with locations over the ledger's string limit split into chunks
+	for _, articles := range [][]SignedArticle{
+		testSignedArticles(1),
+		testSignedArticles(3),
+		{{Name: "long", Location: cidv1_location}},
+	} {
+		metadata := articleMetadata(articles)
+
+		location, _ := metadata[article_metadata_label].Get("loc")
+		for _, chunk := range location.List {
+			if len(chunk.Text()) > max_metadata_string {
+				t.Errorf("chunk is longer than %d bytes: %s", max_metadata_string, chunk.Text())
+			}
+		}
+
+		decoded := signedArticlesFromMetadata(metadata)
+		if !reflect.DeepEqual(decoded, articles) {
+			t.Errorf("expected: %v, got: %v", articles, decoded)
+		}
+	}
+}
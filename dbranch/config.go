@@ -0,0 +1,310 @@
+package dbranch
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// node configuration
+//
+
+type Config struct {
+	// directory for local curator state, ie. ~/.dbranch
+	Dir string
+
+	// ipfs api address and time allowed for reads and for copying, pinning and writing articles
+	IpfsHost        string
+	IpfsTimeout     time.Duration
+	IpfsCopyTimeout time.Duration
+
+	// cardano db-sync postgres connection and time allowed for each query
+	PostgresHost     string
+	PostgresDB       string
+	PostgresUser     string
+	PostgresPassword string
+	PostgresSSLMode  string
+	DBTimeout        time.Duration
+
+	// cardano wallet api and time allowed for each request
+	WalletHost    string
+	WalletTimeout time.Duration
+
+	// passphrase for signing without a prompt, used for every wallet, and the dir holding one passphrase file per wallet id
+	WalletPassphrase string
+	WalletKeyringDir string
+
+	// if true the admin api signs with the passphrase above when a request has none, anyone with the editor role can
+	// then spend from the wallet, otherwise sign requests must include the passphrase
+	ServerPassphrase bool
+
+	// lovelace sent to the signing address with each signed article, it must be at least the output's min-UTxO
+	SignAmount int64
+
+	// bytes of label 451 metadata in each tx when signing a batch of articles, the rest of the 16kb max tx size is
+	// left for inputs, outputs and witnesses, batches that don't fit are split across txs
+	BatchMetadataLimit int
+
+	// how long a signed article is waited on to be in the ledger and db-sync before it is given up on
+	SignatureTimeout time.Duration
+
+	// file listing the cardano addresses the daemon curates articles from, one per line
+	AddressFile string
+
+	// fallback for articles that are not pinned locally, see gateway.go
+	Gateways       []string
+	RemoteIpfsApis []string
+	GatewayTimeout time.Duration
+	GatewayCache   bool
+
+	// articles larger than this many bytes are rejected
+	MaxArticleSize int64
+
+	// articles whose attachments total more than this many bytes are not curated
+	MaxAttachmentsSize int64
+
+	// policy for articles with an unregistered type, "reject" or "quarantine"
+	UnknownTypePolicy string
+
+	// if true each audit entry includes the hash of the previous entry
+	AuditHashChain bool
+
+	// health checks that must pass for /readyz, the time allowed for each and when db-sync and the daemon are considered behind
+	ReadyChecks     []string
+	HealthTimeout   time.Duration
+	MaxSyncLag      time.Duration
+	MaxHeartbeatAge time.Duration
+
+	// server port, address the daemon serves /metrics on ("off" to disable) and time allowed for open requests on shutdown
+	ServerPort        string
+	DaemonMetricsAddr string
+	ShutdownTimeout   time.Duration
+
+	// pubsub topic publishers announce new articles on
+	WireChannel string
+
+	// how long a wallet has to sign a challenge and how long the session from a wallet sign in is valid
+	ChallengeTTL time.Duration
+	SessionTTL   time.Duration
+
+	// state files, each defaults to a file in Dir when empty
+	PolicyFile            string
+	AdminKeysFile         string
+	JWTSecretFile         string
+	WalletAdminsFile      string
+	WebhooksFile          string
+	PinningServicesFile   string
+	PendingSignaturesFile string
+	BlocklistFile         string
+	ReplicasFile          string
+}
+
+func DefaultConfig() *Config {
+	/* config for local services on their default ports, Dir is left empty and defaults to ~/.dbranch in NewNode */
+	return &Config{
+		IpfsHost:        "localhost:5001",
+		IpfsTimeout:     10 * time.Second,
+		IpfsCopyTimeout: 60 * time.Second,
+
+		PostgresHost:    "localhost",
+		PostgresDB:      "cexplorer",
+		PostgresUser:    "postgres",
+		PostgresSSLMode: "disable",
+		DBTimeout:       30 * time.Second,
+
+		WalletHost:    "http://localhost:8090",
+		WalletTimeout: 30 * time.Second,
+
+		SignAmount:         1000000,
+		BatchMetadataLimit: 8192,
+
+		// longer than the wallet's default tx ttl of 2h
+		SignatureTimeout: 3 * time.Hour,
+
+		AddressFile: "./samples/cardano_addresses.txt",
+
+		GatewayTimeout: 10 * time.Second,
+
+		MaxArticleSize:     5 * 1024 * 1024,
+		MaxAttachmentsSize: 100 * 1024 * 1024,
+		UnknownTypePolicy:  "reject",
+		AuditHashChain:     true,
+
+		ReadyChecks:     []string{"ipfs", "postgres"},
+		HealthTimeout:   5 * time.Second,
+		MaxSyncLag:      10 * time.Minute,
+		MaxHeartbeatAge: 2 * time.Minute,
+
+		ServerPort:        "1323",
+		DaemonMetricsAddr: "localhost:9324",
+		ShutdownTimeout:   10 * time.Second,
+
+		WireChannel: "dbranch-wire",
+
+		ChallengeTTL: 5 * time.Minute,
+		SessionTTL:   24 * time.Hour,
+	}
+}
+
+func ConfigFromEnv() (*Config, error) {
+	/* the default config with overrides from env vars, see the configuration section of the readme */
+	config := DefaultConfig()
+
+	stringEnv := func(name string, value *string) {
+		if env := os.Getenv(name); env != "" {
+			*value = env
+		}
+	}
+
+	var err error
+	durationEnv := func(name string, value *time.Duration) {
+		env := os.Getenv(name)
+		if env == "" || err != nil {
+			return
+		}
+		duration, parse_err := time.ParseDuration(env)
+		if parse_err != nil || duration <= 0 {
+			err = errors.New("invalid value for " + name + ": " + env)
+			return
+		}
+		*value = duration
+	}
+
+	fileEnv := func(name string, value *string) {
+		file := os.Getenv(name)
+		if file == "" || err != nil {
+			return
+		}
+		data, read_err := os.ReadFile(file)
+		if read_err != nil {
+			err = errors.New("cannot read " + name + ": " + read_err.Error())
+			return
+		}
+		*value = strings.TrimSpace(string(data))
+	}
+
+	stringEnv("DBRANCH_DIR", &config.Dir)
+
+	stringEnv("IPFS_HOST", &config.IpfsHost)
+	durationEnv("DBRANCH_IPFS_TIMEOUT", &config.IpfsTimeout)
+	durationEnv("DBRANCH_IPFS_COPY_TIMEOUT", &config.IpfsCopyTimeout)
+
+	stringEnv("POSTGRES_DB_HOST", &config.PostgresHost)
+	fileEnv("POSTGRES_DB_FILE", &config.PostgresDB)
+	fileEnv("POSTGRES_USER_FILE", &config.PostgresUser)
+	stringEnv("POSTGRES_SSL_MODE", &config.PostgresSSLMode)
+	durationEnv("DBRANCH_DB_TIMEOUT", &config.DBTimeout)
+
+	if os.Getenv("POSTGRES_PASSWORD_FILE") != "" {
+		fileEnv("POSTGRES_PASSWORD_FILE", &config.PostgresPassword)
+	} else if data, read_err := os.ReadFile("../secrets/postgres_password"); read_err == nil {
+		// the docker compose layout, commands that don't use postgres work without it
+		config.PostgresPassword = strings.TrimSpace(string(data))
+	}
+
+	stringEnv("CARDANO_WALLET_HOST", &config.WalletHost)
+	durationEnv("DBRANCH_WALLET_TIMEOUT", &config.WalletTimeout)
+	stringEnv("DBRANCH_WALLET_PASSPHRASE", &config.WalletPassphrase)
+	fileEnv("DBRANCH_WALLET_PASSPHRASE_FILE", &config.WalletPassphrase)
+	stringEnv("DBRANCH_WALLET_KEYRING_DIR", &config.WalletKeyringDir)
+	config.ServerPassphrase = os.Getenv("DBRANCH_SERVER_PASSPHRASE") == "true"
+	durationEnv("DBRANCH_SIGNATURE_TIMEOUT", &config.SignatureTimeout)
+
+	if amount := os.Getenv("DBRANCH_SIGN_AMOUNT"); amount != "" {
+		lovelace, parse_err := strconv.ParseInt(amount, 10, 64)
+		if parse_err != nil || lovelace <= 0 {
+			return nil, errors.New("invalid value for DBRANCH_SIGN_AMOUNT: " + amount)
+		}
+		config.SignAmount = lovelace
+	}
+
+	if limit := os.Getenv("DBRANCH_BATCH_METADATA_LIMIT"); limit != "" {
+		bytes, parse_err := strconv.Atoi(limit)
+		if parse_err != nil || bytes <= 0 {
+			return nil, errors.New("invalid value for DBRANCH_BATCH_METADATA_LIMIT: " + limit)
+		}
+		config.BatchMetadataLimit = bytes
+	}
+
+	stringEnv("CARDANO_ADDRESS_FILE", &config.AddressFile)
+
+	config.Gateways = splitList(os.Getenv("DBRANCH_GATEWAYS"))
+	config.RemoteIpfsApis = splitList(os.Getenv("DBRANCH_REMOTE_IPFS_APIS"))
+	durationEnv("DBRANCH_GATEWAY_TIMEOUT", &config.GatewayTimeout)
+	config.GatewayCache = os.Getenv("DBRANCH_GATEWAY_CACHE") == "true"
+
+	if max_size := os.Getenv("DBRANCH_MAX_ARTICLE_SIZE"); max_size != "" {
+		size, parse_err := strconv.ParseInt(max_size, 10, 64)
+		if parse_err != nil || size <= 0 {
+			return nil, errors.New("invalid value for DBRANCH_MAX_ARTICLE_SIZE: " + max_size)
+		}
+		config.MaxArticleSize = size
+	}
+
+	if max_size := os.Getenv("DBRANCH_MAX_ATTACHMENTS_SIZE"); max_size != "" {
+		size, parse_err := strconv.ParseInt(max_size, 10, 64)
+		if parse_err != nil || size <= 0 {
+			return nil, errors.New("invalid value for DBRANCH_MAX_ATTACHMENTS_SIZE: " + max_size)
+		}
+		config.MaxAttachmentsSize = size
+	}
+
+	stringEnv("DBRANCH_UNKNOWN_TYPE_POLICY", &config.UnknownTypePolicy)
+	config.AuditHashChain = os.Getenv("DBRANCH_AUDIT_HASH_CHAIN") != "false"
+
+	if checks := os.Getenv("DBRANCH_READY_CHECKS"); checks != "" {
+		config.ReadyChecks = splitList(checks)
+	}
+	durationEnv("DBRANCH_HEALTH_TIMEOUT", &config.HealthTimeout)
+	durationEnv("DBRANCH_MAX_SYNC_LAG", &config.MaxSyncLag)
+	durationEnv("DBRANCH_MAX_HEARTBEAT_AGE", &config.MaxHeartbeatAge)
+
+	stringEnv("DBRANCH_SERVER_PORT", &config.ServerPort)
+	stringEnv("DBRANCH_DAEMON_METRICS_ADDR", &config.DaemonMetricsAddr)
+	durationEnv("DBRANCH_SHUTDOWN_TIMEOUT", &config.ShutdownTimeout)
+
+	stringEnv("DBRANCH_WIRE_CHANNEL", &config.WireChannel)
+
+	durationEnv("DBRANCH_SESSION_TTL", &config.SessionTTL)
+
+	stringEnv("DBRANCH_POLICY_FILE", &config.PolicyFile)
+	stringEnv("DBRANCH_ADMIN_KEYS_FILE", &config.AdminKeysFile)
+	stringEnv("DBRANCH_JWT_SECRET_FILE", &config.JWTSecretFile)
+	stringEnv("DBRANCH_WALLET_ADMINS_FILE", &config.WalletAdminsFile)
+	stringEnv("DBRANCH_WEBHOOKS_FILE", &config.WebhooksFile)
+	stringEnv("DBRANCH_PINNING_SERVICES_FILE", &config.PinningServicesFile)
+	stringEnv("DBRANCH_PENDING_SIGNATURES_FILE", &config.PendingSignaturesFile)
+	stringEnv("DBRANCH_BLOCKLIST_FILE", &config.BlocklistFile)
+	stringEnv("DBRANCH_REPLICAS_FILE", &config.ReplicasFile)
+
+	if err != nil {
+		return nil, err
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) Validate() error {
+	if config.UnknownTypePolicy != "reject" && config.UnknownTypePolicy != "quarantine" {
+		return errors.New("invalid unknown type policy: " + config.UnknownTypePolicy)
+	}
+	if config.MaxArticleSize <= 0 {
+		return errors.New("max article size must be positive")
+	}
+	if config.MaxAttachmentsSize <= 0 {
+		return errors.New("max attachments size must be positive")
+	}
+	if config.SignAmount <= 0 {
+		return errors.New("sign amount must be positive")
+	}
+	if config.BatchMetadataLimit <= 0 {
+		return errors.New("batch metadata limit must be positive")
+	}
+	return nil
+}
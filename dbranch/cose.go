@@ -0,0 +1,306 @@
+package dbranch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//
+// minimal cbor decoding for the COSE_Sign1 and COSE_Key structures returned by CIP-30 wallets
+//
+
+// arrays, maps and tags nested deeper than this are refused, COSE structures are only a few levels deep
+const max_cbor_depth = 16
+
+type cborDecoder struct {
+	data   []byte
+	offset int
+	depth  int
+}
+
+func (decoder *cborDecoder) header() (byte, uint64, error) {
+	// returns the major type and argument of the next item
+	if decoder.offset >= len(decoder.data) {
+		return 0, 0, errors.New("unexpected end of cbor data")
+	}
+
+	initial := decoder.data[decoder.offset]
+	decoder.offset++
+	major, info := initial>>5, initial&0x1f
+
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("unsupported cbor additional info: %d", info)
+	}
+
+	if decoder.offset+size > len(decoder.data) {
+		return 0, 0, errors.New("unexpected end of cbor data")
+	}
+
+	var argument uint64
+	for _, b := range decoder.data[decoder.offset : decoder.offset+size] {
+		argument = argument<<8 | uint64(b)
+	}
+	decoder.offset += size
+
+	return major, argument, nil
+}
+
+func (decoder *cborDecoder) decode() (interface{}, error) {
+	/*
+		decodes the next item as: int64 for integers, []byte, string, []interface{} or map[interface{}]interface{}
+		tags are skipped, indefinite lengths and floats are not supported, map keys must be integers or text
+	*/
+	major, argument, err := decoder.header()
+	if err != nil {
+		return nil, err
+	}
+
+	if major == 4 || major == 5 || major == 6 {
+		// the input comes from unauthenticated requests, unbounded nesting would overflow the stack
+		if decoder.depth >= max_cbor_depth {
+			return nil, errors.New("cbor nested too deeply")
+		}
+		decoder.depth++
+		defer func() { decoder.depth-- }()
+	}
+
+	switch major {
+	case 0:
+		if argument > 1<<63-1 {
+			return nil, errors.New("cbor integer out of range")
+		}
+		return int64(argument), nil
+
+	case 1:
+		if argument > 1<<63-1 {
+			return nil, errors.New("cbor integer out of range")
+		}
+		return -1 - int64(argument), nil
+
+	case 2, 3:
+		if argument > uint64(len(decoder.data)-decoder.offset) {
+			return nil, errors.New("unexpected end of cbor data")
+		}
+		value := decoder.data[decoder.offset : decoder.offset+int(argument)]
+		decoder.offset += int(argument)
+		if major == 3 {
+			return string(value), nil
+		}
+		return value, nil
+
+	case 4:
+		if argument > uint64(len(decoder.data)) {
+			return nil, errors.New("cbor array too long")
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := decoder.decode()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+
+	case 5:
+		if argument > uint64(len(decoder.data)) {
+			return nil, errors.New("cbor map too long")
+		}
+		items := map[interface{}]interface{}{}
+		for i := uint64(0); i < argument; i++ {
+			key, err := decoder.decode()
+			if err != nil {
+				return nil, err
+			}
+			// other keys aren't used by COSE and arrays and maps can't be used as map keys in go
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor map keys must be integers or text")
+			}
+			value, err := decoder.decode()
+			if err != nil {
+				return nil, err
+			}
+			items[key] = value
+		}
+		return items, nil
+
+	case 6:
+		return decoder.decode()
+
+	case 7:
+		switch argument {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported cbor item: major type %d", major)
+}
+
+func decodeCbor(data []byte) (interface{}, error) {
+	decoder := &cborDecoder{data: data}
+	value, err := decoder.decode()
+	if err != nil {
+		return nil, err
+	}
+	if decoder.offset != len(data) {
+		return nil, errors.New("trailing bytes after cbor item")
+	}
+	return value, nil
+}
+
+func cborHeader(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		header := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(header[1:], uint16(argument))
+		return header
+	case argument <= 0xffffffff:
+		header := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[1:], uint32(argument))
+		return header
+	default:
+		header := []byte{major<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(header[1:], argument)
+		return header
+	}
+}
+
+//
+// COSE
+//
+
+type coseSign1 struct {
+	Protected   []byte // serialized protected header, signed as is
+	Headers     map[interface{}]interface{}
+	Unprotected map[interface{}]interface{}
+	Payload     []byte
+	Signature   []byte
+}
+
+func parseCoseSign1(data []byte) (*coseSign1, error) {
+	decoded, err := decodeCbor(data)
+	if err != nil {
+		return nil, errors.New("invalid COSE_Sign1: " + err.Error())
+	}
+
+	items, ok := decoded.([]interface{})
+	if !ok || len(items) != 4 {
+		return nil, errors.New("invalid COSE_Sign1: expected an array of 4 items")
+	}
+
+	sign1 := &coseSign1{}
+	sign1.Protected, ok = items[0].([]byte)
+	if !ok {
+		return nil, errors.New("invalid COSE_Sign1: protected header must be a byte string")
+	}
+
+	sign1.Unprotected, ok = items[1].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid COSE_Sign1: unprotected header must be a map")
+	}
+
+	sign1.Payload, ok = items[2].([]byte)
+	if !ok {
+		return nil, errors.New("invalid COSE_Sign1: payload must be a byte string")
+	}
+
+	sign1.Signature, ok = items[3].([]byte)
+	if !ok {
+		return nil, errors.New("invalid COSE_Sign1: signature must be a byte string")
+	}
+
+	sign1.Headers = map[interface{}]interface{}{}
+	if len(sign1.Protected) > 0 {
+		headers, err := decodeCbor(sign1.Protected)
+		if err != nil {
+			return nil, errors.New("invalid COSE_Sign1 protected header: " + err.Error())
+		}
+		sign1.Headers, ok = headers.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("invalid COSE_Sign1: protected header must be a map")
+		}
+	}
+
+	return sign1, nil
+}
+
+func (sign1 *coseSign1) sigStructure() []byte {
+	// Sig_structure = ["Signature1", protected, external_aad, payload]
+	var buffer bytes.Buffer
+	buffer.Write(cborHeader(4, 4))
+	buffer.Write(cborHeader(3, uint64(len("Signature1"))))
+	buffer.WriteString("Signature1")
+	buffer.Write(cborHeader(2, uint64(len(sign1.Protected))))
+	buffer.Write(sign1.Protected)
+	buffer.Write(cborHeader(2, 0))
+	buffer.Write(cborHeader(2, uint64(len(sign1.Payload))))
+	buffer.Write(sign1.Payload)
+	return buffer.Bytes()
+}
+
+func (sign1 *coseSign1) verify(public_key ed25519.PublicKey) error {
+	// CIP-30 wallets sign with EdDSA (-8)
+	if algorithm, ok := sign1.Headers[int64(1)]; !ok || algorithm != int64(-8) {
+		return errors.New("unsupported COSE algorithm, expected EdDSA")
+	}
+
+	if hashed, _ := sign1.Unprotected["hashed"].(bool); hashed {
+		return errors.New("hashed payloads are not supported")
+	}
+
+	if !ed25519.Verify(public_key, sign1.sigStructure(), sign1.Signature) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+func parseCoseKey(data []byte) (ed25519.PublicKey, error) {
+	// OKP key type (1) on the Ed25519 curve (6) with the public key in x (-2)
+	decoded, err := decodeCbor(data)
+	if err != nil {
+		return nil, errors.New("invalid COSE_Key: " + err.Error())
+	}
+
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid COSE_Key: expected a map")
+	}
+
+	if key[int64(1)] != int64(1) || key[int64(-1)] != int64(6) {
+		return nil, errors.New("invalid COSE_Key: expected an Ed25519 OKP key")
+	}
+
+	x, ok := key[int64(-2)].([]byte)
+	if !ok || len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid COSE_Key: missing or invalid public key")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
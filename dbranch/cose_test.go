@@ -0,0 +1,206 @@
+package dbranch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// a CIP-30 signData response laid out the way wallets return it: protected header {1: -8, "address": bytes},
+// unprotected {"hashed": false}, the payload and signature, with the COSE_Key {1: 1, 3: -8, -1: 6, -2: x}
+// signed with the ed25519 key from seed 0x00..0x1f for an address whose payment key hash is of that key
+const (
+	cip30_address   = "0027e38d0e19e3434e33fbd001d3fe04b5b76763f88acd625e0d770b43a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babb"
+	cip30_signature = "845846a20127676164647265737358390027e38d0e19e3434e33fbd001d3fe04b5b76763f88acd625e0d770b43a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babba166686173686564f4525369676e20696e20746f20644272616e63685840dcded23ecd2ecf6847cf55fa6dabb63506256de332c0f0e445a3605ffdccc316f9f61f7d7de7c106dadab5c219476692776a1488a4cd457e41835bfd8dfe010a"
+	cip30_key       = "a401010327200621582003a107bff3ce10be1d70dd18e74bc09967e4d6309ba50d5f1ddc8664125531b8"
+)
+
+func testSigningKey() ed25519.PrivateKey {
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+func cborBytes(major byte, data []byte) []byte {
+	return append(cborHeader(major, uint64(len(data))), data...)
+}
+
+func signData(private_key ed25519.PrivateKey, address []byte, payload string) (string, string) {
+	/* the hex COSE_Sign1 and COSE_Key a CIP-30 wallet returns from signData */
+	protected := []byte{0xa2, 0x01, 0x27}
+	protected = append(protected, cborBytes(3, []byte("address"))...)
+	protected = append(protected, cborBytes(2, address)...)
+
+	sign1 := &coseSign1{Protected: protected, Payload: []byte(payload)}
+	signature := ed25519.Sign(private_key, sign1.sigStructure())
+
+	encoded := []byte{0x84}
+	encoded = append(encoded, cborBytes(2, protected)...)
+	encoded = append(encoded, 0xa1)
+	encoded = append(encoded, cborBytes(3, []byte("hashed"))...)
+	encoded = append(encoded, 0xf4)
+	encoded = append(encoded, cborBytes(2, []byte(payload))...)
+	encoded = append(encoded, cborBytes(2, signature)...)
+
+	key := []byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21}
+	key = append(key, cborBytes(2, private_key.Public().(ed25519.PublicKey))...)
+
+	return hex.EncodeToString(encoded), hex.EncodeToString(key)
+}
+
+func TestCoseSign1Vector(t *testing.T) {
+	signature, _ := hex.DecodeString(cip30_signature)
+	key, _ := hex.DecodeString(cip30_key)
+	address, _ := hex.DecodeString(cip30_address)
+
+	sign1, err := parseCoseSign1(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public_key, err := parseCoseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(public_key, testSigningKey().Public().(ed25519.PublicKey)) {
+		t.Errorf("unexpected public key: %x", public_key)
+	}
+	if string(sign1.Payload) != "Sign in to dBranch" {
+		t.Errorf("unexpected payload: %q", sign1.Payload)
+	}
+	if signed_address, _ := sign1.Headers["address"].([]byte); !bytes.Equal(signed_address, address) {
+		t.Errorf("unexpected address header: %x", signed_address)
+	}
+	if err := sign1.verify(public_key); err != nil {
+		t.Errorf("verify: %s", err)
+	}
+
+	key_hash, err := paymentKeyHash(address)
+	if err != nil || !bytes.Equal(key_hash, blake2b224(public_key)) {
+		t.Errorf("payment key hash does not match the key: %v", err)
+	}
+
+	// any change to the signed bytes fails verification
+	sign1.Payload = []byte("Sign in to dBranch!")
+	if err := sign1.verify(public_key); err == nil {
+		t.Error("verified a modified payload")
+	}
+}
+
+func TestWalletSignIn(t *testing.T) {
+	node := newTestNode(t, nil)
+	address, _ := hex.DecodeString(cip30_address)
+
+	challenge, err := node.NewWalletChallenge(cip30_address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, key := signData(testSigningKey(), address, challenge.Message)
+	signed_in, err := node.VerifyWalletSignature(challenge.Nonce, signature, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed_in != challenge.Address {
+		t.Errorf("signed in as %s, expected %s", signed_in, challenge.Address)
+	}
+
+	// challenges can only be used once
+	_, err = node.VerifyWalletSignature(challenge.Nonce, signature, key)
+	if ErrorCode(err) != CodeSignInFailed {
+		t.Errorf("reused challenge: expected %s, got %v", CodeSignInFailed, err)
+	}
+
+	// a key other than the address's payment key
+	challenge, _ = node.NewWalletChallenge(cip30_address)
+	other_key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	signature, key = signData(other_key, address, challenge.Message)
+	_, err = node.VerifyWalletSignature(challenge.Nonce, signature, key)
+	if err == nil || !strings.Contains(err.Error(), "payment key") {
+		t.Errorf("other key: expected payment key error, got %v", err)
+	}
+}
+
+func TestDecodeCborMalformed(t *testing.T) {
+	deep_array := bytes.Repeat([]byte{0x81}, 8*1024*1024)
+	deep_map := append(bytes.Repeat([]byte{0xa1, 0x01}, max_cbor_depth+1), 0x00)
+	deep_tags := append(bytes.Repeat([]byte{0xc6}, max_cbor_depth+1), 0x00)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"deeply nested arrays", deep_array},
+		{"deeply nested maps", deep_map},
+		{"deeply nested tags", deep_tags},
+		{"array map key", []byte{0xa1, 0x80, 0x00}},
+		{"map map key", []byte{0xa1, 0xa0, 0x00}},
+		{"byte string map key", []byte{0xa1, 0x41, 0x61, 0x00}},
+		{"bool map key", []byte{0xa1, 0xf5, 0x00}},
+		{"truncated byte string", []byte{0x58, 0x20, 0x00}},
+		{"array longer than the data", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"indefinite length", []byte{0x9f, 0xff}},
+		{"float", []byte{0xfa, 0x00, 0x00, 0x00, 0x00}},
+		{"trailing bytes", []byte{0x00, 0x00}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeCbor(test.data); err == nil {
+				t.Error("expected an error")
+			}
+			if _, err := parseCoseSign1(test.data); err == nil {
+				t.Error("parseCoseSign1: expected an error")
+			}
+			if _, err := parseCoseKey(test.data); err == nil {
+				t.Error("parseCoseKey: expected an error")
+			}
+		})
+	}
+
+	// nesting up to the limit is fine
+	nested := append(bytes.Repeat([]byte{0x81}, max_cbor_depth), 0x00)
+	if _, err := decodeCbor(nested); err != nil {
+		t.Errorf("nesting at the limit: %s", err)
+	}
+}
+
+func TestAuthVerifyLimits(t *testing.T) {
+	node := newTestNode(t, nil)
+	server := node.newCuratorServer()
+
+	verify := func(body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/v0/auth/verify", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	long_signature := `{"nonce": "n", "key": "` + cip30_key + `", "signature": "` + strings.Repeat("81", max_signature_hex) + `"}`
+	if status := verify(long_signature); status != http.StatusBadRequest {
+		t.Errorf("long signature: expected 400, got %d", status)
+	}
+
+	too_large := `{"nonce": "n", "key": "` + cip30_key + `", "signature": "` + strings.Repeat("81", 1024*1024) + `"}`
+	if status := verify(too_large); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: expected 413, got %d", status)
+	}
+
+	// a malformed signature within the limits is a failed sign in, not a crash
+	challenge, err := node.NewWalletChallenge(cip30_address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := `{"nonce": "` + challenge.Nonce + `", "key": "` + cip30_key + `", "signature": "` + strings.Repeat("81", 1000) + `"}`
+	if status := verify(nested); status != http.StatusUnauthorized {
+		t.Errorf("nested signature: expected 401, got %d", status)
+	}
+}
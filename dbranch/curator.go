@@ -2,39 +2,21 @@ package dbranch
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path"
 	"strconv"
 	"time"
 )
 
-// filepath to store last block number between executions of the curator daemon
-var last_block_file string
-
-func init() {
-	home_dir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal("cannot find user home dir: " + err.Error())
-	}
-	dbranch_dir := path.Join(home_dir, ".dbranch")
-	os.Mkdir(dbranch_dir, 0755)
-	if err != nil && !os.IsExist(err) {
-		log.Fatal(err)
-	}
-	last_block_file = path.Join(dbranch_dir, "last_block")
-}
-
-func ListCardanoAddresses() ([]string, error) {
-	address_path := os.Getenv("CARDANO_ADDRESS_FILE")
-	if address_path == "" {
-		address_path = "./samples/cardano_addresses.txt"
-	}
-
-	file, err := os.Open(address_path)
+func (node *Node) ListCardanoAddresses() ([]string, error) {
+	file, err := os.Open(node.config.AddressFile)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
 
@@ -54,26 +36,52 @@ func ListCardanoAddresses() ([]string, error) {
 	return addresses, nil
 }
 
-func loadLastBlock() uint {
-	data, err := os.ReadFile(last_block_file)
+func (node *Node) setDaemonRunning(block_no uint) {
+	node.daemon_state.lock.Lock()
+	defer node.daemon_state.lock.Unlock()
+	node.daemon_state.running = true
+	node.daemon_state.last_block = block_no
+}
+
+func (node *Node) LastDaemonBlock() (uint, error) {
+	/* last block processed by the daemon, from memory when the daemon runs in this process */
+	node.daemon_state.lock.Lock()
+	defer node.daemon_state.lock.Unlock()
+	if node.daemon_state.running {
+		return node.daemon_state.last_block, nil
+	}
+	return node.loadLastBlock()
+}
+
+func (node *Node) lastBlockPath() string {
+	// stores the last block number between executions of the curator daemon
+	return path.Join(node.config.Dir, "last_block")
+}
+
+func (node *Node) loadLastBlock() (uint, error) {
+	data, err := os.ReadFile(node.lastBlockPath())
 	if os.IsNotExist(err) {
-		return 0
+		return 0, nil
 	} else if err != nil {
-		log.Fatal("can't read last block file: ", err)
+		return 0, errors.New("can't read last block file: " + err.Error())
 	}
 
 	block_no, err := strconv.ParseUint(string(data), 10, 32)
 
 	if err != nil {
-		log.Fatal("can't parse last block file: ", err)
+		return 0, errors.New("can't parse last block file: " + node.lastBlockPath() + ": " + err.Error())
 	}
 
-	log.Printf("loaded last block number: %d from: %s\n", block_no, last_block_file)
-	return uint(block_no)
+	log.Printf("loaded last block number: %d from: %s\n", block_no, node.lastBlockPath())
+	return uint(block_no), nil
 }
 
-func saveLastBlock(block_no uint) {
-	file, err := os.Create(last_block_file)
+func (node *Node) saveLastBlock(block_no uint) {
+	node.daemon_state.lock.Lock()
+	node.daemon_state.last_block = block_no
+	node.daemon_state.lock.Unlock()
+
+	file, err := os.Create(node.lastBlockPath())
 	defer file.Close()
 
 	if err != nil {
@@ -85,55 +93,165 @@ func saveLastBlock(block_no uint) {
 	if err != nil {
 		log.Printf("error writing to last block file: %s\n", err)
 	} else {
-		log.Printf("saved block number: %d to: %s\n", block_no, last_block_file)
+		log.Printf("saved block number: %d to: %s\n", block_no, node.lastBlockPath())
 	}
 }
 
-func CuratorDaemon() {
+// actor recorded in the audit log for articles curated by the poller
+var daemon_actor = &AuditActor{Name: "daemon", Source: "daemon"}
+
+func (node *Node) CuratorDaemon(ctx context.Context) error {
 	log.Println("Cardano curator daemon starting")
+	node.default_actor = *daemon_actor
+
+	unlock, err := node.lockCuratorDir()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	components := []Component{{Name: "poller", Run: node.runCardanoPoller}, {Name: "webhooks", Run: node.runWebhookWorker}}
+	if node.config.DaemonMetricsAddr != "off" {
+		components = append(components, Component{Name: "metrics", Run: node.runDaemonMetrics})
+	}
+
+	Supervise(ctx, components...)
+	log.Println("Cardano curator daemon stopped")
+	return nil
+}
+
+func (node *Node) runDaemonMetrics(ctx context.Context) error {
+	log.Printf("serving daemon metrics on: %s/metrics\n", node.config.DaemonMetricsAddr)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	server := &http.Server{Addr: node.config.DaemonMetricsAddr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	err := server.ListenAndServe()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
 
-	addrs, err := ListCardanoAddresses()
+func (node *Node) runCardanoPoller(ctx context.Context) error {
+	/* curate new records from followed addresses every 20 seconds until ctx is done */
+	addrs, err := node.ListCardanoAddresses()
 	if err != nil {
-		log.Fatalf("could not list addresses: %s", err)
+		return errors.New("could not list addresses: " + err.Error())
 	}
 
 	log.Printf("found %d addresses", len(addrs))
 
-	WaitForCardanoDB()
+	err = node.WaitForCardanoDB(ctx)
+	if err != nil {
+		return nil
+	}
 
 	log.Println("entering curator loop")
 
-	block_no := loadLastBlock()
-	var refresh bool
+	// a bad last block file crashes the poller so it is retried with backoff rather than the whole daemon
+	block_no, err := node.loadLastBlock()
+	if err != nil {
+		return err
+	}
+	node.setDaemonRunning(block_no)
 
 	for {
+		loop_start := time.Now()
 
-		refresh = false
+		block_no = node.curateNewRecords(ctx, addrs, block_no)
 
-		for _, addr := range addrs {
-			records, err := ListCardanoRecords(AddressFilter(addr), SinceBlockFilter(block_no))
-			if err != nil {
-				log.Printf("could not list records: %s", err)
-				continue
-			}
+		err = node.SyncReplicas(ctx)
+		if err != nil {
+			log.Printf("could not sync replicas: %s", err)
+		}
 
-			for _, record := range records {
-				log.Printf("adding record from hash: %s\n", record.TxHash)
-				CurateRecordByCardanoTxHash(record.TxHash)
+		err = node.ProcessPendingSignatures(ctx)
+		if err != nil {
+			log.Printf("could not check pending signatures: %s", err)
+		}
+
+		block_status, err := node.CardanoDBBlockStatus(ctx)
+		if err != nil {
+			log.Printf("could not get block status: %s", err)
+		} else {
+			daemon_block_lag.Set(float64(block_status.Difference))
+			daemon_last_block.Set(float64(block_status.LastDaemonBlockNumber))
+		}
+
+		daemon_loop_duration.Observe(time.Since(loop_start).Seconds())
+		daemon_last_loop.Set(float64(time.Now().Unix()))
+		node.saveHeartbeat()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second * 20):
+		}
+	}
+}
+
+func (node *Node) curateNewRecords(ctx context.Context, addrs []string, block_no uint) uint {
+	// returns the last block processed, a record interrupted by ctx is not counted so it is retried on the next start
+	node.curation_lock.Lock()
+	defer node.curation_lock.Unlock()
+
+	refresh := false
+
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		records, err := node.ListCardanoRecords(ctx, AddressFilter(addr), SinceBlockFilter(block_no))
+		if err != nil {
+			log.Printf("could not list records: %s", err)
+			continue
+		}
+
+		daemon_records_found.Add(float64(len(records)))
+
+		for _, record := range records {
+			added, err := node.cardanoRecordAdded(ctx, &record)
+			if ctx.Err() != nil {
+				break
+			} else if err != nil {
+				log.Printf("could not check if record was added: %s: %s\n", record.TxHash, err)
+			} else if added {
+				log.Printf("already added record from hash: %s\n", record.TxHash)
 				block_no = record.BlockNumber
-				log.Printf("new block_no: %d\n", block_no)
-				saveLastBlock(block_no)
-				refresh = true
+				node.saveLastBlock(block_no)
+				continue
 			}
-		}
 
-		if refresh {
-			err = RefreshArticleIndex()
-			if err != nil {
-				log.Printf("could not refresh article index: %s", err)
+			log.Printf("adding record from hash: %s\n", record.TxHash)
+			_, err = node.addCardanoRecord(ctx, CuratedDir, &record, true, daemon_actor)
+			if ctx.Err() != nil {
+				break
+			} else if err != nil {
+				log.Printf("did not curate: %s: %s\n", record.TxHash, err)
+				daemon_curations.Inc("failure")
+			} else {
+				daemon_curations.Inc("success")
 			}
+			block_no = record.BlockNumber
+			log.Printf("new block_no: %d\n", block_no)
+			node.saveLastBlock(block_no)
+			refresh = true
 		}
+	}
 
-		time.Sleep(time.Second * 20)
+	if refresh && ctx.Err() == nil {
+		err := node.RefreshArticleIndex(ctx, daemon_actor)
+		if err != nil {
+			log.Printf("could not refresh article index: %s", err)
+		}
 	}
+
+	return block_no
 }
@@ -0,0 +1,29 @@
+package dbranch
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoadLastBlock(t *testing.T) {
+	node := newTestNode(t, nil)
+
+	block_no, err := node.LastDaemonBlock()
+	if err != nil || block_no != 0 {
+		t.Fatalf("expected block 0 without a last block file, got %d: %v", block_no, err)
+	}
+
+	node.saveLastBlock(1234)
+	node.daemon_state.running = false
+	block_no, err = node.LastDaemonBlock()
+	if err != nil || block_no != 1234 {
+		t.Fatalf("expected the saved block, got %d: %v", block_no, err)
+	}
+
+	// a corrupt file is an error for the supervisor to handle rather than exiting the process
+	os.WriteFile(node.lastBlockPath(), []byte("not a block"), 0644)
+	_, err = node.LastDaemonBlock()
+	if err == nil {
+		t.Error("expected an error for a corrupt last block file")
+	}
+}
@@ -0,0 +1,124 @@
+package dbranch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+	ipfs "github.com/ipfs/go-ipfs-api"
+)
+
+//
+// errors callers can check with errors.Is, they are wrapped with %w to add details
+//
+
+var (
+	ErrArticleNotFound    = errors.New("article not found")
+	ErrRecordNotFound     = errors.New("record not found")
+	ErrBlocked            = errors.New("blocked")
+	ErrInvalidArticle     = errors.New("invalid article")
+	ErrUnknownArticleType = errors.New("unknown article type")
+	ErrInvalidLocation    = errors.New("invalid location")
+	ErrRejectedByPolicy   = errors.New("rejected by curation policy")
+	ErrInvalidInput       = errors.New("invalid input")
+	ErrNotFound           = errors.New("not found") // admin keys, blocks and webhooks
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrSignInFailed       = errors.New("sign in failed")
+	ErrTooManyRequests    = errors.New("too many requests")
+	ErrCuratorLocked      = errors.New("another curator daemon is using the state dir")
+	ErrUpstream           = errors.New("upstream error") // blocklist sources and pinning services
+	ErrSignatureExpired   = errors.New("signature expired")
+)
+
+type inputError struct {
+	message string
+}
+
+func (err *inputError) Error() string {
+	return err.message
+}
+
+func (err *inputError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
+
+func invalidInput(format string, args ...interface{}) error {
+	/* an error that is ErrInvalidInput without its message as a prefix */
+	return &inputError{message: fmt.Sprintf(format, args...)}
+}
+
+//
+// codes returned by the api
+//
+
+const (
+	CodeInternal           = "internal_error"
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeArticleNotFound    = "article_not_found"
+	CodeRecordNotFound     = "record_not_found"
+	CodeBlocked            = "blocked"
+	CodeInvalidArticle     = "invalid_article"
+	CodeUnknownArticleType = "unknown_article_type"
+	CodeInvalidLocation    = "invalid_location"
+	CodeRejectedByPolicy   = "rejected_by_policy"
+	CodeAlreadyExists      = "already_exists"
+	CodeSignInFailed       = "sign_in_failed"
+	CodeCuratorLocked      = "curator_locked"
+	CodeUpstream           = "upstream_error"
+	CodeWallet             = "wallet_error"
+	CodeSignatureExpired   = "signature_expired"
+)
+
+// checked in order so the more specific error wins when one wraps another
+var error_codes = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{ErrArticleNotFound, CodeArticleNotFound, http.StatusNotFound},
+	{ErrRecordNotFound, CodeRecordNotFound, http.StatusNotFound},
+	{ErrBlocked, CodeBlocked, http.StatusForbidden},
+	{ErrUnknownArticleType, CodeUnknownArticleType, http.StatusUnprocessableEntity},
+	{ErrInvalidArticle, CodeInvalidArticle, http.StatusUnprocessableEntity},
+	{ErrInvalidLocation, CodeInvalidLocation, http.StatusUnprocessableEntity},
+	{ErrRejectedByPolicy, CodeRejectedByPolicy, http.StatusUnprocessableEntity},
+	{ErrInvalidInput, CodeInvalidRequest, http.StatusBadRequest},
+	{ErrNotFound, CodeNotFound, http.StatusNotFound},
+	{ErrAlreadyExists, CodeAlreadyExists, http.StatusConflict},
+	{ErrSignInFailed, CodeSignInFailed, http.StatusUnauthorized},
+	{ErrTooManyRequests, CodeTooManyRequests, http.StatusTooManyRequests},
+	{ErrCuratorLocked, CodeCuratorLocked, http.StatusConflict},
+	{ErrUpstream, CodeUpstream, http.StatusBadGateway},
+	{ErrSignatureExpired, CodeSignatureExpired, http.StatusGone},
+}
+
+func ErrorCode(err error) string {
+	/* machine readable code for err, CodeInternal if it isn't one of the errors above */
+	code, _ := errorCode(err)
+	return code
+}
+
+func errorCode(err error) (string, int) {
+	var wallet_err *wallet.Error
+	if errors.As(err, &wallet_err) {
+		return CodeWallet, http.StatusBadGateway
+	}
+
+	for _, known := range error_codes {
+		if errors.Is(err, known.err) {
+			return known.code, known.status
+		}
+	}
+	return CodeInternal, http.StatusInternalServerError
+}
+
+func ipfsNotExist(err error) bool {
+	// ipfs reports missing mfs paths in the message rather than with a code
+	var ipfs_err *ipfs.Error
+	return errors.As(err, &ipfs_err) && ipfs_err.Message == "file does not exist"
+}
@@ -0,0 +1,191 @@
+package dbranch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//
+// in process event bus for article changes, served to frontends as sse and websocket streams
+//
+
+const (
+	EventArticleAdded   = "article_added"
+	EventArticleRemoved = "article_removed"
+	EventIndexRefreshed = "index_refreshed"
+	EventResync         = "resync" // sent when a subscriber asks to resume from an event that is no longer buffered
+)
+
+type Event struct {
+	ID       uint64           `json:"id"`
+	Time     time.Time        `json:"time"`
+	Type     string           `json:"type"`
+	Section  string           `json:"section,omitempty"` // curated, published or quarantine
+	Record   *ArticleRecord   `json:"record,omitempty"`
+	Metadata *ArticleMetadata `json:"metadata,omitempty"`
+}
+
+type EventFilter struct {
+	Types    []string
+	Sections []string
+	Authors  []string
+}
+
+type EventBus struct {
+	lock        sync.Mutex
+	next_id     uint64
+	history     []*Event // most recent events, oldest first
+	max_history int
+	subscribers map[chan *Event]*EventFilter
+	hooks       []func(event *Event)
+}
+
+// number of events kept so subscribers can resume after a disconnect
+const event_history_size = 1000
+
+// events are buffered per subscriber, a subscriber that falls further behind is disconnected and must resume
+const event_subscriber_buffer = 64
+
+func NewEventBus(max_history int) *EventBus {
+	// ids start from the current time so ids from before a restart are never reused
+	return &EventBus{
+		next_id:     uint64(time.Now().UnixMicro()),
+		max_history: max_history,
+		subscribers: map[chan *Event]*EventFilter{},
+	}
+}
+
+func (filter *EventFilter) match(event *Event) bool {
+	if filter == nil {
+		return true
+	}
+
+	if len(filter.Types) > 0 && !containsFold(filter.Types, event.Type) {
+		return false
+	}
+
+	// section and author only apply to article events
+	if event.Record == nil {
+		return true
+	}
+
+	if len(filter.Sections) > 0 && !containsFold(filter.Sections, event.Section) {
+		return false
+	}
+
+	if len(filter.Authors) > 0 && (event.Metadata == nil || !containsFold(filter.Authors, event.Metadata.Author)) {
+		return false
+	}
+
+	return true
+}
+
+func (bus *EventBus) OnPublish(hook func(event *Event)) {
+	/* call hook with every event after it is published, hooks run in the publishing goroutine */
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.hooks = append(bus.hooks, hook)
+}
+
+func (bus *EventBus) Publish(event *Event) {
+	hooks := bus.publish(event)
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
+
+func (bus *EventBus) publish(event *Event) []func(event *Event) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	event.ID = bus.next_id
+	bus.next_id++
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	bus.history = append(bus.history, event)
+	if len(bus.history) > bus.max_history {
+		bus.history = bus.history[len(bus.history)-bus.max_history:]
+	}
+
+	for subscriber, filter := range bus.subscribers {
+		if !filter.match(event) {
+			continue
+		}
+
+		select {
+		case subscriber <- event:
+		default:
+			delete(bus.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return bus.hooks
+}
+
+func (bus *EventBus) Subscribe(last_id uint64, filter *EventFilter) ([]*Event, <-chan *Event, func()) {
+	/*
+		subscribe to new events matching filter, if last_id is set the buffered events after it are returned to be sent first
+		the returned func must be called to unsubscribe, the channel is closed if the subscriber falls too far behind
+	*/
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	replay := []*Event{}
+	if last_id > 0 {
+		oldest := bus.next_id
+		if len(bus.history) > 0 {
+			oldest = bus.history[0].ID
+		}
+
+		if last_id+1 < oldest || last_id >= bus.next_id {
+			// the requested event is from before a restart or was dropped from history
+			replay = append(replay, &Event{ID: bus.next_id - 1, Time: time.Now().UTC(), Type: EventResync})
+		} else {
+			for _, event := range bus.history {
+				if event.ID > last_id && filter.match(event) {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+
+	subscriber := make(chan *Event, event_subscriber_buffer)
+	bus.subscribers[subscriber] = filter
+
+	unsubscribe := func() {
+		bus.lock.Lock()
+		defer bus.lock.Unlock()
+		if _, ok := bus.subscribers[subscriber]; ok {
+			delete(bus.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return replay, subscriber, unsubscribe
+}
+
+func sectionForDirectory(directory string) string {
+	switch directory {
+	case CuratedDir:
+		return "curated"
+	case PublishedDir:
+		return "published"
+	case QuarantineDir:
+		return "quarantine"
+	default:
+		return ""
+	}
+}
+
+func (node *Node) loadArticleMetadata(ctx context.Context, mfs_path string) *ArticleMetadata {
+	// best effort, events are still published without metadata
+	article, err := node.loadArticle(ctx, mfs_path)
+	if err != nil {
+		return nil
+	}
+	return article.Metadata
+}
@@ -0,0 +1,273 @@
+package dbranch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipfs "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
+	mh "github.com/multiformats/go-multihash"
+)
+
+//
+// fallback for articles that are not pinned locally
+//
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (node *Node) gatewayFallbackEnabled() bool {
+	return len(node.config.Gateways) > 0 || len(node.config.RemoteIpfsApis) > 0
+}
+
+func (node *Node) fetchArticleFallback(ctx context.Context, article_cid string) ([]byte, error) {
+	/*
+		fetch an article that is not pinned locally. only the root block is fetched so the bytes can be verified
+		against the cid, articles are small enough to fit in a single block with the default chunker
+	*/
+	parsed, err := cid.Decode(article_cid)
+	if err != nil {
+		return nil, errors.New("invalid cid: " + err.Error())
+	}
+
+	// a previous fallback may have cached the block locally, offline prevents the node from searching the network
+	block, err := node.localBlockGet(ctx, article_cid)
+	if err == nil {
+		return unixfsBlockData(parsed, block)
+	}
+
+	for _, api := range node.config.RemoteIpfsApis {
+		block, err = node.remoteApiBlockGet(ctx, api, article_cid)
+		if err == nil {
+			err = verifyBlock(parsed, block)
+		}
+		if err != nil {
+			log.Printf("could not fetch: %s from ipfs api: %s: %s\n", article_cid, api, err)
+			continue
+		}
+		return node.cacheAndExtract(ctx, parsed, block)
+	}
+
+	for _, gateway := range node.config.Gateways {
+		block, err = node.gatewayBlockGet(ctx, gateway, article_cid)
+		if err == nil {
+			err = verifyBlock(parsed, block)
+		}
+		if err != nil {
+			log.Printf("could not fetch: %s from gateway: %s: %s\n", article_cid, gateway, err)
+			continue
+		}
+		return node.cacheAndExtract(ctx, parsed, block)
+	}
+
+	return nil, ErrArticleNotFound
+}
+
+func (node *Node) localBlockGet(ctx context.Context, article_cid string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, node.config.GatewayTimeout)
+	defer cancel()
+
+	resp, err := node.shell.Request("block/get", article_cid).Option("offline", true).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	return io.ReadAll(resp.Output)
+}
+
+func (node *Node) remoteApiBlockGet(ctx context.Context, host, article_cid string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, node.config.GatewayTimeout)
+	defer cancel()
+
+	resp, err := ipfs.NewShell(host).Request("block/get", article_cid).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Output, node.config.MaxArticleSize+1))
+}
+
+func (node *Node) gatewayBlockGet(ctx context.Context, gateway, article_cid string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, node.config.GatewayTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(gateway, "/") + "/ipfs/" + article_cid + "?format=raw"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+	resp, err := node.gateway_client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(url + " returned status: " + resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, node.config.MaxArticleSize+1))
+}
+
+func verifyBlock(expected cid.Cid, block []byte) error {
+	decoded, err := mh.Decode(expected.Hash())
+	if err != nil {
+		return err
+	}
+
+	sum, err := mh.Sum(block, decoded.Code, decoded.Length)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(sum, expected.Hash()) {
+		return errors.New("block does not match cid: " + expected.String())
+	}
+
+	return nil
+}
+
+func (node *Node) cacheAndExtract(ctx context.Context, parsed cid.Cid, block []byte) ([]byte, error) {
+	data, err := unixfsBlockData(parsed, block)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.config.GatewayCache {
+		// block/put stores the block without pinning it so it will be removed on the next garbage collection
+		format := "raw"
+		if parsed.Type() == cid.DagProtobuf {
+			format = "dag-pb"
+			if parsed.Version() == 0 {
+				format = "v0"
+			}
+		}
+
+		// same request as shell.BlockPut but bound to ctx so a stuck daemon can't hold up the fetch
+		ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
+		defer cancel()
+
+		decoded, _ := mh.Decode(parsed.Hash())
+		body := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", files.NewBytesFile(block))})
+		err := node.shell.Request("block/put").
+			Option("format", format).
+			Option("mhtype", decoded.Name).
+			Option("mhlen", decoded.Length).
+			Body(files.NewMultiFileReader(body, true)).
+			Exec(ctx, nil)
+		if err != nil {
+			log.Printf("could not cache: %s: %s\n", parsed, err)
+		}
+	}
+
+	return data, nil
+}
+
+//
+// minimal protobuf decoding for single block unixfs files
+//
+
+func protobufFields(data []byte) (map[uint64][][]byte, map[uint64]uint64, error) {
+	// returns length delimited fields and varint fields by field number
+	bytes_fields := map[uint64][][]byte{}
+	varint_fields := map[uint64]uint64{}
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, nil, errors.New("invalid protobuf key")
+		}
+		data = data[n:]
+
+		field, wire_type := key>>3, key&7
+		switch wire_type {
+		case 0:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, nil, errors.New("invalid protobuf varint")
+			}
+			varint_fields[field] = value
+			data = data[n:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, nil, errors.New("invalid protobuf length")
+			}
+			data = data[n:]
+			bytes_fields[field] = append(bytes_fields[field], data[:length])
+			data = data[length:]
+		default:
+			return nil, nil, fmt.Errorf("unsupported protobuf wire type: %d", wire_type)
+		}
+	}
+
+	return bytes_fields, varint_fields, nil
+}
+
+func unixfsBlockData(parsed cid.Cid, block []byte) ([]byte, error) {
+	if parsed.Type() == cid.Raw {
+		return block, nil
+	}
+
+	if parsed.Type() != cid.DagProtobuf {
+		return nil, fmt.Errorf("unsupported cid codec: %d", parsed.Type())
+	}
+
+	// dag-pb node: 1 = data, 2 = links
+	node, _, err := protobufFields(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(node[2]) > 0 {
+		return nil, errors.New("article spans multiple blocks and can not be fetched from a gateway")
+	}
+
+	if len(node[1]) == 0 {
+		return []byte{}, nil
+	}
+
+	// unixfs data: 1 = type, 2 = data
+	unixfs, types, err := protobufFields(node[1][0])
+	if err != nil {
+		return nil, err
+	}
+
+	if unixfs_type := types[1]; unixfs_type != 0 && unixfs_type != 2 {
+		return nil, fmt.Errorf("cid is not a file, unixfs type: %d", unixfs_type)
+	}
+
+	if len(unixfs[2]) == 0 {
+		return []byte{}, nil
+	}
+
+	return unixfs[2][0], nil
+}
@@ -0,0 +1,73 @@
+package dbranch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func newGatewayNode(t *testing.T, load_index bool) (*Node, *fakeIpfs, string) {
+	/* a node that only has the article on a gateway, returns the raw cid of test_article */
+	t.Helper()
+	hash, err := mh.Sum([]byte(test_article), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	article_cid := cid.NewCidV1(cid.Raw, hash).String()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(test_article))
+	}))
+	t.Cleanup(gateway.Close)
+
+	fake, shell := newFakeIpfs(t)
+	fake.fail("block/get", "block not found locally (offline)")
+	if load_index {
+		index := &ArticleIndex{CuratedArticles: []*ArticleIndexItem{{Record: &ArticleRecord{Name: "article", CID: article_cid}}}}
+		fake.respond("files/read", index)
+	} else {
+		fake.fail("files/read", "file does not exist")
+	}
+
+	config := DefaultConfig()
+	config.Gateways = []string{gateway.URL}
+	config.GatewayCache = true
+	return newTestNode(t, config, shell), fake, article_cid
+}
+
+func TestGatewayFallbackCache(t *testing.T) {
+	node, fake, article_cid := newGatewayNode(t, false)
+
+	article, err := node.GetArticleByCID(context.Background(), article_cid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Metadata.Title != "Title" {
+		t.Errorf("expected article title: Title, got: %s", article.Metadata.Title)
+	}
+	if !fake.called("block/put") {
+		t.Errorf("expected the block to be cached, got: %v", fake.calls)
+	}
+}
+
+func TestGatewayFallbackRecord(t *testing.T) {
+	// an unpinned article can still be in the index, one that isn't is returned without a record
+	for _, indexed := range []bool{true, false} {
+		node, _, article_cid := newGatewayNode(t, indexed)
+
+		article, err := node.GetArticleByCID(context.Background(), article_cid, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if indexed && (article.Record == nil || article.Record.Name != "article") {
+			t.Errorf("expected the record from the index, got: %+v", article.Record)
+		} else if !indexed && article.Record != nil {
+			t.Errorf("expected no record, got: %+v", article.Record)
+		}
+	}
+}
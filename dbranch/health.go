@@ -0,0 +1,247 @@
+package dbranch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// health and readiness checks for the server, suitable for container orchestrators
+//
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+
+	// overall status when only checks that aren't required for readiness fail
+	HealthDegraded = "degraded"
+)
+
+type HealthCheck struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Required bool    `json:"required"` // a failure makes the server not ready
+	Latency  float64 `json:"latency_ms"`
+	Detail   string  `json:"detail,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string         `json:"status"`
+	Time   time.Time      `json:"time"`
+	Uptime string         `json:"uptime"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+//
+// daemon heartbeat
+//
+
+func (node *Node) heartbeatPath() string {
+	return path.Join(node.config.Dir, "daemon_heartbeat")
+}
+
+func (node *Node) saveHeartbeat() {
+	now := time.Now().UTC()
+	node.daemon_state.lock.Lock()
+	node.daemon_state.heartbeat = now
+	node.daemon_state.lock.Unlock()
+
+	err := os.WriteFile(node.heartbeatPath(), []byte(now.Format(time.RFC3339)), 0644)
+	if err != nil {
+		node.heartbeat_error.Do(func() {
+			log.Printf("could not write daemon heartbeat: %s\n", err)
+		})
+	}
+}
+
+func (node *Node) loadHeartbeat() (time.Time, error) {
+	node.daemon_state.lock.Lock()
+	heartbeat := node.daemon_state.heartbeat
+	node.daemon_state.lock.Unlock()
+	if !heartbeat.IsZero() {
+		return heartbeat, nil
+	}
+
+	data, err := os.ReadFile(node.heartbeatPath())
+	if os.IsNotExist(err) {
+		return time.Time{}, errors.New("no heartbeat, the daemon has not run")
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+//
+// checks
+//
+
+type healthCheckFunc func(ctx context.Context) (string, error)
+
+func (node *Node) checkIpfs(ctx context.Context) (string, error) {
+	var version struct {
+		Version string
+	}
+	err := node.shell.Request("version").Exec(ctx, &version)
+	if err != nil {
+		return "", err
+	}
+	return "version " + version.Version, nil
+}
+
+func (node *Node) checkPubsub(ctx context.Context) (string, error) {
+	// fails when the ipfs daemon runs without --enable-pubsub-experiment
+	var topics struct {
+		Strings []string
+	}
+	err := node.shell.Request("pubsub/ls").Exec(ctx, &topics)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d topics", len(topics.Strings)), nil
+}
+
+func (node *Node) checkPostgres(ctx context.Context) (string, error) {
+	start := time.Now()
+	err := node.db.PingContext(ctx)
+	observeBackend("postgres", "ping", start, err)
+	return "", err
+}
+
+func (node *Node) checkDBSync(ctx context.Context) (string, error) {
+	var last_block_time time.Time
+	start := time.Now()
+	err := node.db.QueryRowContext(ctx, "select max(time) from block;").Scan(&last_block_time)
+	observeBackend("postgres", "last_block_time", start, err)
+	if err != nil {
+		return "", err
+	}
+
+	// block times are stored in utc without a time zone
+	lag := time.Since(last_block_time).Round(time.Second)
+	detail := "last block " + lag.String() + " ago"
+	if lag > node.config.MaxSyncLag {
+		return detail, errors.New("db-sync is behind by " + lag.String())
+	}
+	return detail, nil
+}
+
+func (node *Node) checkWallet(ctx context.Context) (string, error) {
+	status, err := node.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	if status != "ready" {
+		return status, errors.New("wallet is not synced: " + status)
+	}
+	return status, nil
+}
+
+func (node *Node) checkDaemon(ctx context.Context) (string, error) {
+	heartbeat, err := node.loadHeartbeat()
+	if err != nil {
+		return "", err
+	}
+
+	age := time.Since(heartbeat).Round(time.Second)
+	detail := "last heartbeat " + age.String() + " ago"
+	if age > node.config.MaxHeartbeatAge {
+		return detail, errors.New("daemon heartbeat is stale")
+	}
+	return detail, nil
+}
+
+type namedHealthCheck struct {
+	name  string
+	check healthCheckFunc
+}
+
+func (node *Node) healthChecks() []namedHealthCheck {
+	return []namedHealthCheck{
+		{"ipfs", node.checkIpfs},
+		{"pubsub", node.checkPubsub},
+		{"postgres", node.checkPostgres},
+		{"db_sync", node.checkDBSync},
+		{"wallet", node.checkWallet},
+		{"daemon", node.checkDaemon},
+	}
+}
+
+func (node *Node) runHealthCheck(ctx context.Context, name string, check healthCheckFunc) *HealthCheck {
+	result := &HealthCheck{Name: name, Status: HealthOK, Required: containsFold(node.config.ReadyChecks, name)}
+
+	ctx, cancel := context.WithTimeout(ctx, node.config.HealthTimeout)
+	defer cancel()
+
+	type outcome struct {
+		detail string
+		err    error
+	}
+
+	// checks that don't take a context (the wallet client) still report a timeout
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		detail, err := check(ctx)
+		done <- outcome{detail, err}
+	}()
+
+	select {
+	case finished := <-done:
+		result.Detail = finished.detail
+		if finished.err != nil {
+			result.Status = HealthFail
+			result.Error = finished.err.Error()
+		}
+	case <-ctx.Done():
+		result.Status = HealthFail
+		result.Error = ctx.Err().Error()
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Error = "timed out after " + node.config.HealthTimeout.String()
+		}
+	}
+
+	result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	return result
+}
+
+func (node *Node) CheckHealth(ctx context.Context) *HealthReport {
+	/* run every check concurrently, the report is failed if a required check fails and degraded if any other does */
+	health_checks := node.healthChecks()
+	report := &HealthReport{
+		Status: HealthOK,
+		Time:   time.Now().UTC(),
+		Uptime: time.Since(process_start).Round(time.Second).String(),
+		Checks: make([]*HealthCheck, len(health_checks)),
+	}
+
+	var wait sync.WaitGroup
+	for index, health_check := range health_checks {
+		wait.Add(1)
+		go func(index int, name string, check healthCheckFunc) {
+			defer wait.Done()
+			report.Checks[index] = node.runHealthCheck(ctx, name, check)
+		}(index, health_check.name, health_check.check)
+	}
+	wait.Wait()
+
+	for _, check := range report.Checks {
+		if check.Status == HealthOK {
+			continue
+		}
+		if check.Required {
+			report.Status = HealthFail
+		} else if report.Status == HealthOK {
+			report.Status = HealthDegraded
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,105 @@
+package dbranch
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet/wallettest"
+)
+
+func newHealthNode(t *testing.T, config *Config) (*Node, *fakeIpfs) {
+	/* a node whose ipfs and postgres checks pass, db-sync and the daemon checks fail */
+	t.Helper()
+	server := wallettest.NewServer()
+	t.Cleanup(server.Close)
+
+	fake, shell := newFakeIpfs(t)
+	fake.respond("version", map[string]string{"Version": "0.12.0"})
+	_, db := newFakeDBSync(t)
+	return newTestNode(t, config, shell, db, WithWalletClient(server.WalletClient())), fake
+}
+
+func healthRequest(t *testing.T, node *Node, target string) (int, *HealthReport) {
+	t.Helper()
+	response := apiRequest(t, node.newCuratorServer(), http.MethodGet, target, "", nil)
+	report := &HealthReport{}
+	err := json.NewDecoder(response.Body).Decode(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.Code, report
+}
+
+func reportCheck(report *HealthReport, name string) *HealthCheck {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return &HealthCheck{}
+}
+
+func TestReadyz(t *testing.T) {
+	// only the checks in ReadyChecks make the server not ready, the others degrade it
+	node, _ := newHealthNode(t, nil)
+	code, report := healthRequest(t, node, "/readyz")
+	if code != http.StatusOK || report.Status != HealthDegraded {
+		t.Errorf("expected ready and degraded, got %d: %s", code, report.Status)
+	}
+
+	node, fake := newHealthNode(t, nil)
+	fake.fail("version", "ipfs is down")
+	code, report = healthRequest(t, node, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != HealthFail {
+		t.Errorf("expected not ready, got %d: %s", code, report.Status)
+	}
+	if check := reportCheck(report, "ipfs"); check.Status != HealthFail || !check.Required || !strings.Contains(check.Error, "ipfs is down") {
+		t.Errorf("expected the failed required ipfs check, got: %+v", check)
+	}
+
+	// healthz reports the same without failing
+	code, report = healthRequest(t, node, "/healthz")
+	if code != http.StatusOK || report.Status != HealthFail {
+		t.Errorf("expected healthz to be 200 with the failed status, got %d: %s", code, report.Status)
+	}
+}
+
+func TestReadyzRequiredChecks(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadyChecks = []string{"ipfs", "daemon"}
+	node, _ := newHealthNode(t, config)
+
+	code, report := healthRequest(t, node, "/readyz")
+	if code != http.StatusServiceUnavailable || !reportCheck(report, "daemon").Required {
+		t.Errorf("expected not ready without a daemon heartbeat, got %d: %+v", code, reportCheck(report, "daemon"))
+	}
+
+	node.saveHeartbeat()
+	code, report = healthRequest(t, node, "/readyz")
+	if code != http.StatusOK {
+		t.Errorf("expected ready once the daemon has a heartbeat, got %d: %+v", code, reportCheck(report, "daemon"))
+	}
+}
+
+func TestReadyzTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.HealthTimeout = 50 * time.Millisecond
+	node, fake := newHealthNode(t, config)
+
+	release := make(chan struct{})
+	defer close(release)
+	fake.handle("version", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	code, report := healthRequest(t, node, "/readyz")
+	if check := reportCheck(report, "ipfs"); code != http.StatusServiceUnavailable || !strings.HasPrefix(check.Error, "timed out") {
+		t.Errorf("expected the ipfs check to time out, got %d: %+v", code, check)
+	}
+}
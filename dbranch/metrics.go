@@ -0,0 +1,273 @@
+package dbranch
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+//
+// prometheus metrics in the text exposition format
+//
+
+type metric struct {
+	name        string
+	help        string
+	kind        string // counter, gauge or histogram
+	label_names []string
+	buckets     []float64
+
+	lock   sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels []string
+	value  float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+var metrics_registry = []*metric{}
+
+// seconds, from a fast ipfs call to a slow wallet transaction
+var default_buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var process_start = time.Now()
+
+var (
+	daemon_loop_duration = newMetric("histogram", "dbranch_daemon_loop_duration_seconds", "Time taken by each iteration of the curator daemon loop.", []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300})
+	daemon_records_found = newMetric("counter", "dbranch_daemon_records_found_total", "Article records found on chain for followed addresses.", nil)
+	daemon_curations     = newMetric("counter", "dbranch_daemon_curations_total", "Articles the daemon attempted to curate by result.", nil, "result")
+	daemon_block_lag     = newMetric("gauge", "dbranch_daemon_block_lag", "Blocks between the chain tip and the last block processed by the daemon.", nil)
+	daemon_last_block    = newMetric("gauge", "dbranch_daemon_last_block", "Last block number processed by the daemon.", nil)
+	daemon_last_loop     = newMetric("gauge", "dbranch_daemon_last_loop_timestamp_seconds", "Unix time the daemon loop last completed.", nil)
+
+	component_restarts = newMetric("counter", "dbranch_component_restarts_total", "Crashed components restarted by the supervisor.", nil, "component")
+	wire_messages      = newMetric("counter", "dbranch_wire_messages_total", "Messages received on the wire channel by result.", nil, "result")
+
+	http_requests         = newMetric("counter", "dbranch_http_requests_total", "Requests handled by the curator server.", nil, "method", "route", "status")
+	http_request_duration = newMetric("histogram", "dbranch_http_request_duration_seconds", "Latency of requests handled by the curator server.", default_buckets, "method", "route")
+
+	backend_request_duration = newMetric("histogram", "dbranch_backend_request_duration_seconds", "Latency of calls to ipfs, postgres, the cardano wallet and other services.", default_buckets, "backend", "operation")
+	backend_errors           = newMetric("counter", "dbranch_backend_errors_total", "Failed calls to ipfs, postgres, the cardano wallet and other services.", nil, "backend", "operation")
+)
+
+func newMetric(kind, name, help string, buckets []float64, label_names ...string) *metric {
+	m := &metric{name: name, help: help, kind: kind, label_names: label_names, buckets: buckets, series: map[string]*metricSeries{}}
+	if len(label_names) == 0 {
+		// unlabeled metrics are exported from the start so they read 0 rather than missing
+		m.get()
+	}
+	metrics_registry = append(metrics_registry, m)
+	return m
+}
+
+func (m *metric) get(labels ...string) *metricSeries {
+	// callers hold the lock except during registration
+	key := strings.Join(labels, "\xff")
+	series, ok := m.series[key]
+	if !ok {
+		series = &metricSeries{labels: labels}
+		if m.kind == "histogram" {
+			series.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = series
+	}
+	return series
+}
+
+func (m *metric) Add(value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.get(labels...).value += value
+}
+
+func (m *metric) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+func (m *metric) Set(value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.get(labels...).value = value
+}
+
+func (m *metric) Observe(value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	series := m.get(labels...)
+	for index, bound := range m.buckets {
+		if value <= bound {
+			series.counts[index]++
+			break
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func observeBackend(backend, operation string, start time.Time, err error) {
+	backend_request_duration.Observe(time.Since(start).Seconds(), backend, operation)
+	if err != nil {
+		backend_errors.Inc(backend, operation)
+	}
+}
+
+//
+// exposition
+//
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func formatMetricLabels(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for index, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(values[index]))
+	}
+	for index := 0; index+1 < len(extra); index += 2 {
+		pairs = append(pairs, extra[index]+"="+strconv.Quote(extra[index+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metric) write(out io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(out, "%s%s %s\n", m.name, formatMetricLabels(m.label_names, series.labels), formatMetricValue(series.value))
+			continue
+		}
+
+		var cumulative uint64
+		for index, bound := range m.buckets {
+			cumulative += series.counts[index]
+			fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, formatMetricLabels(m.label_names, series.labels, "le", formatMetricValue(bound)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, formatMetricLabels(m.label_names, series.labels, "le", "+Inf"), series.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", m.name, formatMetricLabels(m.label_names, series.labels), formatMetricValue(series.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", m.name, formatMetricLabels(m.label_names, series.labels), series.count)
+	}
+}
+
+func WriteMetrics(out io.Writer) {
+	fmt.Fprintf(out, "# HELP process_start_time_seconds Start time of the process since unix epoch in seconds.\n# TYPE process_start_time_seconds gauge\nprocess_start_time_seconds %d\n", process_start.Unix())
+	for _, m := range metrics_registry {
+		m.write(out)
+	}
+}
+
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
+
+//
+// instrumentation
+//
+
+func metricsMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(e echo.Context) error {
+			start := time.Now()
+			err := next(e)
+
+			// the route pattern rather than the path so cids and names don't each get their own series
+			route := e.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			status := e.Response().Status
+			if http_err, ok := err.(*echo.HTTPError); ok {
+				status = http_err.Code
+			} else if err != nil {
+				status = http.StatusInternalServerError
+			}
+
+			method := e.Request().Method
+			http_requests.Inc(method, route, strconv.Itoa(status))
+			http_request_duration.Observe(time.Since(start).Seconds(), method, route)
+			return err
+		}
+	}
+}
+
+type instrumentedTransport struct {
+	backend string
+	next    http.RoundTripper
+}
+
+func instrumentClient(backend string, client *http.Client) *http.Client {
+	/* record latency and errors of every request made with client, returns client for convenience */
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &instrumentedTransport{backend: backend, next: next}
+	return client
+}
+
+func metricOperation(request_path string) string {
+	// ids, hashes and cids are replaced so each endpoint is one series
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(strings.TrimPrefix(request_path, "/api/v0/"), "/"), "/") {
+		if len(segments) == 4 {
+			break
+		}
+		if len(segment) >= 32 {
+			segment = ":id"
+		}
+		segments = append(segments, segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (transport *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := transport.next.RoundTrip(req)
+
+	failed := err
+	if err == nil && resp.StatusCode >= 400 {
+		failed = fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	observeBackend(transport.backend, metricOperation(req.URL.Path), start, failed)
+	return resp, err
+}
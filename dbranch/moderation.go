@@ -0,0 +1,189 @@
+package dbranch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+)
+
+//
+// moderation queue, articles are staged in the pending dir until a moderator approves or rejects them
+//
+
+const PendingDir = "/dBranch/pending"
+
+func (node *Node) ListModerationDecisions() ([]*AuditEntry, error) {
+	/* moderation decisions are kept in the audit log alongside every other curation action */
+	decisions := []*AuditEntry{}
+
+	err := node.readAuditLog(func(entry *AuditEntry) error {
+		if entry.Action == "stage" || entry.Action == "approve" || entry.Action == "reject" {
+			decisions = append(decisions, entry)
+		}
+		return nil
+	})
+
+	return decisions, err
+}
+
+func (node *Node) StageArticle(ctx context.Context, record *ArticleRecord, actor *AuditActor) error {
+	err := node.stageArticle(ctx, record)
+	node.audit(actor, "stage", record, "", err)
+	if err == nil {
+		log.Printf("staged article for review: %s\n", record.Name)
+	}
+	return err
+}
+
+func (node *Node) stageArticle(ctx context.Context, record *ArticleRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsCopyTimeout)
+	defer cancel()
+
+	ipfs_source := path.Join("/ipfs", record.CID)
+	article_path := path.Join(PendingDir, record.Name)
+	record_path := article_path + ".json"
+
+	err := node.checkBlocklist(record, "")
+	if err != nil {
+		return err
+	}
+
+	// invalid articles are rejected right away rather than wasting a moderator's time
+	_, err = node.catArticle(ctx, ipfs_source)
+	if err != nil {
+		node.recordRejection(record, err)
+		return err
+	}
+
+	err = node.shell.FilesMkdir(ctx, PendingDir, ipfs.FilesMkdir.Parents(true))
+	if err != nil {
+		return err
+	}
+
+	err = node.shell.FilesCp(ctx, ipfs_source, article_path)
+	if err != nil {
+		return err
+	}
+
+	// pin so the article stays available while it waits for review
+	err = node.ipfsPin(ctx, record.CID)
+	if err != nil {
+		node.shell.FilesRm(ctx, article_path, true)
+		return err
+	}
+
+	mashalled_record, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	err = node.shell.FilesWrite(ctx, record_path, bytes.NewReader(mashalled_record), ipfs.FilesWrite.Create(true), ipfs.FilesWrite.Truncate(true))
+	if err != nil {
+		return errors.New("Error writing article record to: " + record_path + ": " + err.Error())
+	}
+
+	return nil
+}
+
+func (node *Node) ListPendingArticles(ctx context.Context) ([]*ArticleIndexItem, error) {
+	items := []*ArticleIndexItem{}
+
+	names, err := node.listArticles(ctx, PendingDir)
+	if err != nil {
+		if ipfsNotExist(err) {
+			return items, nil
+		}
+		return items, err
+	}
+
+	for _, name := range names {
+		article, err := node.GetArticleByMFSPath(ctx, path.Join(PendingDir, name))
+		if err != nil {
+			return items, err
+		}
+		items = append(items, &ArticleIndexItem{Record: article.Record, Metadata: article.Metadata})
+	}
+
+	return items, nil
+}
+
+func (node *Node) removePendingFiles(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
+	defer cancel()
+
+	article_path := path.Join(PendingDir, name)
+
+	err := node.shell.FilesRm(ctx, article_path+".json", true)
+	if err != nil {
+		return err
+	}
+
+	return node.shell.FilesRm(ctx, article_path, true)
+}
+
+func (node *Node) loadPendingRecord(ctx context.Context, name string) (*ArticleRecord, error) {
+	record, err := node.loadArticleRecord(ctx, path.Join(PendingDir, name+".json"))
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, fmt.Errorf("pending %w: %s", ErrArticleNotFound, name)
+	}
+	return record, err
+}
+
+func (node *Node) ApprovePendingArticle(ctx context.Context, name string, actor *AuditActor) (*ArticleRecord, error) {
+	record, err := node.loadPendingRecord(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.AddRecordToLocal(ctx, CuratedDir, record, true, actor)
+	if err != nil {
+		node.audit(actor, "approve", record, "", err)
+		return nil, err
+	}
+
+	err = node.removePendingFiles(ctx, name)
+	if err != nil {
+		log.Printf("could not remove pending files for: %s: %s\n", name, err)
+	}
+
+	node.audit(actor, "approve", record, "", nil)
+	log.Printf("article approved: %s\n", name)
+	return record, nil
+}
+
+func (node *Node) RejectPendingArticle(ctx context.Context, name string, actor *AuditActor, reason string) (*ArticleRecord, error) {
+	if reason == "" {
+		return nil, invalidInput("a reason is required to reject an article")
+	}
+
+	record, err := node.loadPendingRecord(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.removePendingFiles(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.referencedElsewhere(ctx, name)[record.CID] {
+		err = node.ipfsUnpin(ctx, path.Join("/ipfs", record.CID))
+		if err != nil {
+			log.Printf("could not unpin: %s: %s\n", record.CID, err)
+		}
+	}
+
+	if actor == nil {
+		actor = &node.default_actor
+	}
+
+	node.recordRejection(record, errors.New("rejected by "+actor.Name+": "+reason))
+	node.audit(actor, "reject", record, reason, nil)
+	return record, nil
+}
@@ -0,0 +1,196 @@
+package dbranch
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+	ipfs "github.com/ipfs/go-ipfs-api"
+	_ "github.com/lib/pq"
+)
+
+//
+// a curator node, holding its config, clients and in process state
+//
+
+type Node struct {
+	config *Config
+
+	shell  *ipfs.Shell
+	db     *sql.DB
+	wallet *wallet.Client
+
+	gateway_client   *http.Client
+	pinning_client   *http.Client
+	blocklist_client *http.Client
+	webhook_client   *http.Client
+
+	events *EventBus
+
+	// wakes the webhook worker of a daemon or server running in this process when deliveries are queued
+	webhook_wakeup chan struct{}
+
+	// actor recorded for actions that don't have an explicit one, set by the cli, daemon or server at startup
+	default_actor AuditActor
+
+	// state of a daemon running on this node, other processes read it from files in the config dir
+	daemon_state struct {
+		lock       sync.Mutex
+		running    bool
+		last_block uint
+		heartbeat  time.Time
+	}
+
+	// held while curating so the poller and wire listener don't add the same record at once
+	curation_lock sync.Mutex
+
+	// guard state files within this process, a file lock guards the audit log and webhook queue between processes
+	audit_lock         sync.Mutex
+	admin_keys_lock    sync.Mutex
+	blocklist_lock     sync.Mutex
+	replicas_lock      sync.Mutex
+	webhook_queue_lock sync.Mutex
+
+	pending_signatures_lock sync.Mutex
+
+	// challenges are only held in memory, a restart requires clients to request a new one
+	challenges      map[string]*WalletChallenge
+	challenges_lock sync.Mutex
+
+	// only the first failure is logged so a read only home dir doesn't flood the daemon log
+	heartbeat_error sync.Once
+
+	owns_db bool
+}
+
+type Option func(node *Node)
+
+func WithDB(db *sql.DB) Option {
+	/* use an existing db-sync connection instead of opening one from the config, it is not closed by Close */
+	return func(node *Node) {
+		node.db = db
+	}
+}
+
+func WithIpfsShell(shell *ipfs.Shell) Option {
+	return func(node *Node) {
+		node.shell = shell
+	}
+}
+
+func WithWalletClient(client *wallet.Client) Option {
+	/* cardano wallet client, ie. one for a wallettest.Server in tests */
+	return func(node *Node) {
+		node.wallet = client
+	}
+}
+
+func WithAuditActor(actor AuditActor) Option {
+	return func(node *Node) {
+		node.default_actor = actor
+	}
+}
+
+func NewNode(config *Config, options ...Option) (*Node, error) {
+	/*
+		create a node from config, nil for the default config
+		no connections are made here, postgres is connected to on first use
+	*/
+	if config == nil {
+		config = DefaultConfig()
+	}
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// copied so the caller changing their config doesn't change the node
+	node_config := *config
+	node := &Node{
+		config:        &node_config,
+		default_actor: AuditActor{Name: "unknown", Source: "cli"},
+		challenges:    map[string]*WalletChallenge{},
+	}
+
+	if node.config.Dir == "" {
+		home_dir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.New("cannot find user home dir: " + err.Error())
+		}
+		node.config.Dir = path.Join(home_dir, ".dbranch")
+	}
+	err = os.MkdirAll(node.config.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		option(node)
+	}
+
+	if node.shell == nil {
+		// same transport as ipfs.NewShell, wrapped to record metrics
+		node.shell = ipfs.NewShellWithClient(node.config.IpfsHost, instrumentClient("ipfs", &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true},
+		}))
+	}
+
+	if node.db == nil {
+		conn_str := fmt.Sprintf("postgresql://%s@%s/%s?sslmode=%s",
+			url.UserPassword(node.config.PostgresUser, node.config.PostgresPassword).String(),
+			node.config.PostgresHost, node.config.PostgresDB, url.QueryEscape(node.config.PostgresSSLMode))
+
+		node.db, err = sql.Open("postgres", conn_str)
+		if err != nil {
+			return nil, err
+		}
+		node.owns_db = true
+	}
+
+	if node.wallet == nil {
+		node.wallet = wallet.NewClient(node.config.WalletHost, instrumentClient("wallet", &http.Client{}))
+		node.wallet.Timeout = node.config.WalletTimeout
+	}
+
+	node.gateway_client = instrumentClient("gateway", &http.Client{})
+	node.pinning_client = instrumentClient("pinning", &http.Client{Timeout: 30 * time.Second})
+	node.blocklist_client = instrumentClient("blocklist", &http.Client{Timeout: 30 * time.Second})
+	node.webhook_client = instrumentClient("webhook", &http.Client{Timeout: 15 * time.Second})
+
+	node.webhook_wakeup = make(chan struct{}, 1)
+	node.events = NewEventBus(event_history_size)
+	node.events.OnPublish(node.enqueueWebhooks)
+
+	return node, nil
+}
+
+func (node *Node) Close() error {
+	/* close the db-sync connection if the node opened it */
+	if node.owns_db {
+		return node.db.Close()
+	}
+	return nil
+}
+
+func (node *Node) Config() Config {
+	return *node.config
+}
+
+func (node *Node) Events() *EventBus {
+	return node.events
+}
+
+func (node *Node) statePath(override, name string) string {
+	/* path of a state file, override if it was configured */
+	if override != "" {
+		return override
+	}
+	return path.Join(node.config.Dir, name)
+}
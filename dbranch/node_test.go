@@ -0,0 +1,185 @@
+package dbranch
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+)
+
+func newTestNode(t *testing.T, config *Config, options ...Option) *Node {
+	/* a node with its state in a temp dir, nil config for the defaults */
+	t.Helper()
+	if config == nil {
+		config = DefaultConfig()
+	}
+	config.Dir = t.TempDir()
+
+	node, err := NewNode(config, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+// a stand-in for the ipfs http api that records calls, commands without a handler succeed with an empty object
+type fakeIpfs struct {
+	lock     sync.Mutex
+	calls    []string // command and args, ie. "files/rm /dBranch/curated/article"
+	handlers map[string]http.HandlerFunc
+}
+
+func newFakeIpfs(t *testing.T) (*fakeIpfs, Option) {
+	t.Helper()
+	fake := &fakeIpfs{handlers: map[string]http.HandlerFunc{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, WithIpfsShell(ipfs.NewShell(server.URL))
+}
+
+func (fake *fakeIpfs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	command := strings.TrimPrefix(r.URL.Path, "/api/v0/")
+
+	fake.lock.Lock()
+	fake.calls = append(fake.calls, strings.Join(append([]string{command}, r.URL.Query()["arg"]...), " "))
+	handler := fake.handlers[command]
+	fake.lock.Unlock()
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+func (fake *fakeIpfs) handle(command string, handler http.HandlerFunc) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.handlers[command] = handler
+}
+
+func (fake *fakeIpfs) fail(command, message string) {
+	fake.handle(command, func(w http.ResponseWriter, r *http.Request) {
+		// the shell only decodes the error message from json responses
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(&ipfs.Error{Command: command, Message: message})
+	})
+}
+
+func (fake *fakeIpfs) respond(command string, value interface{}) {
+	fake.handle(command, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(value)
+	})
+}
+
+func (fake *fakeIpfs) called(call string) bool {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	for _, made := range fake.calls {
+		if made == call {
+			return true
+		}
+	}
+	return false
+}
+
+// a stand-in for db-sync that answers every query with the label 451 rows of the txs added to it
+type fakeDBSync struct {
+	lock    sync.Mutex
+	records []CardanoArticleRecord
+}
+
+func newFakeDBSync(t *testing.T) (*fakeDBSync, Option) {
+	t.Helper()
+	fake := &fakeDBSync{}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	return fake, WithDB(db)
+}
+
+func (fake *fakeDBSync) addTx(tx_hash string, articles ...SignedArticle) {
+	/* add a tx that signs articles, like db-sync seeing it in a block */
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	tx_raw, _ := hex.DecodeString(tx_hash)
+	for index, article := range articles {
+		fake.records = append(fake.records, CardanoArticleRecord{
+			Name:          article.Name,
+			Location:      article.Location,
+			Address:       "addr_test1",
+			TxId:          int64(len(fake.records) + 1),
+			TxHashRaw:     tx_raw,
+			BlockNumber:   1,
+			Index:         index,
+			DatePublished: time.Now().UTC(),
+		})
+	}
+}
+
+func (fake *fakeDBSync) Connect(ctx context.Context) (driver.Conn, error) { return fake, nil }
+func (fake *fakeDBSync) Driver() driver.Driver                            { return nil }
+func (fake *fakeDBSync) Prepare(query string) (driver.Stmt, error)        { return fake, nil }
+func (fake *fakeDBSync) Begin() (driver.Tx, error)                        { return nil, errors.New("not supported") }
+func (fake *fakeDBSync) Close() error                                     { return nil }
+func (fake *fakeDBSync) NumInput() int                                    { return -1 }
+
+func (fake *fakeDBSync) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fake *fakeDBSync) Query(args []driver.Value) (driver.Rows, error) {
+	// only the tx hash filter is supported, other queries return every record
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	rows := &fakeRows{}
+	for _, record := range fake.records {
+		matches := true
+		for _, arg := range args {
+			if tx_raw, ok := arg.([]byte); ok {
+				matches = bytes.Equal(tx_raw, record.TxHashRaw)
+			}
+		}
+		if matches {
+			rows.values = append(rows.values, []driver.Value{
+				record.Name, record.Location, record.Address, record.TxId, []byte(record.TxHashRaw),
+				record.DatePublished, int64(record.BlockNumber), nil, int64(record.Index),
+			})
+		}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (rows *fakeRows) Columns() []string {
+	return []string{"name", "loc", "address", "id", "hash", "time", "block_no", "view", "index"}
+}
+
+func (rows *fakeRows) Close() error { return nil }
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.values[0])
+	rows.values = rows.values[1:]
+	return nil
+}
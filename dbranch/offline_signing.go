@@ -0,0 +1,207 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+)
+
+//
+// signing articles outside the wallet, ie. with cold keys and cardano-cli
+//
+
+// a cardano-cli text envelope, the format cardano-cli transaction build writes and transaction sign reads and writes
+type TextEnvelope struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	CborHex     string `json:"cborHex"`
+}
+
+type ExportedSignature struct {
+	Estimate  *SignatureEstimate `json:"estimate"`
+	Signature *PendingSignature  `json:"signature"`
+	Envelope  *TextEnvelope      `json:"envelope"`
+}
+
+func envelopeEra(node_era string) (string, error) {
+	/* the era in envelope types, ie. ConwayEra for the wallet's conway */
+	if node_era == "" {
+		return "", errors.New("the wallet did not report the node's era, which is needed for the tx envelope")
+	}
+	return strings.ToUpper(node_era[:1]) + node_era[1:] + "Era", nil
+}
+
+func (node *Node) ExportSignature(ctx context.Context, request SignRequest, actor *AuditActor) (*ExportedSignature, error) {
+	/*
+		build the tx signing an article without signing it, for wallets whose keys aren't in cardano-wallet
+		the wallet only needs the account public key to select coins, ie. a wallet restored from an account xpub
+		the tx is tracked as unsigned until it is returned to SubmitSignedSignature, it expires after Config.SignatureTimeout
+	*/
+	articles, err := node.signedArticles(ctx, []string{request.Path})
+	if err != nil {
+		return nil, err
+	}
+
+	estimate, err := node.prepareSignature(ctx, request.WalletID, request.Address, request.Amount, articles)
+	if err != nil {
+		return nil, err
+	}
+	err = node.constructSignature(ctx, estimate)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := node.wallet.DecodeTransaction(ctx, estimate.WalletID, estimate.Transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := node.wallet.NetworkInformation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	era, err := envelopeEra(info.NodeEra)
+	if err != nil {
+		return nil, err
+	}
+
+	cbor, err := wallet.TransactionBytes(estimate.Transaction)
+	if err != nil {
+		return nil, errors.New("error reading the tx constructed by the wallet: " + err.Error())
+	}
+
+	if actor == nil {
+		actor = &node.default_actor
+	}
+	now := time.Now().UTC()
+	signature := &PendingSignature{
+		TxHash:    decoded.ID,
+		WalletID:  estimate.WalletID,
+		Articles:  estimate.Articles,
+		Actor:     actor.Name,
+		Source:    actor.Source,
+		Status:    SignatureUnsigned,
+		External:  true,
+		Submitted: now,
+		Expires:   now.Add(node.config.SignatureTimeout),
+	}
+
+	err = node.trackSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExportedSignature{
+		Estimate:  estimate,
+		Signature: signature,
+		Envelope:  &TextEnvelope{Type: "Unwitnessed Tx " + era, Description: "Ledger Cddl Format", CborHex: hex.EncodeToString(cbor)},
+	}, nil
+}
+
+func (node *Node) SubmitSignedSignature(ctx context.Context, envelope *TextEnvelope, actor *AuditActor) (*PendingSignature, error) {
+	/*
+		submit a tx from ExportSignature once it was signed outside the wallet, ie. with cardano-cli transaction sign
+		only txs this node exported are submitted, from then on the tx is published like one from SignArticle
+	*/
+	if envelope == nil || envelope.CborHex == "" {
+		return nil, invalidInput("a signed tx envelope with cborHex is required")
+	}
+	if !strings.Contains(envelope.Type, "Tx") || !strings.HasSuffix(envelope.Type, "Era") {
+		return nil, invalidInput("not a cardano-cli tx envelope: %s", envelope.Type)
+	}
+	cbor, err := hex.DecodeString(strings.TrimSpace(envelope.CborHex))
+	if err != nil {
+		return nil, invalidInput("cborHex of the tx envelope is not hex")
+	}
+
+	pending, err := node.ListPendingSignatures()
+	if err != nil {
+		return nil, err
+	}
+	unsigned := []*PendingSignature{}
+	for _, signature := range pending {
+		if signature.Status == SignatureUnsigned {
+			unsigned = append(unsigned, signature)
+		}
+	}
+	if len(unsigned) == 0 {
+		return nil, fmt.Errorf("%w: no exported txs are waiting to be signed", ErrNotFound)
+	}
+
+	// decoding is wallet scoped, so each wallet with an export decodes the tx until it matches one of its own exports by id
+	var signature *PendingSignature
+	var decoded *wallet.DecodedTransaction
+	var decode_err error
+	decoded_by := map[string]bool{}
+	for _, exported := range unsigned {
+		if signature != nil || decoded_by[exported.WalletID] {
+			continue
+		}
+		decoded_by[exported.WalletID] = true
+
+		by_wallet, err := node.wallet.DecodeTransaction(ctx, exported.WalletID, base64.StdEncoding.EncodeToString(cbor))
+		if err != nil {
+			// ie. the wallet was removed since it exported
+			decode_err = err
+			continue
+		}
+		decoded = by_wallet
+		for _, candidate := range unsigned {
+			if candidate.WalletID == exported.WalletID && candidate.TxHash == decoded.ID {
+				signature = candidate
+			}
+		}
+	}
+	if decoded == nil {
+		return nil, decode_err
+	} else if signature == nil {
+		return nil, fmt.Errorf("%w: tx %s was not exported by this node or has expired, see wallet pending", ErrNotFound, decoded.ID)
+	}
+	if decoded.WitnessCount.VerificationKey+decoded.WitnessCount.Bootstrap == 0 {
+		return nil, invalidInput("tx %s is not signed, sign it with cardano-cli transaction sign", decoded.ID)
+	}
+
+	_, err = node.wallet.SubmitTransaction(ctx, cbor)
+	if err != nil {
+		for _, article := range signature.Articles {
+			node.audit(actor, "sign", article.record(""), signature.WalletID, err)
+		}
+		return nil, err
+	}
+
+	for _, article := range signature.Articles {
+		node.audit(actor, "sign", article.record(signature.TxHash), signature.WalletID, nil)
+	}
+
+	// the articles are attributed to whoever signed them rather than who exported the tx
+	if actor == nil {
+		actor = &node.default_actor
+	}
+	now := time.Now().UTC()
+	signature.Actor = actor.Name
+	signature.Source = actor.Source
+	signature.Status = SignatureSubmitted
+	signature.Submitted = now
+	signature.Expires = now.Add(node.config.SignatureTimeout)
+	signature.LastError = ""
+
+	err = node.withPendingSignatures(func(pending []*PendingSignature) ([]*PendingSignature, error) {
+		updated := []*PendingSignature{}
+		for _, existing := range pending {
+			if existing.TxHash != signature.TxHash {
+				updated = append(updated, existing)
+			}
+		}
+		return append(updated, signature), nil
+	})
+	if err != nil {
+		return signature, errors.New("submitted tx " + signature.TxHash + " but could not track it, publish it once it is in the ledger: " + err.Error())
+	}
+	return signature, nil
+}
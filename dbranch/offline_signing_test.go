@@ -0,0 +1,85 @@
+package dbranch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet/wallettest"
+)
+
+func newExportNode(t *testing.T, wallet_ids ...string) (*Node, *wallettest.Server) {
+	/* a node with a fake wallet holding a wallet for each id and an article to export */
+	t.Helper()
+	server := wallettest.NewServer()
+	t.Cleanup(server.Close)
+	for _, wallet_id := range wallet_ids {
+		server.AddWallet(wallet.Wallet{ID: wallet_id}, "")
+	}
+
+	fake, shell := newFakeIpfs(t)
+	fake.respond("files/stat", map[string]interface{}{"Hash": "QmArticle"})
+	return newTestNode(t, nil, shell, WithWalletClient(server.WalletClient())), server
+}
+
+func exportTestSignature(t *testing.T, node *Node, wallet_id string) *ExportedSignature {
+	t.Helper()
+	request := SignRequest{WalletID: wallet_id, Address: "addr_test1", Path: "/dBranch/drafts/article"}
+	exported, err := node.ExportSignature(context.Background(), request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exported
+}
+
+func signTestEnvelope(envelope *TextEnvelope) *TextEnvelope {
+	// like cardano-cli transaction sign
+	return &TextEnvelope{
+		Type:    strings.Replace(envelope.Type, "Unwitnessed", "Witnessed", 1),
+		CborHex: wallettest.SignTransaction(envelope.CborHex),
+	}
+}
+
+func TestSubmitSignedSignature(t *testing.T) {
+	node, _ := newExportNode(t, "cold")
+	exported := exportTestSignature(t, node, "cold")
+	expectPendingSignatureStatus(t, node, exported.Signature.TxHash, SignatureUnsigned)
+
+	_, err := node.SubmitSignedSignature(context.Background(), exported.Envelope, nil)
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected a tx without witnesses to be refused, got: %v", err)
+	}
+
+	signature, err := node.SubmitSignedSignature(context.Background(), signTestEnvelope(exported.Envelope), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.TxHash != exported.Signature.TxHash || !signature.External {
+		t.Errorf("expected the exported signature, got: %+v", signature)
+	}
+	expectPendingSignatureStatus(t, node, exported.Signature.TxHash, SignatureSubmitted)
+}
+
+func TestSubmitSignedSignatureWallet(t *testing.T) {
+	// an export is matched by the wallet it was exported from, the wallets of other exports may be gone
+	node, server := newExportNode(t, "removed", "cold")
+	removed := exportTestSignature(t, node, "removed")
+	exported := exportTestSignature(t, node, "cold")
+	server.RemoveWallet("removed")
+
+	_, err := node.SubmitSignedSignature(context.Background(), signTestEnvelope(exported.Envelope), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPendingSignatureStatus(t, node, exported.Signature.TxHash, SignatureSubmitted)
+
+	// no wallet is left to decode the tx
+	_, err = node.SubmitSignedSignature(context.Background(), signTestEnvelope(removed.Envelope), nil)
+	var wallet_err *wallet.Error
+	if !errors.As(err, &wallet_err) || wallet_err.Code != "no_such_wallet" {
+		t.Errorf("expected the wallet error, got: %v", err)
+	}
+	expectPendingSignatureStatus(t, node, removed.Signature.TxHash, SignatureUnsigned)
+}
@@ -0,0 +1,318 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//
+// curation policy, rules are evaluated in order and the first match decides the action
+//
+
+const (
+	PolicyCurate = "curate"
+	PolicyReview = "review"
+	PolicyReject = "reject"
+)
+
+type PolicyConditions struct {
+	Addresses     []string   `json:"addresses,omitempty"`
+	StakeKeys     []string   `json:"stake_keys,omitempty"`
+	Types         []string   `json:"types,omitempty"`
+	Authors       []string   `json:"authors,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`      // matches if the article has any of these tags
+	Languages     []string   `json:"languages,omitempty"` // ex: en, es
+	TitleKeywords []string   `json:"title_keywords,omitempty"`
+	MinSize       int64      `json:"min_size,omitempty"` // bytes
+	MaxSize       int64      `json:"max_size,omitempty"` // bytes
+	After         *time.Time `json:"after,omitempty"`    // published after
+	Before        *time.Time `json:"before,omitempty"`   // published before
+}
+
+type PolicyRule struct {
+	Name   string            `json:"name"`
+	Action string            `json:"action"`
+	Allow  *PolicyConditions `json:"allow,omitempty"` // all conditions must match, omit to match everything
+	Deny   *PolicyConditions `json:"deny,omitempty"`  // rule is skipped if all of these conditions match
+}
+
+type Policy struct {
+	DefaultAction string        `json:"default_action"`
+	Rules         []*PolicyRule `json:"rules"`
+}
+
+type PolicyInput struct {
+	Address       string           `json:"address"`
+	StakeAddress  string           `json:"stake_address"`
+	Metadata      *ArticleMetadata `json:"metadata"`
+	Size          int64            `json:"size"`
+	DatePublished time.Time        `json:"date_published"`
+}
+
+type PolicyDecision struct {
+	Action  string   `json:"action"`
+	Rule    string   `json:"rule"` // empty if the default action was used
+	Reasons []string `json:"reasons"`
+}
+
+func (node *Node) policyPath() string {
+	return node.statePath(node.config.PolicyFile, "policy.json")
+}
+
+func validPolicyAction(action string) bool {
+	return action == PolicyCurate || action == PolicyReview || action == PolicyReject
+}
+
+func (node *Node) LoadPolicy() (*Policy, error) {
+	// without a policy file every article from a followed address is curated
+	policy := &Policy{DefaultAction: PolicyCurate, Rules: []*PolicyRule{}}
+
+	data, err := os.ReadFile(node.policyPath())
+	if os.IsNotExist(err) {
+		return policy, nil
+	} else if err != nil {
+		return policy, err
+	}
+
+	err = json.Unmarshal(data, policy)
+	if err != nil {
+		return policy, errors.New("error decoding policy file: " + err.Error())
+	}
+
+	if policy.DefaultAction == "" {
+		policy.DefaultAction = PolicyCurate
+	}
+
+	if !validPolicyAction(policy.DefaultAction) {
+		return policy, errors.New("invalid policy default_action: " + policy.DefaultAction)
+	}
+
+	for index, rule := range policy.Rules {
+		if !validPolicyAction(rule.Action) {
+			return policy, fmt.Errorf("invalid action for policy rule %d (%s): %s", index, rule.Name, rule.Action)
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", index)
+		}
+	}
+
+	return policy, nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (conditions *PolicyConditions) match(input *PolicyInput) (bool, []string) {
+	// returns whether every condition matches and a description of each condition that was checked
+	reasons := []string{}
+
+	check := func(matched bool, description string) bool {
+		if matched {
+			reasons = append(reasons, description)
+		} else {
+			reasons = append(reasons, "not "+description)
+		}
+		return matched
+	}
+
+	metadata := input.Metadata
+	if metadata == nil {
+		metadata = &ArticleMetadata{}
+	}
+
+	if len(conditions.Addresses) > 0 && !check(containsFold(conditions.Addresses, input.Address), "address in "+strings.Join(conditions.Addresses, ", ")) {
+		return false, reasons
+	}
+
+	if len(conditions.StakeKeys) > 0 && !check(containsFold(conditions.StakeKeys, input.StakeAddress), "stake key in "+strings.Join(conditions.StakeKeys, ", ")) {
+		return false, reasons
+	}
+
+	if len(conditions.Types) > 0 && !check(containsFold(conditions.Types, metadata.Type), "type in "+strings.Join(conditions.Types, ", ")) {
+		return false, reasons
+	}
+
+	if len(conditions.Authors) > 0 && !check(containsFold(conditions.Authors, metadata.Author), "author in "+strings.Join(conditions.Authors, ", ")) {
+		return false, reasons
+	}
+
+	if len(conditions.Tags) > 0 {
+		tagged := false
+		for _, tag := range metadata.Tags {
+			tagged = tagged || containsFold(conditions.Tags, tag)
+		}
+		if !check(tagged, "tagged with any of "+strings.Join(conditions.Tags, ", ")) {
+			return false, reasons
+		}
+	}
+
+	if len(conditions.Languages) > 0 && !check(containsFold(conditions.Languages, metadata.Language), "language in "+strings.Join(conditions.Languages, ", ")) {
+		return false, reasons
+	}
+
+	if len(conditions.TitleKeywords) > 0 {
+		title := strings.ToLower(metadata.Title)
+		found := false
+		for _, keyword := range conditions.TitleKeywords {
+			found = found || strings.Contains(title, strings.ToLower(keyword))
+		}
+		if !check(found, "title contains any of "+strings.Join(conditions.TitleKeywords, ", ")) {
+			return false, reasons
+		}
+	}
+
+	if conditions.MinSize > 0 && !check(input.Size >= conditions.MinSize, fmt.Sprintf("size >= %d bytes", conditions.MinSize)) {
+		return false, reasons
+	}
+
+	if conditions.MaxSize > 0 && !check(input.Size <= conditions.MaxSize, fmt.Sprintf("size <= %d bytes", conditions.MaxSize)) {
+		return false, reasons
+	}
+
+	if conditions.After != nil && !check(input.DatePublished.After(*conditions.After), "published after "+conditions.After.Format(time.RFC3339)) {
+		return false, reasons
+	}
+
+	if conditions.Before != nil && !check(input.DatePublished.Before(*conditions.Before), "published before "+conditions.Before.Format(time.RFC3339)) {
+		return false, reasons
+	}
+
+	return true, reasons
+}
+
+func (policy *Policy) Evaluate(input *PolicyInput) *PolicyDecision {
+	explanation := []string{}
+
+	for _, rule := range policy.Rules {
+		matched := rule.Name + ": matched all articles"
+
+		if rule.Allow != nil {
+			allowed, reasons := rule.Allow.match(input)
+			if !allowed {
+				explanation = append(explanation, rule.Name+": skipped, "+reasons[len(reasons)-1])
+				continue
+			}
+			matched = rule.Name + ": matched " + strings.Join(reasons, ", ")
+		}
+
+		if rule.Deny != nil {
+			denied, reasons := rule.Deny.match(input)
+			if denied {
+				explanation = append(explanation, rule.Name+": skipped, denied because "+strings.Join(reasons, ", "))
+				continue
+			}
+		}
+
+		return &PolicyDecision{Action: rule.Action, Rule: rule.Name, Reasons: append(explanation, matched)}
+	}
+
+	return &PolicyDecision{Action: policy.DefaultAction, Reasons: append(explanation, "no rule matched, using default action")}
+}
+
+//
+// evaluate cardano records
+//
+
+func (node *Node) policyInputForCardanoRecord(ctx context.Context, record *CardanoArticleRecord, cid string) (*PolicyInput, error) {
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
+	defer cancel()
+
+	resp, err := node.ipfsCat(ctx, path.Join("/ipfs", cid))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp, node.config.MaxArticleSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > node.config.MaxArticleSize {
+		return nil, fmt.Errorf("%w: exceeds max size of %d bytes", ErrInvalidArticle, node.config.MaxArticleSize)
+	}
+
+	// only the metadata is needed here, full validation happens when the article is added
+	article := &Article{}
+	err = json.Unmarshal(data, article)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode: %s", ErrInvalidArticle, err)
+	}
+
+	return &PolicyInput{
+		Address:       record.Address,
+		StakeAddress:  record.StakeAddress,
+		Metadata:      article.Metadata,
+		Size:          int64(len(data)),
+		DatePublished: record.DatePublished,
+	}, nil
+}
+
+func (node *Node) ExplainPolicyForCardanoTxHash(ctx context.Context, tx_hash string, entry int) (*PolicyInput, *PolicyDecision, error) {
+	/* entry is the article to explain for txs signing more than one, 0 for the first */
+	records, err := node.cardanoRecordsByTxHash(ctx, tx_hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry < 0 || entry >= len(records) {
+		return nil, nil, invalidInput("tx %s signs %d articles, no entry %d", tx_hash, len(records), entry)
+	}
+
+	record := &records[entry]
+	article, err := cardanoArticleRecord(record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policy, err := node.LoadPolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	input, err := node.policyInputForCardanoRecord(ctx, record, article.CID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return input, policy.Evaluate(input), nil
+}
+
+func (node *Node) applyCurationPolicy(ctx context.Context, record *CardanoArticleRecord, article *ArticleRecord, actor *AuditActor) (*PolicyDecision, error) {
+	policy, err := node.LoadPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := node.policyInputForCardanoRecord(ctx, record, article.CID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidArticle) {
+			node.recordRejection(article, err)
+		}
+		node.audit(actor, "policy", article, "", err)
+		return nil, err
+	}
+
+	decision := policy.Evaluate(input)
+	node.audit(actor, "policy", article, decision.Action+": "+decision.Reasons[len(decision.Reasons)-1], nil)
+
+	if decision.Action == PolicyReject {
+		err = fmt.Errorf("%w: %s", ErrRejectedByPolicy, decision.Reasons[len(decision.Reasons)-1])
+		node.recordRejection(article, err)
+		return decision, err
+	}
+
+	return decision, nil
+}
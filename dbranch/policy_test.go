@@ -0,0 +1,89 @@
+package dbranch
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := &Policy{
+		DefaultAction: PolicyReview,
+		Rules: []*PolicyRule{
+			{Name: "spam", Action: PolicyReject, Allow: &PolicyConditions{TitleKeywords: []string{"FREE ADA"}}},
+			{Name: "trusted", Action: PolicyCurate, Allow: &PolicyConditions{Addresses: []string{"addr_trusted"}}, Deny: &PolicyConditions{MaxSize: 10}},
+			{Name: "trusted again", Action: PolicyReject, Allow: &PolicyConditions{Addresses: []string{"addr_trusted"}}},
+			{Name: "old news", Action: PolicyReject, Allow: &PolicyConditions{Types: []string{"news"}, Before: &cutoff}},
+		},
+	}
+
+	input := func(address, title string, size int64, published time.Time) *PolicyInput {
+		return &PolicyInput{
+			Address:       address,
+			Metadata:      &ArticleMetadata{Type: "news", Title: title},
+			Size:          size,
+			DatePublished: published,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		input  *PolicyInput
+		action string
+		rule   string
+	}{
+		{"first match wins over later rules", input("addr_trusted", "Free ADA inside", 100, cutoff), PolicyReject, "spam"},
+		{"allowed rule", input("addr_trusted", "Title", 100, cutoff), PolicyCurate, "trusted"},
+		{"denied rule falls through to the next", input("addr_trusted", "Title", 5, cutoff), PolicyReject, "trusted again"},
+		{"every allow condition must match", input("addr_other", "Title", 100, cutoff.Add(time.Hour)), PolicyReview, ""},
+		{"date condition", input("addr_other", "Title", 100, cutoff.Add(-time.Hour)), PolicyReject, "old news"},
+		{"default action", &PolicyInput{}, PolicyReview, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := policy.Evaluate(test.input)
+			if decision.Action != test.action || decision.Rule != test.rule {
+				t.Errorf("expected: %s by %q, got: %s by %q: %v", test.action, test.rule, decision.Action, decision.Rule, decision.Reasons)
+			}
+			if len(decision.Reasons) == 0 {
+				t.Error("expected the decision to be explained")
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	node := newTestNode(t, nil)
+
+	// without a policy file everything is curated
+	policy, err := node.LoadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := policy.Evaluate(&PolicyInput{}); decision.Action != PolicyCurate {
+		t.Errorf("expected the default action: %s, got: %s", PolicyCurate, decision.Action)
+	}
+
+	err = os.WriteFile(node.policyPath(), []byte(`{"rules": [{"action": "review"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err = node.LoadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.DefaultAction != PolicyCurate || policy.Rules[0].Name != "rule 0" {
+		t.Errorf("expected the default action and rule name to be filled in, got: %s, %s", policy.DefaultAction, policy.Rules[0].Name)
+	}
+
+	err = os.WriteFile(node.policyPath(), []byte(`{"rules": [{"name": "typo", "action": "curated"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = node.LoadPolicy()
+	if err == nil {
+		t.Error("expected an invalid action to be refused")
+	}
+}
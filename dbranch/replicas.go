@@ -0,0 +1,456 @@
+package dbranch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+)
+
+//
+// remote pinning service replication, see: https://ipfs.github.io/pinning-services-api-spec/
+//
+
+type PinningService struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"` // ex: https://api.pinata.cloud/psa
+	Token    string `json:"token"`
+}
+
+type ServiceReplica struct {
+	RequestID string    `json:"request_id,omitempty"`
+	Status    string    `json:"status"` // queued, pinning, pinned, failed or removing
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+type ArticleReplica struct {
+	Name     string                     `json:"name"`
+	CID      string                     `json:"cid"`
+	Removing bool                       `json:"removing,omitempty"` // article was un-curated, pins are being deleted
+	Services map[string]*ServiceReplica `json:"services"`
+}
+
+// give up re-submitting a failed pin after this many attempts
+const MaxReplicaAttempts = 5
+
+func (node *Node) pinningServicesPath() string {
+	return node.statePath(node.config.PinningServicesFile, "pinning_services.json")
+}
+
+func (node *Node) replicasPath() string {
+	return node.statePath(node.config.ReplicasFile, "replicas.json")
+}
+
+func (node *Node) ListPinningServices() ([]PinningService, error) {
+	services := []PinningService{}
+
+	data, err := os.ReadFile(node.pinningServicesPath())
+	if os.IsNotExist(err) {
+		return services, nil
+	} else if err != nil {
+		return services, err
+	}
+
+	err = json.Unmarshal(data, &services)
+	if err != nil {
+		return services, errors.New("error decoding pinning services file: " + err.Error())
+	}
+
+	return services, nil
+}
+
+func (node *Node) loadReplicas() (map[string]*ArticleReplica, error) {
+	replicas := map[string]*ArticleReplica{}
+
+	data, err := os.ReadFile(node.replicasPath())
+	if os.IsNotExist(err) {
+		return replicas, nil
+	} else if err != nil {
+		return replicas, err
+	}
+
+	err = json.Unmarshal(data, &replicas)
+	if err != nil {
+		return replicas, errors.New("error decoding replicas file: " + err.Error())
+	}
+
+	return replicas, nil
+}
+
+func (node *Node) saveReplicas(replicas map[string]*ArticleReplica) error {
+	data, err := json.MarshalIndent(replicas, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(node.replicasPath(), data, 0644)
+}
+
+func (node *Node) ListReplicas() ([]*ArticleReplica, error) {
+	node.replicas_lock.Lock()
+	defer node.replicas_lock.Unlock()
+
+	replicas, err := node.loadReplicas()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*ArticleReplica{}
+	for _, replica := range replicas {
+		list = append(list, replica)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name == list[j].Name {
+			return list[i].CID < list[j].CID
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+//
+// pinning service api requests
+//
+
+type pinStatusResponse struct {
+	RequestID string `json:"requestid"`
+	Status    string `json:"status"`
+}
+
+func (node *Node) pinningRequest(ctx context.Context, service PinningService, method, endpoint string, body interface{}) (*pinStatusResponse, error) {
+	payload := &bytes.Buffer{}
+	if body != nil {
+		err := json.NewEncoder(payload).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	url := service.Endpoint + endpoint
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+service.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := node.pinning_client.Do(req)
+	if err != nil {
+		return nil, errors.New(url + " returned error: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		failure := struct {
+			Error struct {
+				Reason  string `json:"reason"`
+				Details string `json:"details"`
+			} `json:"error"`
+		}{}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return nil, fmt.Errorf("%s returned %s: %s %s", url, resp.Status, failure.Error.Reason, failure.Error.Details)
+	}
+
+	status := &pinStatusResponse{}
+	if method != http.MethodDelete {
+		err = json.NewDecoder(resp.Body).Decode(status)
+		if err != nil {
+			return nil, errors.New("error decoding pinning service response: " + err.Error())
+		}
+	}
+
+	return status, nil
+}
+
+func (node *Node) localOrigins(ctx context.Context) []string {
+	// tell the service where to fetch the content from to speed up pinning
+	ctx, cancel := context.WithTimeout(ctx, node.config.IpfsTimeout)
+	defer cancel()
+
+	var id ipfs.IdOutput
+	err := node.shell.Request("id").Exec(ctx, &id)
+	if err != nil {
+		return []string{}
+	}
+	return id.Addresses
+}
+
+//
+// replication, pinning service requests are made without holding replicas_lock so a slow service doesn't block
+// curation, the results are merged into the replicas file afterwards
+//
+
+type replicaUpdate struct {
+	CID       string
+	Name      string
+	Service   string
+	State     *ServiceReplica // nil when the pin was removed from the service
+	Replicate bool            // from ReplicateArticle, the replica is kept or created even if it was removed meanwhile
+}
+
+func (node *Node) replicaCID(ctx context.Context, ref string) (string, error) {
+	// attachments with a sub path are pinned by the cid they resolve to, the pinning api only takes cids
+	if !strings.Contains(ref, "/") {
+		return ref, nil
+	}
+	stat, err := node.statIpfsPath(ctx, path.Join("/ipfs", ref))
+	if err != nil {
+		return "", errors.New("could not resolve: " + ref + ": " + err.Error())
+	}
+	return stat.Hash, nil
+}
+
+func (node *Node) snapshotReplicas() (map[string]*ArticleReplica, error) {
+	node.replicas_lock.Lock()
+	defer node.replicas_lock.Unlock()
+	return node.loadReplicas()
+}
+
+func (node *Node) updateReplicas(update func(replicas map[string]*ArticleReplica)) error {
+	node.replicas_lock.Lock()
+	defer node.replicas_lock.Unlock()
+
+	replicas, err := node.loadReplicas()
+	if err != nil {
+		return err
+	}
+	update(replicas)
+	return node.saveReplicas(replicas)
+}
+
+func (node *Node) mergeReplicaUpdates(updates []*replicaUpdate) error {
+	/* apply the results of pinning service requests to the current replicas */
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return node.updateReplicas(func(replicas map[string]*ArticleReplica) {
+		for _, update := range updates {
+			replica, exists := replicas[update.CID]
+			if !exists {
+				// removed while the request was made, only a newly curated article brings it back
+				if !update.Replicate {
+					continue
+				}
+				replica = &ArticleReplica{Name: update.Name, CID: update.CID, Services: map[string]*ServiceReplica{}}
+				replicas[update.CID] = replica
+			}
+			if update.Replicate {
+				replica.Removing = false
+			}
+
+			if update.Service == "" {
+				continue
+			} else if update.State == nil {
+				delete(replica.Services, update.Service)
+				if replica.Removing && len(replica.Services) == 0 {
+					delete(replicas, update.CID)
+				}
+				continue
+			}
+			replica.Services[update.Service] = update.State
+		}
+	})
+}
+
+func (node *Node) submitReplica(ctx context.Context, service PinningService, replica *ArticleReplica, state *ServiceReplica, origins []string) {
+	state.Attempts++
+	state.Updated = time.Now().UTC()
+
+	body := map[string]interface{}{"cid": replica.CID, "name": replica.Name, "origins": origins}
+	status, err := node.pinningRequest(ctx, service, http.MethodPost, "/pins", body)
+	if err != nil {
+		state.Status = "failed"
+		state.LastError = err.Error()
+		log.Printf("could not replicate: %s to: %s: %s\n", replica.CID, service.Name, err)
+		return
+	}
+
+	state.RequestID = status.RequestID
+	state.Status = status.Status
+	state.LastError = ""
+	log.Printf("replicating: %s to: %s status: %s\n", replica.CID, service.Name, state.Status)
+}
+
+func (node *Node) ReplicateArticle(ctx context.Context, record *ArticleRecord) error {
+	services, err := node.ListPinningServices()
+	if err != nil {
+		return err
+	}
+
+	if len(services) == 0 {
+		return nil
+	}
+
+	cids := []string{}
+	for _, ref := range append([]string{record.CID}, record.Attachments...) {
+		cid, err := node.replicaCID(ctx, ref)
+		if err != nil {
+			return err
+		}
+		cids = append(cids, cid)
+	}
+
+	replicas, err := node.snapshotReplicas()
+	if err != nil {
+		return err
+	}
+
+	origins := node.localOrigins(ctx)
+	updates := []*replicaUpdate{}
+
+	for _, cid := range cids {
+		replica, exists := replicas[cid]
+		if !exists {
+			replica = &ArticleReplica{Name: record.Name, CID: cid, Services: map[string]*ServiceReplica{}}
+			replicas[cid] = replica
+		}
+		updates = append(updates, &replicaUpdate{CID: cid, Name: replica.Name, Replicate: true})
+
+		for _, service := range services {
+			state, exists := replica.Services[service.Name]
+			if exists && state.Status != "failed" && state.Status != "removing" {
+				continue
+			}
+
+			state = &ServiceReplica{}
+			replica.Services[service.Name] = state
+			node.submitReplica(ctx, service, replica, state, origins)
+			updates = append(updates, &replicaUpdate{CID: cid, Name: replica.Name, Service: service.Name, State: state, Replicate: true})
+		}
+	}
+
+	return node.mergeReplicaUpdates(updates)
+}
+
+func (node *Node) UnreplicateCID(ctx context.Context, ref string) error {
+	/* delete the remote pins of an article or attachment ref, pins that can't be deleted are retried by SyncReplicas */
+	services, err := node.ListPinningServices()
+	if err != nil {
+		return err
+	}
+
+	cid, err := node.replicaCID(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	// marked as removing first so a failed removal is retried
+	var replica *ArticleReplica
+	err = node.updateReplicas(func(replicas map[string]*ArticleReplica) {
+		replica = replicas[cid]
+		if replica != nil {
+			replica.Removing = true
+		}
+	})
+	if err != nil || replica == nil {
+		return err
+	}
+
+	return node.mergeReplicaUpdates(node.removeReplica(ctx, services, replica))
+}
+
+func (node *Node) removeReplica(ctx context.Context, services []PinningService, replica *ArticleReplica) []*replicaUpdate {
+	// services are dropped from the replica once the pin is deleted, failures stay as "removing" to be retried
+	updates := []*replicaUpdate{}
+	for _, service := range services {
+		state, exists := replica.Services[service.Name]
+		if !exists {
+			continue
+		}
+
+		if state.RequestID != "" {
+			_, err := node.pinningRequest(ctx, service, http.MethodDelete, "/pins/"+state.RequestID, nil)
+			if err != nil {
+				state.Status = "removing"
+				state.LastError = err.Error()
+				state.Updated = time.Now().UTC()
+				log.Printf("could not remove replica: %s from: %s: %s\n", replica.CID, service.Name, err)
+				updates = append(updates, &replicaUpdate{CID: replica.CID, Name: replica.Name, Service: service.Name, State: state})
+				continue
+			}
+		}
+
+		updates = append(updates, &replicaUpdate{CID: replica.CID, Name: replica.Name, Service: service.Name})
+		log.Printf("removed replica: %s from: %s\n", replica.CID, service.Name)
+	}
+	return updates
+}
+
+func (node *Node) SyncReplicas(ctx context.Context) error {
+	/* refresh the status of in progress pins, retry failed pins and retry failed removals */
+	services, err := node.ListPinningServices()
+	if err != nil {
+		return err
+	}
+
+	if len(services) == 0 {
+		return nil
+	}
+
+	replicas, err := node.snapshotReplicas()
+	if err != nil {
+		return err
+	}
+
+	origins := []string{}
+	updates := []*replicaUpdate{}
+	for cid, replica := range replicas {
+
+		if replica.Removing {
+			updates = append(updates, node.removeReplica(ctx, services, replica)...)
+			continue
+		}
+
+		for _, service := range services {
+			state, exists := replica.Services[service.Name]
+			if !exists {
+				// service was added since this cid was replicated
+				state = &ServiceReplica{}
+			}
+
+			switch state.Status {
+			case "queued", "pinning":
+				status, err := node.pinningRequest(ctx, service, http.MethodGet, "/pins/"+state.RequestID, nil)
+				if err != nil {
+					state.LastError = err.Error()
+				} else {
+					state.Status = status.Status
+					state.Updated = time.Now().UTC()
+				}
+				updates = append(updates, &replicaUpdate{CID: cid, Name: replica.Name, Service: service.Name, State: state})
+
+			case "failed", "":
+				if state.Attempts >= MaxReplicaAttempts {
+					continue
+				}
+
+				// back off exponentially between attempts
+				backoff := time.Duration(1<<state.Attempts) * time.Minute
+				if time.Since(state.Updated) < backoff {
+					continue
+				}
+
+				if len(origins) == 0 {
+					origins = node.localOrigins(ctx)
+				}
+				node.submitReplica(ctx, service, replica, state, origins)
+				updates = append(updates, &replicaUpdate{CID: cid, Name: replica.Name, Service: service.Name, State: state})
+			}
+		}
+	}
+
+	return node.mergeReplicaUpdates(updates)
+}
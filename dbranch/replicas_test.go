@@ -0,0 +1,147 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePinningService struct {
+	lock    sync.Mutex
+	pinned  map[string]string // request id to cid
+	deleted []string
+	block   chan bool // when set, pin requests wait for it
+}
+
+func (service *fakePinningService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/pins":
+		if service.block != nil {
+			<-service.block
+		}
+		body := struct {
+			CID string `json:"cid"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if strings.Contains(body.CID, "/") {
+			http.Error(w, `{"error": {"reason": "BAD_REQUEST", "details": "not a cid"}}`, http.StatusBadRequest)
+			return
+		}
+		service.lock.Lock()
+		service.pinned["req-"+body.CID] = body.CID
+		service.lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"requestid": "req-" + body.CID, "status": "queued"})
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/pins/"):
+		service.lock.Lock()
+		request_id := strings.TrimPrefix(r.URL.Path, "/pins/")
+		service.deleted = append(service.deleted, service.pinned[request_id])
+		delete(service.pinned, request_id)
+		service.lock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newReplicaNode(t *testing.T, service *fakePinningService) *Node {
+	/* a node replicating to service, its ipfs api resolves any path with a sub path to QmResolved */
+	t.Helper()
+	pinning := httptest.NewServer(service)
+	t.Cleanup(pinning.Close)
+
+	fake, shell := newFakeIpfs(t)
+	fake.respond("files/stat", map[string]interface{}{"Hash": "QmResolved", "Type": "file"})
+	fake.respond("id", map[string]interface{}{"Addresses": []string{"/ip4/127.0.0.1/tcp/4001"}})
+
+	node := newTestNode(t, nil, shell)
+	data, _ := json.Marshal([]PinningService{{Name: "fake", Endpoint: pinning.URL, Token: "token"}})
+	err := os.WriteFile(node.pinningServicesPath(), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestReplicateAttachmentsByCID(t *testing.T) {
+	service := &fakePinningService{pinned: map[string]string{}}
+	node := newReplicaNode(t, service)
+	ctx := context.Background()
+
+	record := &ArticleRecord{Name: "article", CID: "QmArticle", Attachments: []string{"QmImage", "QmDir/clip.mp4"}}
+	err := node.ReplicateArticle(ctx, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicas, err := node.ListReplicas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cids := []string{}
+	for _, replica := range replicas {
+		cids = append(cids, replica.CID)
+		if state := replica.Services["fake"]; state == nil || state.Status != "queued" || state.RequestID != "req-"+replica.CID {
+			t.Errorf("unexpected replica state for %s: %+v", replica.CID, state)
+		}
+	}
+	if strings.Join(cids, ",") != "QmArticle,QmImage,QmResolved" {
+		t.Errorf("expected replicas keyed by cid, got: %v", cids)
+	}
+
+	// removing by the attachment's ref deletes the pin of the cid it resolved to
+	err = node.UnreplicateCID(ctx, "QmDir/clip.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas, _ = node.ListReplicas()
+	if len(replicas) != 2 || len(service.deleted) != 1 || service.deleted[0] != "QmResolved" {
+		t.Errorf("expected the resolved cid to be removed, deleted: %v, replicas left: %d", service.deleted, len(replicas))
+	}
+}
+
+func TestReplicateWithoutHoldingLock(t *testing.T) {
+	service := &fakePinningService{pinned: map[string]string{}, block: make(chan bool)}
+	node := newReplicaNode(t, service)
+
+	done := make(chan error)
+	go func() {
+		done <- node.ReplicateArticle(context.Background(), &ArticleRecord{Name: "slow", CID: "QmSlow"})
+	}()
+
+	// the pin request is waiting on the service, the replicas can still be read and changed
+	listed := make(chan error)
+	go func() {
+		_, err := node.ListReplicas()
+		if err == nil {
+			err = node.UnreplicateCID(context.Background(), "QmOther")
+		}
+		listed <- err
+	}()
+	select {
+	case err := <-listed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replicas locked while a pinning request is in flight")
+	}
+
+	close(service.block)
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicas, _ := node.ListReplicas()
+	if len(replicas) != 1 || replicas[0].Services["fake"].Status != "queued" {
+		t.Errorf("expected the pin result to be merged: %+v", replicas)
+	}
+}
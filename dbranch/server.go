@@ -1,12 +1,20 @@
 package dbranch
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/net/websocket"
 )
 
 //
@@ -15,127 +23,892 @@ import (
 
 type errorMsg struct {
 	Error string `json:"error"`
+	Code  string `json:"code"` // see ErrorCode
+}
+
+func apiError(e echo.Context, err error) error {
+	/* respond with the status and code for err, the message of internal errors is not exposed */
+	code, status := errorCode(err)
+	if status >= http.StatusInternalServerError {
+		e.Logger().Error(err)
+		return e.JSON(status, &errorMsg{Error: "internal server error", Code: code})
+	}
+
+	e.Logger().Warn(err)
+	return e.JSON(status, &errorMsg{Error: err.Error(), Code: code})
+}
+
+func invalidRequest(e echo.Context, message string) error {
+	return e.JSON(http.StatusBadRequest, &errorMsg{Error: "invalud request: " + message, Code: CodeInvalidRequest})
+}
+
+func httpErrorHandler(err error, e echo.Context) {
+	/* errors from echo and middleware, ie. unknown routes and missing auth, use the same response as handlers */
+	if e.Response().Committed {
+		return
+	}
+
+	http_err, ok := err.(*echo.HTTPError)
+	if !ok {
+		apiError(e, err)
+		return
+	}
+
+	code := CodeInvalidRequest
+	switch {
+	case http_err.Code == http.StatusUnauthorized:
+		code = CodeUnauthorized
+	case http_err.Code == http.StatusForbidden:
+		code = CodeForbidden
+	case http_err.Code == http.StatusNotFound:
+		code = CodeNotFound
+	case http_err.Code == http.StatusTooManyRequests:
+		code = CodeTooManyRequests
+	case http_err.Code >= http.StatusInternalServerError:
+		code = CodeInternal
+	}
+
+	message := fmt.Sprint(http_err.Message)
+	if e.Request().Method == http.MethodHead {
+		e.NoContent(http_err.Code)
+	} else {
+		e.JSON(http_err.Code, &errorMsg{Error: message, Code: code})
+	}
 }
 
 //
 // article endpoints
 //
 
-func articleIndex(e echo.Context) error {
-	index, err := LoadArticleIndex()
+func (node *Node) articleIndex(e echo.Context) error {
+	index, err := node.LoadArticleIndex(e.Request().Context())
 	if err != nil {
-		e.Logger().Error(err)
-		return e.JSON(http.StatusInternalServerError, &errorMsg{Error: "internal server error"})
+		return apiError(e, err)
 	}
 
 	return e.JSON(http.StatusOK, index)
 }
 
-func articleGetByCid(e echo.Context) error {
+func (node *Node) articleGetByCid(e echo.Context) error {
 	// init request
 	article_cid := e.Param("cid")
 	load_record := false
 	err := echo.QueryParamsBinder(e).Bool("load_record", &load_record).BindError()
 	if err != nil {
-		e.Logger().Error(err)
-		return e.JSON(http.StatusBadRequest, &errorMsg{Error: "invalud request: " + err.Error()})
+		return invalidRequest(e, err.Error())
 	}
 
 	// load article
-	article, err := GetArticleByCID(article_cid, load_record)
+	article, err := node.GetArticleByCID(e.Request().Context(), article_cid, load_record)
 	if err != nil {
-		e.Logger().Error(err)
-		if err.Error() == "article not found" {
-			return e.JSON(http.StatusNotFound, &errorMsg{Error: "article not found"})
-		} else {
-			return e.JSON(http.StatusInternalServerError, &errorMsg{Error: "internal server error"})
-		}
+		return apiError(e, err)
 	}
 
 	return e.JSON(http.StatusOK, article)
 }
 
+func (node *Node) articleRenderByCid(e echo.Context) error {
+	article, err := node.GetArticleByCID(e.Request().Context(), e.Param("cid"), false)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	rendered, err := RenderArticle(article)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.HTML(http.StatusOK, rendered)
+}
+
+func (node *Node) articleTextByCid(e echo.Context) error {
+	summary := 0
+	err := echo.QueryParamsBinder(e).Int("summary", &summary).BindError()
+	if err != nil {
+		return invalidRequest(e, err.Error())
+	}
+
+	article, err := node.GetArticleByCID(e.Request().Context(), e.Param("cid"), false)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	text, err := ArticlePlainText(article)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	if summary > 0 {
+		text = SummarizeText(text, summary)
+	}
+
+	return e.String(http.StatusOK, text)
+}
+
+func articleTypeList(e echo.Context) error {
+	return e.JSON(http.StatusOK, ListArticleTypes())
+}
+
+//
+// curator endpoints
+//
+
+func (node *Node) curatorRejected(e echo.Context) error {
+	rejections, err := node.ListRejections()
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, rejections)
+}
+
+//
+// admin endpoints
+//
+
+type rejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (node *Node) adminPendingList(e echo.Context) error {
+	items, err := node.ListPendingArticles(e.Request().Context())
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, items)
+}
+
+func (node *Node) adminPendingApprove(e echo.Context) error {
+	record, err := node.ApprovePendingArticle(e.Request().Context(), e.Param("name"), requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, record)
+}
+
+func (node *Node) adminPendingReject(e echo.Context) error {
+	body := &rejectRequest{}
+	err := e.Bind(body)
+	if err != nil || body.Reason == "" {
+		return invalidRequest(e, "a reason is required")
+	}
+
+	record, err := node.RejectPendingArticle(e.Request().Context(), e.Param("name"), requestActor(e), body.Reason)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, record)
+}
+
+type blockRequest struct {
+	Kind    string     `json:"kind"`
+	Value   string     `json:"value"`
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires"`
+}
+
+type importRequest struct {
+	Source string `json:"source"`
+}
+
+func (node *Node) blocklistGet(e echo.Context) error {
+	entries, err := node.ListBlocks()
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, entries)
+}
+
+func (node *Node) adminBlockAdd(e echo.Context) error {
+	body := &blockRequest{}
+	err := e.Bind(body)
+	if err != nil {
+		return invalidRequest(e, err.Error())
+	}
+
+	entry := &BlockEntry{Kind: body.Kind, Value: body.Value, Reason: body.Reason, Expires: body.Expires, Source: requestActor(e).Name}
+	err = node.AddBlock(entry, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, entry)
+}
+
+func (node *Node) adminBlockRemove(e echo.Context) error {
+	err := node.RemoveBlock(e.Param("kind"), e.Param("value"), requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
+
+func (node *Node) adminBlockImport(e echo.Context) error {
+	body := &importRequest{}
+	err := e.Bind(body)
+	if err != nil || body.Source == "" {
+		return invalidRequest(e, "a source is required")
+	}
+
+	// only urls can be imported through the api, reading local files is left to the cli
+	if !strings.HasPrefix(body.Source, "http://") && !strings.HasPrefix(body.Source, "https://") {
+		return invalidRequest(e, "source must be an http(s) url")
+	}
+
+	count, err := node.ImportBlocklist(e.Request().Context(), body.Source, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, map[string]int{"imported": count})
+}
+
+func (node *Node) adminModerationLog(e echo.Context) error {
+	decisions, err := node.ListModerationDecisions()
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, decisions)
+}
+
+type txRequest struct {
+	TxHash string `json:"tx_hash"`
+}
+
+func (node *Node) adminCurate(e echo.Context) error {
+	body := &txRequest{}
+	err := e.Bind(body)
+	if err != nil || body.TxHash == "" {
+		return invalidRequest(e, "a tx_hash is required")
+	}
+
+	records, err := node.CurateRecordsByCardanoTxHash(e.Request().Context(), body.TxHash, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, records)
+}
+
+func (node *Node) adminPublish(e echo.Context) error {
+	body := &txRequest{}
+	err := e.Bind(body)
+	if err != nil || body.TxHash == "" {
+		return invalidRequest(e, "a tx_hash is required")
+	}
+
+	records, err := node.PublishRecordsByCardanoTxHash(e.Request().Context(), body.TxHash, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, records)
+}
+
+type signRequest struct {
+	SignRequest
+	Passphrase string `json:"passphrase,omitempty"` // defaults to the passphrase configured for the wallet
+}
+
+func (node *Node) adminSignEstimate(e echo.Context) error {
+	body := &SignRequest{}
+	err := e.Bind(body)
+	if err != nil || body.WalletID == "" || body.Address == "" || body.Path == "" {
+		return invalidRequest(e, "a wallet_id, address and path are required")
+	}
+
+	estimate, err := node.EstimateSignature(e.Request().Context(), *body)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, estimate)
+}
+
+func (node *Node) requestPassphrase(wallet_id, passphrase string) (string, error) {
+	/* the passphrase of a sign request, defaulting to the one configured for the wallet only if Config.ServerPassphrase is set */
+	if passphrase != "" {
+		return passphrase, nil
+	}
+	if !node.config.ServerPassphrase {
+		return "", invalidInput("a passphrase is required")
+	}
+
+	passphrase, err := node.WalletPassphrase(wallet_id)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", invalidInput("a passphrase is required, none is configured for wallet %s", wallet_id)
+	}
+	return passphrase, nil
+}
+
+func (node *Node) adminSign(e echo.Context) error {
+	body := &signRequest{}
+	err := e.Bind(body)
+	if err != nil || body.WalletID == "" || body.Address == "" || body.Path == "" {
+		return invalidRequest(e, "a wallet_id, address and path are required")
+	}
+
+	body.Passphrase, err = node.requestPassphrase(body.WalletID, body.Passphrase)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	signature, err := node.SignArticle(e.Request().Context(), body.SignRequest, body.Passphrase, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	// the daemon publishes the record once the tx is in the ledger, see adminSignatures
+	return e.JSON(http.StatusAccepted, signature)
+}
+
+type batchSignRequest struct {
+	BatchSignRequest
+	Passphrase string `json:"passphrase,omitempty"` // defaults to the passphrase configured for the wallet
+}
+
+func (node *Node) adminSignBatchEstimate(e echo.Context) error {
+	body := &BatchSignRequest{}
+	err := e.Bind(body)
+	if err != nil || body.WalletID == "" || body.Address == "" || len(body.Paths) == 0 {
+		return invalidRequest(e, "a wallet_id, address and paths are required")
+	}
+
+	estimates, err := node.EstimateBatchSignature(e.Request().Context(), *body)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, estimates)
+}
+
+func (node *Node) adminSignBatch(e echo.Context) error {
+	body := &batchSignRequest{}
+	err := e.Bind(body)
+	if err != nil || body.WalletID == "" || body.Address == "" || len(body.Paths) == 0 {
+		return invalidRequest(e, "a wallet_id, address and paths are required")
+	}
+
+	body.Passphrase, err = node.requestPassphrase(body.WalletID, body.Passphrase)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	signatures, err := node.SignArticles(e.Request().Context(), body.BatchSignRequest, body.Passphrase, requestActor(e))
+	if err != nil {
+		// txs submitted before the error are tracked like any other and listed by adminSignatures
+		for _, signature := range signatures {
+			e.Logger().Warnf("tx %s was submitted before the batch failed", signature.TxHash)
+		}
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusAccepted, signatures)
+}
+
+func (node *Node) adminSignExport(e echo.Context) error {
+	body := &SignRequest{}
+	err := e.Bind(body)
+	if err != nil || body.WalletID == "" || body.Address == "" || body.Path == "" {
+		return invalidRequest(e, "a wallet_id, address and path are required")
+	}
+
+	exported, err := node.ExportSignature(e.Request().Context(), *body, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, exported)
+}
+
+func (node *Node) adminSignSubmit(e echo.Context) error {
+	// the body is the envelope written by cardano-cli transaction sign
+	body := &TextEnvelope{}
+	err := e.Bind(body)
+	if err != nil || body.CborHex == "" {
+		return invalidRequest(e, "a signed tx envelope with cborHex is required")
+	}
+
+	signature, err := node.SubmitSignedSignature(e.Request().Context(), body, requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusAccepted, signature)
+}
+
+func (node *Node) adminSignatures(e echo.Context) error {
+	pending, err := node.ListPendingSignatures()
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, pending)
+}
+
+func (node *Node) adminArticleRemove(e echo.Context) error {
+	err := node.RemoveRecordFromLocal(e.Request().Context(), e.Param("name"), requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
+
+func (node *Node) adminIndexRefresh(e echo.Context) error {
+	err := node.RefreshArticleIndex(e.Request().Context(), requestActor(e))
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return node.articleIndex(e)
+}
+
+func (node *Node) adminAuditLog(e echo.Context) error {
+	query := &AuditQuery{
+		Action: e.QueryParam("action"),
+		Actor:  e.QueryParam("actor"),
+		Source: e.QueryParam("source"),
+		Name:   e.QueryParam("name"),
+		CID:    e.QueryParam("cid"),
+		TxHash: e.QueryParam("tx"),
+		Limit:  100,
+	}
+
+	var err error
+	if value := e.QueryParam("since"); value != "" {
+		query.Since, err = time.Parse(time.RFC3339, value)
+	}
+	if value := e.QueryParam("until"); value != "" && err == nil {
+		query.Until, err = time.Parse(time.RFC3339, value)
+	}
+	if value := e.QueryParam("limit"); value != "" && err == nil {
+		query.Limit, err = strconv.Atoi(value)
+	}
+	if err != nil {
+		return invalidRequest(e, err.Error())
+	}
+
+	entries, err := node.QueryAuditLog(query)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, entries)
+}
+
+//
+// event stream endpoints
+//
+
+func eventStreamParams(e echo.Context) (uint64, *EventFilter, error) {
+	// resume from the Last-Event-ID header sent by EventSource on reconnect or the last_event_id query param
+	last_event_id := e.Request().Header.Get("Last-Event-ID")
+	if value := e.QueryParam("last_event_id"); value != "" {
+		last_event_id = value
+	}
+
+	var last_id uint64
+	if last_event_id != "" {
+		var err error
+		last_id, err = strconv.ParseUint(last_event_id, 10, 64)
+		if err != nil {
+			return 0, nil, invalidInput("invalid last event id: %s", last_event_id)
+		}
+	}
+
+	// authors may contain commas so they are passed as repeated params
+	filter := &EventFilter{
+		Types:    splitList(e.QueryParam("type")),
+		Sections: splitList(e.QueryParam("section")),
+		Authors:  e.QueryParams()["author"],
+	}
+
+	return last_id, filter, nil
+}
+
+func writeServerSentEvent(resp *echo.Response, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(resp, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	if err != nil {
+		return err
+	}
+
+	resp.Flush()
+	return nil
+}
+
+func (node *Node) eventStream(e echo.Context) error {
+	last_id, filter, err := eventStreamParams(e)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	replay, events, unsubscribe := node.events.Subscribe(last_id, filter)
+	defer unsubscribe()
+
+	resp := e.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	for _, event := range replay {
+		err = writeServerSentEvent(resp, event)
+		if err != nil {
+			return nil
+		}
+	}
+
+	// comments keep proxies from closing idle connections
+	keep_alive := time.NewTicker(15 * time.Second)
+	defer keep_alive.Stop()
+
+	for {
+		select {
+		case <-e.Request().Context().Done():
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				// fell too far behind, the client reconnects with its last event id
+				return nil
+			}
+			err = writeServerSentEvent(resp, event)
+			if err != nil {
+				return nil
+			}
+
+		case <-keep_alive.C:
+			_, err = fmt.Fprint(resp, ": keep-alive\n\n")
+			if err != nil {
+				return nil
+			}
+			resp.Flush()
+		}
+	}
+}
+
+func (node *Node) eventWebSocket(e echo.Context) error {
+	last_id, filter, err := eventStreamParams(e)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	// websocket.Server rather than websocket.Handler so any origin is accepted, matching the cors config
+	server := websocket.Server{Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		replay, events, unsubscribe := node.events.Subscribe(last_id, filter)
+		defer unsubscribe()
+
+		// messages from the client are ignored, reading is only used to notice when it disconnects
+		closed := make(chan struct{})
+		go func() {
+			var message []byte
+			for websocket.Message.Receive(conn, &message) == nil {
+			}
+			close(closed)
+		}()
+
+		for _, event := range replay {
+			if websocket.JSON.Send(conn, event) != nil {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-conn.Request().Context().Done():
+				// the server is shutting down
+				return
+			case event, ok := <-events:
+				if !ok || websocket.JSON.Send(conn, event) != nil {
+					return
+				}
+			}
+		}
+	}}
+
+	server.ServeHTTP(e.Response(), e.Request())
+	return nil
+}
+
+//
+// wallet sign in endpoints
+//
+
+type challengeRequest struct {
+	Address string `json:"address"` // bech32 or hex
+}
+
+type signInRequest struct {
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"` // hex COSE_Sign1 from CIP-30 signData
+	Key       string `json:"key"`       // hex COSE_Key from CIP-30 signData
+}
+
+type sessionResponse struct {
+	Token   string    `json:"token,omitempty"`
+	Subject string    `json:"subject"`
+	Address string    `json:"address,omitempty"`
+	Roles   []string  `json:"roles"`
+	Expires time.Time `json:"expires"`
+}
+
+func newSessionResponse(token string, claims *AdminClaims) *sessionResponse {
+	return &sessionResponse{
+		Token:   token,
+		Subject: claims.Subject,
+		Address: claims.Address,
+		Roles:   claims.Roles,
+		Expires: time.Unix(claims.ExpiresAt, 0).UTC(),
+	}
+}
+
+func (node *Node) authChallenge(e echo.Context) error {
+	body := &challengeRequest{}
+	err := e.Bind(body)
+	if err != nil || body.Address == "" {
+		return invalidRequest(e, "an address is required")
+	}
+
+	challenge, err := node.NewWalletChallenge(body.Address)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, challenge)
+}
+
+// hex lengths accepted for sign in, a COSE_Sign1 of the challenge is a few hundred bytes and a COSE_Key under 100
+const (
+	max_signature_hex = 8192
+	max_key_hex       = 512
+)
+
+func (node *Node) authVerify(e echo.Context) error {
+	body := &signInRequest{}
+	err := e.Bind(body)
+	if err != nil || body.Nonce == "" || body.Signature == "" || body.Key == "" {
+		return invalidRequest(e, "nonce, signature and key are required")
+	}
+	if len(body.Signature) > max_signature_hex || len(body.Key) > max_key_hex {
+		return invalidRequest(e, "signature or key is too long")
+	}
+
+	token, claims, err := node.WalletSignIn(body.Nonce, body.Signature, body.Key)
+	if err != nil {
+		return apiError(e, err)
+	}
+
+	return e.JSON(http.StatusOK, newSessionResponse(token, claims))
+}
+
+func authSession(e echo.Context) error {
+	claims, _ := e.Get("claims").(*AdminClaims)
+	return e.JSON(http.StatusOK, newSessionResponse("", claims))
+}
+
 //
 // db status endpoints
 //
 
-func dbMeta(e echo.Context) error {
-	meta, err := CardanoDBMeta()
+func (node *Node) dbMeta(e echo.Context) error {
+	meta, err := node.CardanoDBMeta(e.Request().Context())
 
 	if err != nil {
-		e.Logger().Error(err)
-		return e.JSON(http.StatusInternalServerError, &errorMsg{Error: "internal server error"})
+		return apiError(e, err)
 	}
 
 	return e.JSON(http.StatusOK, meta)
 }
 
-func dbSyncStatus(e echo.Context) error {
-	sync_status, err := CardanoDBSyncStatus()
+func (node *Node) dbSyncStatus(e echo.Context) error {
+	sync_status, err := node.CardanoDBSyncStatus(e.Request().Context())
 
 	if err != nil {
-		e.Logger().Error(err)
-		return e.JSON(http.StatusInternalServerError, &errorMsg{Error: "internal server error"})
+		return apiError(e, err)
 	}
 
 	return e.JSON(http.StatusOK, sync_status)
 }
 
-func dbBlockStatus(e echo.Context) error {
-	block_status, err := CardanoDBBlockStatus()
+func (node *Node) dbBlockStatus(e echo.Context) error {
+	block_status, err := node.CardanoDBBlockStatus(e.Request().Context())
 
 	if err != nil {
-		e.Logger().Error(err)
-		return e.JSON(http.StatusInternalServerError, &errorMsg{Error: "internal server error"})
+		return apiError(e, err)
 	}
 
 	return e.JSON(http.StatusOK, block_status)
 }
 
-func dbOverview(e echo.Context) error {
-	overview, err := CardanoDBOverview()
+func (node *Node) dbOverview(e echo.Context) error {
+	overview, err := node.CardanoDBOverview(e.Request().Context())
 
 	if err != nil {
-		e.Logger().Error(err)
-		return e.JSON(http.StatusInternalServerError, &errorMsg{Error: "internal server error"})
+		return apiError(e, err)
 	}
 
 	return e.JSON(http.StatusOK, overview)
 }
 
+//
+// health endpoints
+//
+
+func (node *Node) healthz(e echo.Context) error {
+	// always 200 so a degraded dependency is reported without the orchestrator restarting the server
+	return e.JSON(http.StatusOK, node.CheckHealth(e.Request().Context()))
+}
+
+func (node *Node) readyz(e echo.Context) error {
+	report := node.CheckHealth(e.Request().Context())
+	if report.Status == HealthFail {
+		return e.JSON(http.StatusServiceUnavailable, report)
+	}
+	return e.JSON(http.StatusOK, report)
+}
+
 //
 // server / router
 //
 
-func CuratorServer() error {
+func (node *Node) CuratorServer(ctx context.Context) error {
+	node.default_actor = AuditActor{Name: "server", Source: "api"}
+
+	// deliver webhooks for changes made through the admin api, the daemon does the same for its own
+	worker_ctx, stop_worker := context.WithCancel(ctx)
+	worker_stopped := make(chan struct{})
+	go func() {
+		defer close(worker_stopped)
+		node.runWebhookWorker(worker_ctx)
+	}()
 
-	port := os.Getenv("DBRANCH_SERVER_PORT")
-	if port == "" {
-		port = "1323"
+	err := node.runCuratorServer(ctx)
+	stop_worker()
+	<-worker_stopped
+	return err
+}
+
+func (node *Node) runCuratorServer(ctx context.Context) error {
+	/* serve the api until ctx is done, then stop accepting requests and wait for open ones to finish */
+	server := node.newCuratorServer()
+
+	// requests inherit ctx so event streams end when shutdown starts rather than holding it open
+	server.Server.BaseContext = func(net.Listener) context.Context {
+		return ctx
 	}
 
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Start(":" + node.config.ServerPort)
+	}()
+
+	select {
+	case err := <-stopped:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown_ctx, cancel := context.WithTimeout(context.Background(), node.config.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdown_ctx)
+	if err != nil {
+		server.Close()
+		return errors.New("could not shut down server: " + err.Error())
+	}
+	return nil
+}
+
+func (node *Node) newCuratorServer() *echo.Echo {
 	server := echo.New()
+	server.HTTPErrorHandler = httpErrorHandler
 
+	// no request needs a large body, the biggest are signed tx envelopes
+	server.Use(middleware.Recover())
+	server.Use(middleware.BodyLimit("1M"))
+	server.Use(metricsMiddleware())
 	server.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
 	}))
 
 	prefix := "/api/v0"
 
-	server.GET(prefix+"/article/index", articleIndex)
-	server.GET(prefix+"/article/cid/:cid", articleGetByCid)
-
-	server.GET(prefix+"/db/meta", dbMeta)
-	server.GET(prefix+"/db/sync", dbSyncStatus)
-	server.GET(prefix+"/db/block", dbBlockStatus)
-	server.GET(prefix+"/db/overview", dbOverview)
+	server.GET(prefix+"/article/index", node.articleIndex)
+	server.GET(prefix+"/article/cid/:cid", node.articleGetByCid)
+	server.GET(prefix+"/article/cid/:cid/render", node.articleRenderByCid)
+	server.GET(prefix+"/article/cid/:cid/text", node.articleTextByCid)
+	server.GET(prefix+"/article/types", articleTypeList)
+
+	server.GET(prefix+"/curator/rejected", node.curatorRejected)
+	server.GET(prefix+"/curator/blocklist", node.blocklistGet)
+
+	server.GET(prefix+"/db/meta", node.dbMeta)
+	server.GET(prefix+"/db/sync", node.dbSyncStatus)
+	server.GET(prefix+"/db/block", node.dbBlockStatus)
+	server.GET(prefix+"/db/overview", node.dbOverview)
+
+	server.GET("/metrics", echo.WrapHandler(MetricsHandler()))
+	server.GET("/healthz", node.healthz)
+	server.GET("/readyz", node.readyz)
+
+	server.GET(prefix+"/events", node.eventStream)
+	server.GET(prefix+"/events/ws", node.eventWebSocket)
+
+	server.POST(prefix+"/auth/challenge", node.authChallenge)
+	server.POST(prefix+"/auth/verify", node.authVerify)
+	server.GET(prefix+"/auth/session", authSession, node.sessionAuth())
+
+	admin := server.Group(prefix+"/admin", node.adminAuth())
+	moderator, operator, editor := requireRole(RoleModerator), requireRole(RoleOperator), requireRole(RoleEditor)
+
+	admin.GET("/pending", node.adminPendingList, moderator)
+	admin.POST("/pending/:name/approve", node.adminPendingApprove, moderator)
+	admin.POST("/pending/:name/reject", node.adminPendingReject, moderator)
+	admin.GET("/moderation", node.adminModerationLog, moderator)
+	admin.GET("/blocklist", node.blocklistGet, moderator)
+	admin.POST("/blocklist", node.adminBlockAdd, moderator)
+	admin.DELETE("/blocklist/:kind/:value", node.adminBlockRemove, moderator)
+	admin.POST("/blocklist/import", node.adminBlockImport, moderator)
+
+	admin.POST("/curate", node.adminCurate, operator)
+	admin.POST("/publish", node.adminPublish, operator)
+	admin.DELETE("/article/:name", node.adminArticleRemove, operator)
+	admin.POST("/index/refresh", node.adminIndexRefresh, operator)
+
+	admin.POST("/sign", node.adminSign, editor)
+	admin.POST("/sign/estimate", node.adminSignEstimate, editor)
+	admin.POST("/sign/batch", node.adminSignBatch, editor)
+	admin.POST("/sign/batch/estimate", node.adminSignBatchEstimate, editor)
+	admin.POST("/sign/export", node.adminSignExport, editor)
+	admin.POST("/sign/submit", node.adminSignSubmit, editor)
+	admin.GET("/sign/pending", node.adminSignatures, editor)
+
+	admin.GET("/audit", node.adminAuditLog)
 
 	server.GET("/*", func(c echo.Context) error {
-		return c.String(http.StatusNotFound, "not found")
+		return c.JSON(http.StatusNotFound, &errorMsg{Error: "not found", Code: CodeNotFound})
 	})
 
-	err := server.Start(":" + port)
-	server.Logger.Fatal(err)
-	return err
+	return server
 }
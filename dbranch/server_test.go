@@ -0,0 +1,62 @@
+package dbranch
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func apiRequest(t *testing.T, server *echo.Echo, method, target, token string, body interface{}) *httptest.ResponseRecorder {
+	/* make a request to the api with a bearer token if set, body is encoded as json */
+	t.Helper()
+	payload := &bytes.Buffer{}
+	if body != nil {
+		json.NewEncoder(payload).Encode(body)
+	}
+
+	request := httptest.NewRequest(method, target, payload)
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSignRequiresPassphrase(t *testing.T) {
+	sign := map[string]interface{}{"wallet_id": "wallet-1", "address": "addr_test1", "path": "/dBranch/drafts/article"}
+	batch := map[string]interface{}{"wallet_id": "wallet-1", "address": "addr_test1", "paths": []string{"/dBranch/drafts/article"}}
+
+	config := DefaultConfig()
+	config.WalletPassphrase = "configured"
+	node := newTestNode(t, config)
+	key, err := node.AddAdminKey("editor", []string{RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := node.newCuratorServer()
+
+	// the configured passphrase isn't used for api requests unless the server opts in
+	for target, body := range map[string]interface{}{"/api/v0/admin/sign": sign, "/api/v0/admin/sign/batch": batch} {
+		response := apiRequest(t, server, http.MethodPost, target, key, body)
+		if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "a passphrase is required") {
+			t.Errorf("%s: expected a passphrase to be required, got %d: %s", target, response.Code, response.Body.String())
+		}
+	}
+
+	config.ServerPassphrase = true
+	fake, shell := newFakeIpfs(t)
+	fake.fail("files/stat", "file does not exist")
+	node = newTestNode(t, config, shell)
+	key, _ = node.AddAdminKey("editor", []string{RoleEditor})
+	response := apiRequest(t, node.newCuratorServer(), http.MethodPost, "/api/v0/admin/sign", key, sign)
+	if strings.Contains(response.Body.String(), "passphrase") {
+		t.Errorf("expected the configured passphrase to be used, got %d: %s", response.Code, response.Body.String())
+	}
+}
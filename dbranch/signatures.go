@@ -0,0 +1,287 @@
+package dbranch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+)
+
+//
+// signatures submitted to the wallet, their record is published once the tx is in the ledger and db-sync
+//
+
+const (
+	SignatureUnsigned  = "unsigned"  // exported for signing outside the wallet, waiting for the signed tx, see ExportSignature
+	SignatureSubmitted = "submitted" // waiting for the tx to be added to the ledger
+	SignatureInLedger  = "in_ledger" // waiting for db-sync to have the tx
+)
+
+type PendingSignature struct {
+	TxHash      string          `json:"tx_hash"`
+	WalletID    string          `json:"wallet_id"`
+	Articles    []SignedArticle `json:"articles"` // in the order of the tx's label 451 list
+	Actor       string          `json:"actor"`
+	Source      string          `json:"source"`
+	Status      string          `json:"status"`
+	External    bool            `json:"external,omitempty"` // signed outside the wallet and submitted through its proxy
+	Submitted   time.Time       `json:"submitted"`          // or exported while it is unsigned
+	Expires     time.Time       `json:"expires"`            // given up on if not published by then, see Config.SignatureTimeout
+	LastChecked time.Time       `json:"last_checked,omitempty"`
+	LastError   string          `json:"last_error,omitempty"` // the last error that was retried, ie. the wallet being unreachable
+}
+
+func (pending *PendingSignature) actor() *AuditActor {
+	return &AuditActor{Name: pending.Actor, Source: pending.Source}
+}
+
+func (node *Node) pendingSignaturesPath() string {
+	return node.statePath(node.config.PendingSignaturesFile, "pending_signatures.json")
+}
+
+func (node *Node) withPendingSignatures(update func(pending []*PendingSignature) ([]*PendingSignature, error)) error {
+	// the cli waiting on a signature and the daemon may update the file at once
+	node.pending_signatures_lock.Lock()
+	defer node.pending_signatures_lock.Unlock()
+
+	file, err := os.OpenFile(node.pendingSignaturesPath(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	pending := []*PendingSignature{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		err = json.Unmarshal(data, &pending)
+		if err != nil {
+			return errors.New("error decoding pending signatures: " + err.Error())
+		}
+	}
+
+	pending, err = update(pending)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(pending, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt(data, 0)
+	return err
+}
+
+func (node *Node) ListPendingSignatures() ([]*PendingSignature, error) {
+	var list []*PendingSignature
+	err := node.withPendingSignatures(func(pending []*PendingSignature) ([]*PendingSignature, error) {
+		list = pending
+		return pending, nil
+	})
+	return list, err
+}
+
+func (node *Node) GetPendingSignature(tx_hash string) (*PendingSignature, error) {
+	pending, err := node.ListPendingSignatures()
+	if err != nil {
+		return nil, err
+	}
+	for _, signature := range pending {
+		if signature.TxHash == tx_hash {
+			return signature, nil
+		}
+	}
+	return nil, fmt.Errorf("pending signature %w: %s", ErrNotFound, tx_hash)
+}
+
+func (node *Node) trackSignature(signature *PendingSignature) error {
+	return node.withPendingSignatures(func(pending []*PendingSignature) ([]*PendingSignature, error) {
+		return append(pending, signature), nil
+	})
+}
+
+//
+// checking pending signatures
+//
+
+func retrySignatureError(err error) bool {
+	// errors from the services being unavailable are retried until the signature expires, anything else is final
+	switch ErrorCode(err) {
+	case CodeInternal, CodeUpstream, CodeWallet:
+		return true
+	}
+	return false
+}
+
+func (node *Node) checkSignature(ctx context.Context, signature *PendingSignature) ([]*ArticleRecord, error) {
+	/*
+		check the wallet and db-sync for a pending signature and publish its records once db-sync has the tx
+		returns a record for each article in the tx when they were published, nil and no error while it is still pending
+		the returned error is final unless retrySignatureError is true for it, the records published before a final error are returned with it
+	*/
+	signature.LastChecked = time.Now().UTC()
+
+	if signature.Status == SignatureUnsigned {
+		// nothing to check until the signed tx is submitted
+		return nil, nil
+	}
+
+	if signature.Status != SignatureInLedger {
+		tx, err := node.wallet.GetTransaction(ctx, signature.WalletID, signature.TxHash)
+
+		var wallet_err *wallet.Error
+		if errors.As(err, &wallet_err) && wallet_err.Code == "no_such_transaction" && signature.External {
+			// the wallet doesn't track txs submitted through its proxy until they are in the ledger
+			return nil, nil
+		} else if errors.As(err, &wallet_err) && wallet_err.Code == "no_such_transaction" {
+			// the wallet forgets txs that expired before they were added to the ledger
+			return nil, fmt.Errorf("%w: the wallet no longer has tx %s", ErrSignatureExpired, signature.TxHash)
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch tx.Status {
+		case wallet.TxExpired:
+			return nil, fmt.Errorf("%w: tx %s expired before it was added to the ledger", ErrSignatureExpired, signature.TxHash)
+		case wallet.TxInLedger:
+			signature.Status = SignatureInLedger
+		default:
+			return nil, nil
+		}
+	}
+
+	records, err := node.PublishRecordsByCardanoTxHash(ctx, signature.TxHash, signature.actor())
+	if errors.Is(err, ErrRecordNotFound) {
+		// in the ledger but db-sync hasn't caught up yet
+		return nil, nil
+	} else if err != nil && retrySignatureError(err) {
+		// publishing is retried for every article of the tx, the ones already published are written again
+		return nil, err
+	}
+	return records, err
+}
+
+func (node *Node) CheckPendingSignature(ctx context.Context, tx_hash string) (*PendingSignature, []*ArticleRecord, error) {
+	/*
+		check one pending signature, see checkSignature
+		it is removed once published or when it fails or expires, the error is returned in that case
+	*/
+	signature, err := node.GetPendingSignature(tx_hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, check_err := node.checkSignature(ctx, signature)
+	if ctx.Err() != nil {
+		return signature, nil, ctx.Err()
+	}
+
+	done := records != nil || (check_err != nil && !retrySignatureError(check_err))
+	if !done && time.Now().After(signature.Expires) {
+		check_err = fmt.Errorf("%w: tx %s was not published by %s", ErrSignatureExpired, signature.TxHash, signature.Expires.Format(time.RFC3339))
+		if signature.Status == SignatureUnsigned {
+			check_err = fmt.Errorf("%w: signed tx %s was not submitted by %s", ErrSignatureExpired, signature.TxHash, signature.Expires.Format(time.RFC3339))
+		}
+		done = true
+	}
+
+	if check_err != nil {
+		signature.LastError = check_err.Error()
+	} else {
+		signature.LastError = ""
+	}
+
+	err = node.withPendingSignatures(func(pending []*PendingSignature) ([]*PendingSignature, error) {
+		updated := []*PendingSignature{}
+		for _, existing := range pending {
+			if existing.TxHash != signature.TxHash {
+				updated = append(updated, existing)
+			} else if !done {
+				updated = append(updated, signature)
+			}
+		}
+		return updated, nil
+	})
+	if err != nil {
+		return signature, records, err
+	}
+
+	if done && check_err != nil {
+		published := map[string]bool{}
+		for _, record := range records {
+			published[record.Name] = true
+		}
+		for _, article := range signature.Articles {
+			if !published[article.Name] {
+				node.audit(signature.actor(), "sign_failed", article.record(signature.TxHash), signature.WalletID, check_err)
+			}
+		}
+		return signature, records, check_err
+	}
+	return signature, records, nil
+}
+
+func (node *Node) WaitForSignature(ctx context.Context, tx_hash string, interval time.Duration) ([]*ArticleRecord, error) {
+	/* check a pending signature every interval until its records are published, it fails or ctx is done */
+	for {
+		_, records, err := node.CheckPendingSignature(ctx, tx_hash)
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w, it may have been published or failed in another process, see audit query --tx %s", err, tx_hash)
+		} else if records != nil || err != nil {
+			return records, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (node *Node) ProcessPendingSignatures(ctx context.Context) error {
+	/* check each pending signature once, called by the daemon loop */
+	pending, err := node.ListPendingSignatures()
+	if err != nil {
+		return err
+	}
+
+	for _, signature := range pending {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_, records, err := node.CheckPendingSignature(ctx, signature.TxHash)
+		for _, record := range records {
+			log.Printf("published signed article: %s: %s\n", record.Name, signature.TxHash)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("signature failed: %s: %s\n", signature.TxHash, err)
+		}
+	}
+	return nil
+}
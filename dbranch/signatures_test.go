@@ -0,0 +1,155 @@
+package dbranch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet/wallettest"
+)
+
+var signed_tx = strings.Repeat("ab", 32)
+
+var signed_articles = []SignedArticle{
+	{Name: "first", Location: "ipfs://QmFirst"},
+	{Name: "second", Location: "ipfs://QmSecond"},
+}
+
+func newSignatureNode(t *testing.T) (*Node, *wallettest.Server, *fakeDBSync) {
+	/* a node with a fake wallet that has one wallet and a fake db-sync */
+	t.Helper()
+	server := wallettest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddWallet(wallet.Wallet{ID: "wallet"}, "passphrase")
+
+	_, shell := newFakeIpfs(t)
+	db_sync, db := newFakeDBSync(t)
+	node := newTestNode(t, nil, shell, db, WithWalletClient(server.WalletClient()))
+	return node, server, db_sync
+}
+
+func trackTestSignature(t *testing.T, node *Node, status string, external bool, expires time.Time) {
+	t.Helper()
+	err := node.trackSignature(&PendingSignature{
+		TxHash:    signed_tx,
+		WalletID:  "wallet",
+		Articles:  signed_articles,
+		Actor:     "tester",
+		Source:    "cli",
+		Status:    status,
+		External:  external,
+		Submitted: time.Now().UTC(),
+		Expires:   expires,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestSignature(t *testing.T, node *Node) ([]*ArticleRecord, error) {
+	t.Helper()
+	_, records, err := node.CheckPendingSignature(context.Background(), signed_tx)
+	return records, err
+}
+
+func expectPendingSignatureStatus(t *testing.T, node *Node, tx_hash, status string) {
+	t.Helper()
+	signature, err := node.GetPendingSignature(tx_hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.Status != status {
+		t.Errorf("expected status: %s, got: %s", status, signature.Status)
+	}
+}
+
+func expectNoPendingSignature(t *testing.T, node *Node) {
+	t.Helper()
+	_, err := node.GetPendingSignature(signed_tx)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the signature to be removed, got: %v", err)
+	}
+}
+
+func TestSignaturePublished(t *testing.T) {
+	// submitted -> in_ledger -> published once db-sync has the tx
+	node, server, db_sync := newSignatureNode(t)
+	server.AddTransaction("wallet", wallet.Transaction{ID: signed_tx, Status: wallet.TxPending})
+	trackTestSignature(t, node, SignatureSubmitted, false, time.Now().Add(time.Hour))
+
+	records, err := checkTestSignature(t, node)
+	if records != nil || err != nil {
+		t.Fatalf("expected the pending tx to be waited on, got: %v, %v", records, err)
+	}
+	expectPendingSignatureStatus(t, node, signed_tx, SignatureSubmitted)
+
+	// in the ledger but not in db-sync yet
+	server.SetTransactionStatus("wallet", signed_tx, wallet.TxInLedger)
+	records, err = checkTestSignature(t, node)
+	if records != nil || err != nil {
+		t.Fatalf("expected to wait for db-sync, got: %v, %v", records, err)
+	}
+	expectPendingSignatureStatus(t, node, signed_tx, SignatureInLedger)
+
+	db_sync.addTx(signed_tx, signed_articles...)
+	records, err = checkTestSignature(t, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Name != "first" || records[1].Name != "second" {
+		t.Errorf("expected a record for each article, got: %v", records)
+	}
+	expectNoPendingSignature(t, node)
+}
+
+func TestSignatureExpired(t *testing.T) {
+	for name, tx := range map[string]*wallet.Transaction{
+		"expired in wallet": {ID: signed_tx, Status: wallet.TxExpired},
+		"not in wallet":     nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			node, server, _ := newSignatureNode(t)
+			if tx != nil {
+				server.AddTransaction("wallet", *tx)
+			}
+			trackTestSignature(t, node, SignatureSubmitted, false, time.Now().Add(time.Hour))
+
+			_, err := checkTestSignature(t, node)
+			if !errors.Is(err, ErrSignatureExpired) {
+				t.Errorf("expected: %s, got: %v", ErrSignatureExpired, err)
+			}
+			expectNoPendingSignature(t, node)
+		})
+	}
+}
+
+func TestSignatureExternal(t *testing.T) {
+	// the wallet doesn't know txs submitted through its proxy until they are in the ledger
+	node, _, _ := newSignatureNode(t)
+	trackTestSignature(t, node, SignatureSubmitted, true, time.Now().Add(time.Hour))
+
+	records, err := checkTestSignature(t, node)
+	if records != nil || err != nil {
+		t.Fatalf("expected the external tx to be waited on, got: %v, %v", records, err)
+	}
+	expectPendingSignatureStatus(t, node, signed_tx, SignatureSubmitted)
+}
+
+func TestSignatureTimeout(t *testing.T) {
+	for _, status := range []string{SignatureUnsigned, SignatureSubmitted, SignatureInLedger} {
+		t.Run(status, func(t *testing.T) {
+			node, server, _ := newSignatureNode(t)
+			server.AddTransaction("wallet", wallet.Transaction{ID: signed_tx, Status: wallet.TxPending})
+			trackTestSignature(t, node, status, false, time.Now().Add(-time.Minute))
+
+			_, err := checkTestSignature(t, node)
+			if !errors.Is(err, ErrSignatureExpired) {
+				t.Errorf("expected: %s, got: %v", ErrSignatureExpired, err)
+			}
+			expectNoPendingSignature(t, node)
+		})
+	}
+}
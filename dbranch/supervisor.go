@@ -0,0 +1,152 @@
+package dbranch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//
+// run the poller, wire listener and server in one process with shared state
+//
+
+type Component struct {
+	Name string
+	Run  func(ctx context.Context) error // should return when ctx is done, any other return is treated as a crash
+}
+
+// delay before restarting a crashed component, doubled after each crash up to the max
+const (
+	restart_min_backoff = time.Second
+	restart_max_backoff = time.Minute
+)
+
+// a component that ran this long before crashing is restarted without waiting for the backoff to build up again
+const restart_reset_after = 5 * time.Minute
+
+func (node *Node) CuratorRun(ctx context.Context) error {
+	/* run the cardano poller, wire listener and server until ctx is done */
+	log.Println("dBranch curator starting")
+	node.default_actor = *daemon_actor
+
+	unlock, err := node.lockCuratorDir()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the server serves metrics so they don't need their own component here
+	Supervise(ctx,
+		Component{Name: "poller", Run: node.runCardanoPoller},
+		Component{Name: "wire", Run: node.runWireListener},
+		Component{Name: "server", Run: node.runCuratorServer},
+		Component{Name: "webhooks", Run: node.runWebhookWorker},
+	)
+
+	log.Println("dBranch curator stopped")
+	return nil
+}
+
+func Supervise(ctx context.Context, components ...Component) {
+	/* run each component in its own goroutine, restarting it with backoff if it crashes, returns once all have stopped */
+	var wait sync.WaitGroup
+	for _, component := range components {
+		wait.Add(1)
+		go func(component Component) {
+			defer wait.Done()
+			superviseComponent(ctx, component)
+		}(component)
+	}
+	wait.Wait()
+}
+
+func superviseComponent(ctx context.Context, component Component) {
+	backoff := restart_min_backoff
+
+	for {
+		log.Printf("starting %s\n", component.Name)
+		start := time.Now()
+		err := runComponent(ctx, component)
+		if ctx.Err() != nil {
+			log.Printf("stopped %s\n", component.Name)
+			return
+		}
+
+		if err == nil {
+			err = errors.New("exited unexpectedly")
+		}
+		if time.Since(start) >= restart_reset_after {
+			backoff = restart_min_backoff
+		}
+
+		component_restarts.Inc(component.Name)
+		log.Printf("%s crashed: %s, restarting in %s\n", component.Name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			log.Printf("stopped %s\n", component.Name)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > restart_max_backoff {
+			backoff = restart_max_backoff
+		}
+	}
+}
+
+func runComponent(ctx context.Context, component Component) (err error) {
+	// a panic in one component is restarted like any other crash rather than taking down the process
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return component.Run(ctx)
+}
+
+//
+// lock file
+//
+
+func (node *Node) lockCuratorDir() (func(), error) {
+	/*
+		hold an exclusive lock on ~/.dbranch/curator.lock so two daemons can't share the state dir
+		the lock is released by the returned func or by the os if the process dies
+	*/
+	lock_path := path.Join(node.config.Dir, "curator.lock")
+	file, err := os.OpenFile(lock_path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		pid, _ := os.ReadFile(lock_path)
+		file.Close()
+		return nil, fmt.Errorf("%w: %s (pid %s)", ErrCuratorLocked, node.config.Dir, strings.TrimSpace(string(pid)))
+	} else if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// the pid is informational, the flock is what prevents a second daemon
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	unlock := func() {
+		file.Truncate(0)
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}
+	return unlock, nil
+}
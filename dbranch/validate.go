@@ -0,0 +1,142 @@
+package dbranch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+//
+// validation
+//
+
+func ValidateArticle(article *Article) error {
+	if article.Metadata == nil {
+		return errors.New("missing metadata")
+	}
+
+	if article.Metadata.Type == "" {
+		return errors.New("missing metadata.type")
+	}
+
+	if article.Metadata.Title == "" {
+		return errors.New("missing metadata.title")
+	}
+
+	if article.Metadata.Author == "" {
+		return errors.New("missing metadata.author")
+	}
+
+	article_type, err := LookupArticleType(article.Metadata.Type)
+	if err != nil {
+		return err
+	}
+
+	if article.Contents == nil {
+		return errors.New("missing contents")
+	}
+
+	return article_type.Validate(article.Contents)
+}
+
+func (node *Node) DecodeArticle(reader io.Reader) (*Article, error) {
+	// read one byte past the limit so oversized articles can be detected without reading the whole thing
+	data, err := io.ReadAll(io.LimitReader(reader, node.config.MaxArticleSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > node.config.MaxArticleSize {
+		return nil, fmt.Errorf("%w: exceeds max size of %d bytes", ErrInvalidArticle, node.config.MaxArticleSize)
+	}
+
+	article := &Article{}
+	err = json.Unmarshal(data, article)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode: %s", ErrInvalidArticle, err)
+	}
+
+	err = ValidateArticle(article)
+	if errors.Is(err, ErrUnknownArticleType) {
+		// kept distinct so the unknown type policy can quarantine it
+		return nil, fmt.Errorf("invalid article: %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArticle, err)
+	}
+
+	return article, nil
+}
+
+//
+// rejection log
+//
+
+type ArticleRejection struct {
+	Time          time.Time `json:"time"`
+	Name          string    `json:"name"`
+	CID           string    `json:"cid"`
+	CardanoTxHash string    `json:"cardano_tx_hash,omitempty"`
+	Reason        string    `json:"reason"`
+}
+
+func (node *Node) rejectionLogPath() string {
+	return path.Join(node.config.Dir, "rejected.jsonl")
+}
+
+func (node *Node) recordRejection(record *ArticleRecord, reason error) {
+	rejection := &ArticleRejection{
+		Time:          time.Now().UTC(),
+		Name:          record.Name,
+		CID:           record.CID,
+		CardanoTxHash: record.CardanoTxHash,
+		Reason:        reason.Error(),
+	}
+
+	log.Printf("rejected article: %s (%s): %s\n", record.Name, record.CID, rejection.Reason)
+
+	file, err := os.OpenFile(node.rejectionLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("can't open rejection log: %s\n", err)
+		return
+	}
+	defer file.Close()
+
+	err = json.NewEncoder(file).Encode(rejection)
+	if err != nil {
+		log.Printf("error writing to rejection log: %s\n", err)
+	}
+}
+
+func (node *Node) ListRejections() ([]ArticleRejection, error) {
+	rejections := []ArticleRejection{}
+
+	data, err := os.ReadFile(node.rejectionLogPath())
+	if os.IsNotExist(err) {
+		return rejections, nil
+	} else if err != nil {
+		return rejections, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		rejection := ArticleRejection{}
+		err = json.Unmarshal(scanner.Bytes(), &rejection)
+		if err != nil {
+			return rejections, errors.New("error decoding rejection log: " + err.Error())
+		}
+		rejections = append(rejections, rejection)
+	}
+
+	return rejections, scanner.Err()
+}
@@ -0,0 +1,334 @@
+// Package wallet is a typed client for the cardano-wallet v2 api.
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// client
+//
+
+type Client struct {
+	// base url of the wallet api, ie. http://localhost:8090
+	Host string
+
+	HTTP *http.Client
+
+	// time allowed for each request, 0 for no limit other than the caller's context
+	Timeout time.Duration
+}
+
+func NewClient(host string, http_client *http.Client) *Client {
+	if http_client == nil {
+		http_client = &http.Client{}
+	}
+	return &Client{Host: host, HTTP: http_client}
+}
+
+func (client *Client) do(ctx context.Context, method, endpoint string, query url.Values, body, out interface{}) error {
+	/*
+		send a request and decode a 2xx response into out, a []byte body is sent as is rather than as json
+		any other status is returned as an *Error
+	*/
+	if client.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+		defer cancel()
+	}
+
+	req_url := client.Host + endpoint
+	if len(query) > 0 {
+		req_url += "?" + query.Encode()
+	}
+
+	var req_body io.Reader
+	content_type := "application/json; charset=UTF-8"
+	if raw, ok := body.([]byte); ok {
+		req_body = bytes.NewReader(raw)
+		content_type = "application/octet-stream"
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.New("error encoding wallet request: " + err.Error())
+		}
+		req_body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, req_url, req_body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", content_type)
+	}
+
+	resp, err := client.HTTP.Do(req)
+	if err != nil {
+		return errors.New(method + " " + req_url + " returned error: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return errors.New("error decoding response from " + method + " " + req_url + ": " + err.Error())
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) *Error {
+	/* the error body of a failed request, the message is the raw body if it isn't the wallet's error json */
+	wallet_err := &Error{Status: resp.Status, StatusCode: resp.StatusCode}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		wallet_err.Message = "error reading response: " + err.Error()
+		return wallet_err
+	}
+
+	err = json.Unmarshal(data, wallet_err)
+	if err != nil || wallet_err.Code == "" {
+		wallet_err.Code = "unexpected_response"
+		wallet_err.Message = string(bytes.TrimSpace(data))
+	}
+	return wallet_err
+}
+
+//
+// wallets
+//
+
+func (client *Client) ListWallets(ctx context.Context) ([]Wallet, error) {
+	wallets := []Wallet{}
+	err := client.do(ctx, http.MethodGet, "/v2/wallets", nil, nil, &wallets)
+	return wallets, err
+}
+
+func (client *Client) GetWallet(ctx context.Context, wallet_id string) (*Wallet, error) {
+	wallet := &Wallet{}
+	err := client.do(ctx, http.MethodGet, "/v2/wallets/"+url.PathEscape(wallet_id), nil, nil, wallet)
+	if err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+func (client *Client) ListAddresses(ctx context.Context, wallet_id, state string) ([]Address, error) {
+	/* addresses of a wallet, state filters to "used" or "unused" and "" lists all */
+	query := url.Values{}
+	if state != "" {
+		query.Set("state", state)
+	}
+
+	addresses := []Address{}
+	err := client.do(ctx, http.MethodGet, "/v2/wallets/"+url.PathEscape(wallet_id)+"/addresses", query, nil, &addresses)
+	return addresses, err
+}
+
+//
+// transactions
+//
+
+const default_page_size = 100
+
+// largest page requested when more than a page of transactions share a timestamp
+const max_page_size = 10000
+
+type TransactionQuery struct {
+	// optional time range, either may be zero
+	Start time.Time
+	End   time.Time
+
+	// transactions requested per page, 0 for default_page_size
+	PageSize int
+}
+
+func (client *Client) ListTransactions(ctx context.Context, wallet_id string, query TransactionQuery) ([]Transaction, error) {
+	/*
+		all transactions of a wallet in query's range, newest first
+		pages are requested with max_count and the end of the range moved back to the oldest transaction of the
+		previous page, the wallet's range is inclusive so transactions seen on the previous page are skipped
+		if a full page has nothing new, more than a page of transactions share a timestamp and the page is made bigger
+	*/
+	page_size := query.PageSize
+	if page_size <= 0 {
+		page_size = default_page_size
+	}
+
+	endpoint := "/v2/wallets/" + url.PathEscape(wallet_id) + "/transactions"
+	end := query.End
+
+	transactions := []Transaction{}
+	seen := map[string]bool{}
+
+	for {
+		params := url.Values{}
+		params.Set("order", "descending")
+		params.Set("max_count", strconv.Itoa(page_size))
+		// the wallet takes whole seconds, the range is widened so it still includes the bounds
+		if !query.Start.IsZero() {
+			params.Set("start", query.Start.UTC().Truncate(time.Second).Format(time.RFC3339))
+		}
+		if !end.IsZero() {
+			end_second := end.UTC().Truncate(time.Second)
+			if end_second.Before(end) {
+				end_second = end_second.Add(time.Second)
+			}
+			params.Set("end", end_second.Format(time.RFC3339))
+		}
+
+		page := []Transaction{}
+		err := client.do(ctx, http.MethodGet, endpoint, params, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, tx := range page {
+			if seen[tx.ID] {
+				continue
+			}
+			seen[tx.ID] = true
+			transactions = append(transactions, tx)
+			added++
+
+			if tx_time := tx.Time(); !tx_time.IsZero() && (end.IsZero() || tx_time.Before(end)) {
+				end = tx_time
+			}
+		}
+
+		// a short page is the last one
+		if len(page) < page_size {
+			return transactions, nil
+		}
+
+		// a full page with nothing new is all transactions at the end of the range, request more at once
+		// so the ones past the page are included, if the wallet ignores max_count the page ends up short
+		if added == 0 {
+			if page_size >= max_page_size {
+				return nil, errors.New("more than " + strconv.Itoa(max_page_size) + " transactions at " + end.UTC().Format(time.RFC3339) + ", the list would be incomplete")
+			}
+			page_size *= 2
+			if page_size > max_page_size {
+				page_size = max_page_size
+			}
+		}
+	}
+}
+
+func (client *Client) GetTransaction(ctx context.Context, wallet_id, tx_id string) (*Transaction, error) {
+	tx := &Transaction{}
+	endpoint := "/v2/wallets/" + url.PathEscape(wallet_id) + "/transactions/" + url.PathEscape(tx_id)
+	err := client.do(ctx, http.MethodGet, endpoint, nil, nil, tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (client *Client) CreateTransaction(ctx context.Context, wallet_id string, request TransactionRequest) (*Transaction, error) {
+	/* sign and submit a transaction, the returned transaction is pending */
+	tx := &Transaction{}
+	err := client.do(ctx, http.MethodPost, "/v2/wallets/"+url.PathEscape(wallet_id)+"/transactions", nil, request, tx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.ID == "" {
+		return nil, errors.New("wallet accepted the transaction without returning its id")
+	}
+	return tx, nil
+}
+
+func (client *Client) PaymentFees(ctx context.Context, wallet_id string, request PaymentRequest) (*PaymentFees, error) {
+	/* estimated fee, deposit and min-UTxO of each payment for a transaction */
+	fees := &PaymentFees{}
+	err := client.do(ctx, http.MethodPost, "/v2/wallets/"+url.PathEscape(wallet_id)+"/payment-fees", nil, request, fees)
+	if err != nil {
+		return nil, err
+	}
+	return fees, nil
+}
+
+func (client *Client) ConstructTransaction(ctx context.Context, wallet_id string, request PaymentRequest) (*ConstructedTransaction, error) {
+	/* select coins and balance a transaction without signing or submitting it */
+	constructed := &ConstructedTransaction{}
+	err := client.do(ctx, http.MethodPost, "/v2/wallets/"+url.PathEscape(wallet_id)+"/transactions-construct", nil, request, constructed)
+	if err != nil {
+		return nil, err
+	}
+	return constructed, nil
+}
+
+func (client *Client) DecodeTransaction(ctx context.Context, wallet_id, transaction string) (*DecodedTransaction, error) {
+	/* the id, metadata and witnesses of a serialized transaction, transaction is base64 like the wallet returns */
+	decoded := &DecodedTransaction{}
+	body := map[string]string{"transaction": transaction}
+	err := client.do(ctx, http.MethodPost, "/v2/wallets/"+url.PathEscape(wallet_id)+"/transactions-decode", nil, body, decoded)
+	if err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func (client *Client) SubmitTransaction(ctx context.Context, transaction []byte) (string, error) {
+	/*
+		submit a transaction signed outside the wallet, returns its id
+		the wallet doesn't track txs submitted this way, they are listed once they are in the ledger
+	*/
+	submitted := struct {
+		ID string `json:"id"`
+	}{}
+	err := client.do(ctx, http.MethodPost, "/v2/proxy/transactions", nil, transaction, &submitted)
+	if err != nil {
+		return "", err
+	}
+	if submitted.ID == "" {
+		return "", errors.New("wallet accepted the transaction without returning its id")
+	}
+	return submitted.ID, nil
+}
+
+func TransactionBytes(transaction string) ([]byte, error) {
+	/* the cbor of a serialized transaction, which the wallet returns as base64 and cardano-cli writes as hex */
+	transaction = strings.TrimSpace(transaction)
+	if data, err := hex.DecodeString(transaction); err == nil {
+		return data, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(transaction)
+	if err != nil {
+		return nil, errors.New("transaction is neither hex nor base64")
+	}
+	return data, nil
+}
+
+//
+// network
+//
+
+func (client *Client) NetworkInformation(ctx context.Context) (*NetworkInformation, error) {
+	info := &NetworkInformation{}
+	err := client.do(ctx, http.MethodGet, "/v2/network/information", nil, nil, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,189 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet/wallettest"
+)
+
+const test_wallet = "2269611a3c10b219b0d38d74b004c298b76d16a9"
+
+func newTestServer(t *testing.T) (*wallettest.Server, *wallet.Client) {
+	t.Helper()
+	server := wallettest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddWallet(wallet.Wallet{ID: test_wallet, Name: "test"}, "passphrase")
+	return server, server.WalletClient()
+}
+
+func addTransactions(server *wallettest.Server, count int, at func(i int) time.Time) {
+	for i := 0; i < count; i++ {
+		server.AddTransaction(test_wallet, wallet.Transaction{
+			ID:         fmt.Sprintf("%064x", i),
+			Direction:  wallet.TxOutgoing,
+			Status:     wallet.TxInLedger,
+			InsertedAt: &wallet.BlockRef{Time: at(i)},
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	_, client := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := client.GetWallet(ctx, "0000000000000000000000000000000000000000")
+	var wallet_err *wallet.Error
+	if !errors.As(err, &wallet_err) {
+		t.Fatalf("expected a *wallet.Error, got %T: %v", err, err)
+	}
+	if wallet_err.Code != "no_such_wallet" || wallet_err.StatusCode != http.StatusNotFound || wallet_err.Status != "404 Not Found" {
+		t.Errorf("unexpected error: %+v", wallet_err)
+	}
+
+	_, err = client.CreateTransaction(ctx, test_wallet, wallet.TransactionRequest{
+		Passphrase: "wrong",
+		Payments:   []wallet.Payment{{Address: "addr_test1", Amount: wallet.Lovelace(2000000)}},
+	})
+	if !errors.As(err, &wallet_err) || wallet_err.Code != "wrong_encryption_passphrase" || wallet_err.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong passphrase: unexpected error: %v", err)
+	}
+
+	_, err = client.ConstructTransaction(ctx, test_wallet, wallet.PaymentRequest{
+		Payments: []wallet.Payment{{Address: "addr_test1", Amount: wallet.Lovelace(1)}},
+	})
+	if !errors.As(err, &wallet_err) || wallet_err.Code != "utxo_too_small" {
+		t.Errorf("small payment: unexpected error: %v", err)
+	}
+}
+
+func TestNonJSONErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "  <html>bad gateway</html>\n")
+	}))
+	defer server.Close()
+
+	_, err := wallet.NewClient(server.URL, nil).ListWallets(context.Background())
+	var wallet_err *wallet.Error
+	if !errors.As(err, &wallet_err) {
+		t.Fatalf("expected a *wallet.Error, got %T: %v", err, err)
+	}
+	if wallet_err.Code != "unexpected_response" || wallet_err.Message != "<html>bad gateway</html>" || wallet_err.StatusCode != http.StatusBadGateway {
+		t.Errorf("unexpected error: %+v", wallet_err)
+	}
+
+	// json without a code is unexpected too
+	json_server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"message": "boom"}`)
+	}))
+	defer json_server.Close()
+
+	_, err = wallet.NewClient(json_server.URL, nil).ListWallets(context.Background())
+	if !errors.As(err, &wallet_err) || wallet_err.Code != "unexpected_response" || wallet_err.Message != `{"message": "boom"}` {
+		t.Errorf("json without code: unexpected error: %v", err)
+	}
+}
+
+func checkTransactions(t *testing.T, transactions []wallet.Transaction, expected int) {
+	/* every transaction once, newest first */
+	t.Helper()
+	if len(transactions) != expected {
+		t.Fatalf("expected %d transactions, got %d", expected, len(transactions))
+	}
+	seen := map[string]bool{}
+	for i, tx := range transactions {
+		if seen[tx.ID] {
+			t.Fatalf("transaction listed twice: %s", tx.ID)
+		}
+		seen[tx.ID] = true
+		if i > 0 && tx.Time().After(transactions[i-1].Time()) {
+			t.Fatalf("transactions not newest first at %d", i)
+		}
+	}
+}
+
+func TestListTransactionsPagination(t *testing.T) {
+	server, client := newTestServer(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 1.5s apart so page boundaries fall within a second, which the wallet's range can't express
+	addTransactions(server, 250, func(i int) time.Time { return base.Add(time.Duration(i) * 1500 * time.Millisecond) })
+
+	transactions, err := client.ListTransactions(context.Background(), test_wallet, wallet.TransactionQuery{PageSize: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTransactions(t, transactions, 250)
+
+	// the range is inclusive, 100 to 199 are between 150s and 298.5s
+	transactions, err = client.ListTransactions(context.Background(), test_wallet, wallet.TransactionQuery{
+		Start:    base.Add(150 * time.Second),
+		End:      base.Add(298500 * time.Millisecond),
+		PageSize: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTransactions(t, transactions, 100)
+}
+
+func TestListTransactionsSharedTimestamp(t *testing.T) {
+	server, client := newTestServer(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 150 in one block, more than three pages, then 20 older ones
+	addTransactions(server, 170, func(i int) time.Time {
+		if i < 20 {
+			return base.Add(time.Duration(i) * time.Second)
+		}
+		return base.Add(time.Hour)
+	})
+
+	transactions, err := client.ListTransactions(context.Background(), test_wallet, wallet.TransactionQuery{PageSize: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTransactions(t, transactions, 170)
+}
+
+func TestListTransactionsIgnoredMaxCount(t *testing.T) {
+	// a wallet that returns the whole range whatever max_count is
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	all := []wallet.Transaction{}
+	for i := 49; i >= 0; i-- {
+		all = append(all, wallet.Transaction{ID: fmt.Sprintf("%064x", i), InsertedAt: &wallet.BlockRef{Time: base.Add(time.Duration(i) * time.Second)}})
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		end, _ := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+		page := []wallet.Transaction{}
+		for _, tx := range all {
+			if end.IsZero() || !tx.Time().After(end) {
+				page = append(page, tx)
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	transactions, err := wallet.NewClient(server.URL, nil).ListTransactions(context.Background(), test_wallet, wallet.TransactionQuery{PageSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTransactions(t, transactions, 50)
+	if requests > 10 {
+		t.Errorf("expected a few requests, got %d", requests)
+	}
+}
@@ -0,0 +1,241 @@
+package wallet
+
+import (
+	"time"
+)
+
+//
+// models for the cardano-wallet v2 api, fields the curator doesn't use are left out
+//
+
+type Quantity struct {
+	Quantity int64  `json:"quantity"`
+	Unit     string `json:"unit"` // lovelace, percent, block, slot, ...
+}
+
+func Lovelace(quantity int64) Quantity {
+	return Quantity{Quantity: quantity, Unit: "lovelace"}
+}
+
+type Balance struct {
+	Available Quantity `json:"available"`
+	Total     Quantity `json:"total"`
+	Reward    Quantity `json:"reward"`
+}
+
+type WalletState struct {
+	Status   string    `json:"status"` // ready, syncing or not_responding
+	Progress *Quantity `json:"progress,omitempty"`
+}
+
+type Wallet struct {
+	ID             string      `json:"id"`
+	Name           string      `json:"name"`
+	AddressPoolGap int         `json:"address_pool_gap"`
+	Balance        Balance     `json:"balance"`
+	State          WalletState `json:"state"`
+	Tip            *BlockRef   `json:"tip,omitempty"`
+}
+
+type Address struct {
+	ID             string   `json:"id"`
+	State          string   `json:"state"` // used or unused
+	DerivationPath []string `json:"derivation_path,omitempty"`
+}
+
+//
+// transactions
+//
+
+const (
+	TxPending   = "pending"
+	TxSubmitted = "submitted"
+	TxInLedger  = "in_ledger"
+	TxExpired   = "expired"
+
+	TxIncoming = "incoming"
+	TxOutgoing = "outgoing"
+)
+
+type BlockRef struct {
+	Time        time.Time `json:"time"`
+	SlotNumber  int64     `json:"absolute_slot_number"`
+	EpochNumber int64     `json:"epoch_number"`
+	Height      Quantity  `json:"height"`
+}
+
+type TxOutput struct {
+	Address string   `json:"address"`
+	Amount  Quantity `json:"amount"`
+}
+
+type TxInput struct {
+	ID      string    `json:"id"`
+	Index   int       `json:"index"`
+	Address string    `json:"address,omitempty"`
+	Amount  *Quantity `json:"amount,omitempty"`
+}
+
+type Transaction struct {
+	ID           string     `json:"id"`
+	Amount       Quantity   `json:"amount"`
+	Fee          Quantity   `json:"fee"`
+	Direction    string     `json:"direction"`
+	Status       string     `json:"status"`
+	Depth        *Quantity  `json:"depth,omitempty"`
+	InsertedAt   *BlockRef  `json:"inserted_at,omitempty"`   // set once the tx is in the ledger
+	PendingSince *BlockRef  `json:"pending_since,omitempty"` // set while the tx is pending
+	ExpiresAt    *BlockRef  `json:"expires_at,omitempty"`
+	Inputs       []TxInput  `json:"inputs"`
+	Outputs      []TxOutput `json:"outputs"`
+	Metadata     Metadata   `json:"metadata,omitempty"`
+}
+
+func (tx *Transaction) Time() time.Time {
+	/* when the tx was added to the ledger, or submitted if it is still pending */
+	if tx.InsertedAt != nil {
+		return tx.InsertedAt.Time
+	}
+	if tx.PendingSince != nil {
+		return tx.PendingSince.Time
+	}
+	return time.Time{}
+}
+
+type Payment struct {
+	Address string   `json:"address"`
+	Amount  Quantity `json:"amount"`
+}
+
+type TransactionRequest struct {
+	Passphrase string    `json:"passphrase"`
+	Payments   []Payment `json:"payments"`
+	Metadata   Metadata  `json:"metadata,omitempty"`
+}
+
+// body for payment-fees and transactions-construct, which don't need the passphrase
+type PaymentRequest struct {
+	Payments []Payment `json:"payments"`
+	Metadata Metadata  `json:"metadata,omitempty"`
+}
+
+type PaymentFees struct {
+	EstimatedMin Quantity   `json:"estimated_min"`
+	EstimatedMax Quantity   `json:"estimated_max"`
+	MinimumCoins []Quantity `json:"minimum_coins"` // min-UTxO of each payment, in the order of the request
+	Deposit      Quantity   `json:"deposit"`
+}
+
+type CoinSelection struct {
+	Inputs           []TxInput  `json:"inputs"`
+	Outputs          []TxOutput `json:"outputs"`
+	Change           []TxOutput `json:"change"`
+	DepositsTaken    []Quantity `json:"deposits_taken,omitempty"`
+	DepositsReturned []Quantity `json:"deposits_returned,omitempty"`
+}
+
+// a balanced transaction that hasn't been signed or submitted
+type ConstructedTransaction struct {
+	Transaction   string        `json:"transaction"` // serialized tx as returned by the wallet
+	CoinSelection CoinSelection `json:"coin_selection"`
+	Fee           Quantity      `json:"fee"`
+}
+
+// counts of the witnesses of a decoded transaction, a tx that hasn't been signed has none
+type WitnessCount struct {
+	VerificationKey int `json:"verification_key"`
+	Bootstrap       int `json:"bootstrap"`
+}
+
+type DecodedTransaction struct {
+	ID           string       `json:"id"`
+	Fee          Quantity     `json:"fee"`
+	Inputs       []TxInput    `json:"inputs"`
+	Outputs      []TxOutput   `json:"outputs"`
+	Metadata     Metadata     `json:"metadata,omitempty"`
+	WitnessCount WitnessCount `json:"witness_count"`
+}
+
+//
+// tx metadata in the wallet's detailed json schema, ie. {"451": {"map": [{"k": {"string": "name"}, "v": {"string": "..."}}]}}
+//
+
+type Metadata map[string]MetadataValue
+
+type MetadataValue struct {
+	String *string         `json:"string,omitempty"`
+	Int    *int64          `json:"int,omitempty"`
+	Bytes  *string         `json:"bytes,omitempty"` // hex
+	List   []MetadataValue `json:"list,omitempty"`
+	Map    []MetadataPair  `json:"map,omitempty"`
+}
+
+type MetadataPair struct {
+	Key   MetadataValue `json:"k"`
+	Value MetadataValue `json:"v"`
+}
+
+func MetadataString(value string) MetadataValue {
+	return MetadataValue{String: &value}
+}
+
+func MetadataInt(value int64) MetadataValue {
+	return MetadataValue{Int: &value}
+}
+
+func MetadataMap(pairs ...MetadataPair) MetadataValue {
+	if pairs == nil {
+		pairs = []MetadataPair{}
+	}
+	return MetadataValue{Map: pairs}
+}
+
+func (value MetadataValue) Text() string {
+	/* the string value or "" if it isn't a string */
+	if value.String == nil {
+		return ""
+	}
+	return *value.String
+}
+
+func (value MetadataValue) Get(key string) (MetadataValue, bool) {
+	/* the value for a string key of a map */
+	for _, pair := range value.Map {
+		if pair.Key.String != nil && *pair.Key.String == key {
+			return pair.Value, true
+		}
+	}
+	return MetadataValue{}, false
+}
+
+//
+// network
+//
+
+type SyncProgress struct {
+	Status   string    `json:"status"` // ready, syncing or not_responding
+	Progress *Quantity `json:"progress,omitempty"`
+}
+
+type NetworkInformation struct {
+	SyncProgress SyncProgress `json:"sync_progress"`
+	NodeTip      *BlockRef    `json:"node_tip,omitempty"`
+	NetworkTip   *BlockRef    `json:"network_tip,omitempty"`
+	NodeEra      string       `json:"node_era,omitempty"`
+}
+
+//
+// errors
+//
+
+// the error body returned for a failed request, ie. no_such_wallet or wrong_encryption_passphrase
+type Error struct {
+	Status     string `json:"-"` // http status returned by the wallet
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (err *Error) Error() string {
+	return err.Status + " - " + err.Code + " - " + err.Message
+}
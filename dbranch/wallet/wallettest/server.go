@@ -0,0 +1,565 @@
+// Package wallettest runs an in memory cardano-wallet api for tests, see wallet.Client.
+package wallettest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/b-rad-c/dbranch-backend/dbranch/wallet"
+)
+
+//
+// fake wallet server
+//
+
+type Server struct {
+	*httptest.Server
+
+	lock         sync.Mutex
+	wallets      map[string]*fakeWallet
+	wallet_order []string
+	network      wallet.NetworkInformation
+
+	// time given to transactions created through the api, defaults to time.Now
+	Now func() time.Time
+
+	// lovelace each payment must be at least, like the ledger's min-UTxO
+	MinUTxO int64
+}
+
+type fakeWallet struct {
+	wallet       wallet.Wallet
+	passphrase   string
+	addresses    []wallet.Address
+	transactions []wallet.Transaction
+
+	// submitted through the proxy, the real wallet only lists these once they are in the ledger
+	proxied []wallet.Transaction
+}
+
+// the fake's serialized tx, json rather than cbor, clients treat it as opaque bytes
+type fakeTx struct {
+	ID        string           `json:"id"`
+	WalletID  string           `json:"wallet_id"`
+	Fee       int64            `json:"fee"`
+	Payments  []wallet.Payment `json:"payments"`
+	Metadata  wallet.Metadata  `json:"metadata,omitempty"`
+	Witnesses int              `json:"witnesses"`
+}
+
+func decodeFakeTx(transaction []byte) (*fakeTx, error) {
+	tx := &fakeTx{}
+	err := json.Unmarshal(transaction, tx)
+	if err == nil && tx.ID == "" {
+		err = errors.New("missing id")
+	}
+	return tx, err
+}
+
+func NewServer() *Server {
+	/* start a fake wallet that is synced and has no wallets, Close it when done */
+	server := &Server{
+		wallets: map[string]*fakeWallet{},
+		network: wallet.NetworkInformation{SyncProgress: wallet.SyncProgress{Status: "ready"}, NodeEra: "conway"},
+		Now:     time.Now,
+		MinUTxO: 1000000,
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+func (server *Server) WalletClient() *wallet.Client {
+	/* a wallet client for this server */
+	return wallet.NewClient(server.URL, server.Server.Client())
+}
+
+func (server *Server) AddWallet(w wallet.Wallet, passphrase string, addresses ...wallet.Address) {
+	/*
+		add or replace a wallet, addresses without a state are unused
+		payments are only checked against the available balance when it is set
+	*/
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if w.State.Status == "" {
+		w.State.Status = "ready"
+	}
+	for i := range addresses {
+		if addresses[i].State == "" {
+			addresses[i].State = "unused"
+		}
+	}
+
+	if _, exists := server.wallets[w.ID]; !exists {
+		server.wallet_order = append(server.wallet_order, w.ID)
+	}
+	server.wallets[w.ID] = &fakeWallet{wallet: w, passphrase: passphrase, addresses: addresses}
+}
+
+func (server *Server) RemoveWallet(wallet_id string) {
+	/* remove a wallet, like deleting it from cardano-wallet */
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	delete(server.wallets, wallet_id)
+	order := []string{}
+	for _, id := range server.wallet_order {
+		if id != wallet_id {
+			order = append(order, id)
+		}
+	}
+	server.wallet_order = order
+}
+
+func (server *Server) AddTransaction(wallet_id string, tx wallet.Transaction) {
+	/* add a transaction to a wallet that was added with AddWallet */
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	w := server.wallets[wallet_id]
+	if w == nil {
+		panic("wallettest: no wallet " + wallet_id)
+	}
+	w.transactions = append(w.transactions, tx)
+}
+
+func (server *Server) Transactions(wallet_id string) []wallet.Transaction {
+	/* the transactions of a wallet, including the ones created through the api */
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	w := server.wallets[wallet_id]
+	if w == nil {
+		return nil
+	}
+	return append([]wallet.Transaction{}, w.transactions...)
+}
+
+func (server *Server) SetTransactionStatus(wallet_id, tx_id, status string) {
+	/* ie. move a pending transaction in_ledger, inserted_at is set to the current time */
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	w := server.wallets[wallet_id]
+	if w == nil {
+		return
+	}
+
+	// txs submitted through the proxy show up once the wallet sees them in the ledger
+	proxied := []wallet.Transaction{}
+	for _, tx := range w.proxied {
+		if tx.ID == tx_id && status == wallet.TxInLedger {
+			w.transactions = append(w.transactions, tx)
+		} else {
+			proxied = append(proxied, tx)
+		}
+	}
+	w.proxied = proxied
+
+	for i := range w.transactions {
+		tx := &w.transactions[i]
+		if tx.ID != tx_id {
+			continue
+		}
+		tx.Status = status
+		if status == wallet.TxInLedger && tx.InsertedAt == nil {
+			tx.InsertedAt = &wallet.BlockRef{Time: server.Now().UTC()}
+			tx.PendingSince = nil
+		}
+	}
+}
+
+func SignTransaction(transaction string) string {
+	/*
+		add a witness to a tx from transactions-construct, like signing it with cardano-cli
+		transaction is base64 or hex, the signed tx is returned as hex like the cborHex of a cardano-cli envelope
+	*/
+	data, err := wallet.TransactionBytes(transaction)
+	if err != nil {
+		panic("wallettest: " + err.Error())
+	}
+	tx, err := decodeFakeTx(data)
+	if err != nil {
+		panic("wallettest: not a transaction from the fake wallet: " + err.Error())
+	}
+	tx.Witnesses++
+	data, _ = json.Marshal(tx)
+	return hex.EncodeToString(data)
+}
+
+func (server *Server) SetSyncStatus(status string) {
+	/* "ready", "syncing" or "not_responding" */
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.network.SyncProgress.Status = status
+}
+
+//
+// handlers
+//
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/network/information":
+		writeJSON(w, http.StatusOK, server.network)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/proxy/transactions":
+		server.submitTransaction(w, r)
+
+	case len(parts) < 2 || parts[0] != "v2" || parts[1] != "wallets":
+		writeError(w, http.StatusNotFound, "not_found", "I couldn't find the requested endpoint.")
+
+	case len(parts) == 2:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET is supported by the fake wallet")
+			return
+		}
+		wallets := []wallet.Wallet{}
+		for _, id := range server.wallet_order {
+			wallets = append(wallets, server.wallets[id].wallet)
+		}
+		writeJSON(w, http.StatusOK, wallets)
+
+	default:
+		fake := server.wallets[parts[2]]
+		if fake == nil {
+			writeError(w, http.StatusNotFound, "no_such_wallet", "I couldn't find a wallet with the given id: "+parts[2])
+			return
+		}
+		server.handleWallet(w, r, fake, parts[3:])
+	}
+}
+
+func (server *Server) handleWallet(w http.ResponseWriter, r *http.Request, fake *fakeWallet, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, fake.wallet)
+
+	case len(parts) == 1 && parts[0] == "addresses" && r.Method == http.MethodGet:
+		state := r.URL.Query().Get("state")
+		addresses := []wallet.Address{}
+		for _, address := range fake.addresses {
+			if state == "" || address.State == state {
+				addresses = append(addresses, address)
+			}
+		}
+		writeJSON(w, http.StatusOK, addresses)
+
+	case len(parts) == 1 && parts[0] == "transactions" && r.Method == http.MethodGet:
+		server.listTransactions(w, r, fake)
+
+	case len(parts) == 1 && parts[0] == "transactions" && r.Method == http.MethodPost:
+		server.createTransaction(w, r, fake)
+
+	case len(parts) == 1 && parts[0] == "payment-fees" && r.Method == http.MethodPost:
+		server.paymentFees(w, r, fake)
+
+	case len(parts) == 1 && parts[0] == "transactions-construct" && r.Method == http.MethodPost:
+		server.constructTransaction(w, r, fake)
+
+	case len(parts) == 1 && parts[0] == "transactions-decode" && r.Method == http.MethodPost:
+		server.decodeTransaction(w, r)
+
+	case len(parts) == 2 && parts[0] == "transactions" && r.Method == http.MethodGet:
+		for _, tx := range fake.transactions {
+			if tx.ID == parts[1] {
+				writeJSON(w, http.StatusOK, tx)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "no_such_transaction", "I couldn't find a transaction with the given id: "+parts[1])
+
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "I couldn't find the requested endpoint.")
+	}
+}
+
+func (server *Server) listTransactions(w http.ResponseWriter, r *http.Request, fake *fakeWallet) {
+	/* supports the start, end, order and max_count query params like the real wallet */
+	query := r.URL.Query()
+
+	var start, end time.Time
+	var err error
+	if value := query.Get("start"); value != "" {
+		start, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid start: "+value)
+			return
+		}
+	}
+	if value := query.Get("end"); value != "" {
+		end, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid end: "+value)
+			return
+		}
+	}
+
+	max_count := 0
+	if value := query.Get("max_count"); value != "" {
+		max_count, err = strconv.Atoi(value)
+		if err != nil || max_count < 1 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid max_count: "+value)
+			return
+		}
+	}
+
+	transactions := []wallet.Transaction{}
+	for _, tx := range fake.transactions {
+		tx_time := tx.Time()
+		if !start.IsZero() && tx_time.Before(start) {
+			continue
+		}
+		if !end.IsZero() && tx_time.After(end) {
+			continue
+		}
+		transactions = append(transactions, tx)
+	}
+
+	ascending := query.Get("order") == "ascending"
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if ascending {
+			return transactions[i].Time().Before(transactions[j].Time())
+		}
+		return transactions[i].Time().After(transactions[j].Time())
+	})
+
+	if max_count > 0 && len(transactions) > max_count {
+		transactions = transactions[:max_count]
+	}
+	writeJSON(w, http.StatusOK, transactions)
+}
+
+// an error response for a request the fake wallet refuses
+type walletError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (server *Server) checkPayments(fake *fakeWallet, request wallet.PaymentRequest) (int64, *walletError) {
+	/* the fee for a transaction with these payments, or the error the wallet would return for them */
+	if len(request.Payments) == 0 {
+		return 0, &walletError{http.StatusBadRequest, "bad_request", "at least one payment is required"}
+	}
+
+	total := int64(0)
+	for _, payment := range request.Payments {
+		if payment.Amount.Quantity < server.MinUTxO {
+			return 0, &walletError{http.StatusForbidden, "utxo_too_small", "Some outputs have ada values that are too small, the minimum is " + strconv.FormatInt(server.MinUTxO, 10) + " lovelace"}
+		}
+		total += payment.Amount.Quantity
+	}
+
+	fee := server.fee(request)
+	available := fake.wallet.Balance.Available.Quantity
+	if available > 0 && total+fee > available {
+		return 0, &walletError{http.StatusForbidden, "not_enough_money", "I can't process this payment as there are not enough funds available in the wallet"}
+	}
+	return fee, nil
+}
+
+func (server *Server) fee(request wallet.PaymentRequest) int64 {
+	// roughly the linear fee of the real network, a base fee plus 44 lovelace per byte
+	metadata, _ := json.Marshal(request.Metadata)
+	return 155381 + 44*int64(300*len(request.Payments)+len(metadata))
+}
+
+func (server *Server) paymentFees(w http.ResponseWriter, r *http.Request, fake *fakeWallet) {
+	var request wallet.PaymentRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid request body: "+err.Error())
+		return
+	}
+
+	// the minimum coins are reported even when a payment is below them, like the real wallet
+	fees := wallet.PaymentFees{Deposit: wallet.Lovelace(0), MinimumCoins: []wallet.Quantity{}}
+	for range request.Payments {
+		fees.MinimumCoins = append(fees.MinimumCoins, wallet.Lovelace(server.MinUTxO))
+	}
+	fee := server.fee(request)
+	fees.EstimatedMin = wallet.Lovelace(fee)
+	fees.EstimatedMax = wallet.Lovelace(fee + fee/10)
+
+	writeJSON(w, http.StatusAccepted, fees)
+}
+
+func (server *Server) constructTransaction(w http.ResponseWriter, r *http.Request, fake *fakeWallet) {
+	var request wallet.PaymentRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid request body: "+err.Error())
+		return
+	}
+
+	fee, wallet_err := server.checkPayments(fake, request)
+	if wallet_err != nil {
+		writeError(w, wallet_err.status, wallet_err.code, wallet_err.message)
+		return
+	}
+
+	tx, _ := json.Marshal(fakeTx{ID: randomID(), WalletID: fake.wallet.ID, Fee: fee, Payments: request.Payments, Metadata: request.Metadata})
+	constructed := wallet.ConstructedTransaction{
+		Transaction: base64.StdEncoding.EncodeToString(tx),
+		Fee:         wallet.Lovelace(fee),
+	}
+
+	total := fee
+	for _, payment := range request.Payments {
+		total += payment.Amount.Quantity
+		constructed.CoinSelection.Outputs = append(constructed.CoinSelection.Outputs, wallet.TxOutput{Address: payment.Address, Amount: payment.Amount})
+	}
+
+	input := fake.wallet.Balance.Available.Quantity
+	if input == 0 {
+		input = total
+	}
+	constructed.CoinSelection.Inputs = []wallet.TxInput{{ID: randomID(), Index: 0, Amount: &wallet.Quantity{Quantity: input, Unit: "lovelace"}}}
+	if change := input - total; change > 0 {
+		constructed.CoinSelection.Change = []wallet.TxOutput{{Address: request.Payments[0].Address, Amount: wallet.Lovelace(change)}}
+	}
+
+	writeJSON(w, http.StatusAccepted, constructed)
+}
+
+func (server *Server) decodeTransaction(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Transaction string `json:"transaction"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid request body: "+err.Error())
+		return
+	}
+
+	data, err := wallet.TransactionBytes(request.Transaction)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	tx, err := decodeFakeTx(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "not a transaction from the fake wallet: "+err.Error())
+		return
+	}
+
+	decoded := wallet.DecodedTransaction{
+		ID:           tx.ID,
+		Fee:          wallet.Lovelace(tx.Fee),
+		Metadata:     tx.Metadata,
+		WitnessCount: wallet.WitnessCount{VerificationKey: tx.Witnesses},
+	}
+	for _, payment := range tx.Payments {
+		decoded.Outputs = append(decoded.Outputs, wallet.TxOutput{Address: payment.Address, Amount: payment.Amount})
+	}
+	writeJSON(w, http.StatusAccepted, decoded)
+}
+
+func (server *Server) submitTransaction(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "error reading request body: "+err.Error())
+		return
+	}
+
+	tx, err := decodeFakeTx(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed_tx_payload", "I couldn't verify that the payload has the correct binary format: "+err.Error())
+		return
+	}
+	if tx.Witnesses == 0 {
+		writeError(w, http.StatusForbidden, "created_invalid_transaction", "the node rejected the transaction: missing vkey witnesses")
+		return
+	}
+
+	if fake := server.wallets[tx.WalletID]; fake != nil {
+		submitted := wallet.Transaction{
+			ID:           tx.ID,
+			Amount:       wallet.Lovelace(tx.Fee),
+			Fee:          wallet.Lovelace(tx.Fee),
+			Direction:    wallet.TxOutgoing,
+			Status:       wallet.TxSubmitted,
+			PendingSince: &wallet.BlockRef{Time: server.Now().UTC()},
+			Metadata:     tx.Metadata,
+		}
+		for _, payment := range tx.Payments {
+			submitted.Amount.Quantity += payment.Amount.Quantity
+			submitted.Outputs = append(submitted.Outputs, wallet.TxOutput{Address: payment.Address, Amount: payment.Amount})
+		}
+		fake.proxied = append(fake.proxied, submitted)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": tx.ID})
+}
+
+func (server *Server) createTransaction(w http.ResponseWriter, r *http.Request, fake *fakeWallet) {
+	var request wallet.TransactionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid request body: "+err.Error())
+		return
+	}
+	if request.Passphrase != fake.passphrase {
+		writeError(w, http.StatusForbidden, "wrong_encryption_passphrase", "The given encryption passphrase doesn't match the one I use to encrypt the root private key of the given wallet: "+fake.wallet.ID)
+		return
+	}
+
+	fee, wallet_err := server.checkPayments(fake, wallet.PaymentRequest{Payments: request.Payments, Metadata: request.Metadata})
+	if wallet_err != nil {
+		writeError(w, wallet_err.status, wallet_err.code, wallet_err.message)
+		return
+	}
+
+	tx := wallet.Transaction{
+		ID:           randomID(),
+		Amount:       wallet.Lovelace(fee),
+		Fee:          wallet.Lovelace(fee),
+		Direction:    wallet.TxOutgoing,
+		Status:       wallet.TxPending,
+		PendingSince: &wallet.BlockRef{Time: server.Now().UTC()},
+		Metadata:     request.Metadata,
+	}
+	for _, payment := range request.Payments {
+		tx.Amount.Quantity += payment.Amount.Quantity
+		tx.Outputs = append(tx.Outputs, wallet.TxOutput{Address: payment.Address, Amount: payment.Amount})
+	}
+
+	fake.transactions = append(fake.transactions, tx)
+	writeJSON(w, http.StatusAccepted, tx)
+}
+
+//
+// helpers
+//
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, wallet.Error{Code: code, Message: message})
+}
+
+func randomID() string {
+	id := make([]byte, 32)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,229 @@
+package dbranch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+//
+// sign in with a cardano wallet, the client signs a challenge with CIP-30 signData (CIP-8 message signing)
+//
+
+type WalletAdmin struct {
+	Name    string   `json:"name"` // recorded as the actor in the audit log, defaults to the address
+	Address string   `json:"address"`
+	Roles   []string `json:"roles"`
+}
+
+type WalletChallenge struct {
+	Address string    `json:"address"`
+	Nonce   string    `json:"nonce"`
+	Message string    `json:"message"` // the exact text the wallet must sign
+	Expires time.Time `json:"expires"`
+}
+
+const max_pending_challenges = 10000
+
+func (node *Node) walletAdminsPath() string {
+	return node.statePath(node.config.WalletAdminsFile, "wallet_admins.json")
+}
+
+func (node *Node) loadWalletAdmins() ([]WalletAdmin, error) {
+	admins := []WalletAdmin{}
+
+	data, err := os.ReadFile(node.walletAdminsPath())
+	if os.IsNotExist(err) {
+		return admins, nil
+	} else if err != nil {
+		return admins, err
+	}
+
+	err = json.Unmarshal(data, &admins)
+	if err != nil {
+		return admins, errors.New("error decoding wallet admins file: " + err.Error())
+	}
+
+	return admins, nil
+}
+
+func (node *Node) walletAdmin(address []byte) (*WalletAdmin, error) {
+	/* returns the configured admin for address or nil, addresses in the config may be bech32 or hex */
+	admins, err := node.loadWalletAdmins()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, admin := range admins {
+		admin_address, err := decodeCardanoAddress(admin.Address)
+		if err != nil {
+			return nil, errors.New("invalid address in wallet admins file: " + admin.Address)
+		}
+		if bytes.Equal(admin_address, address) {
+			return &admin, validRoles(admin.Roles)
+		}
+	}
+
+	return nil, nil
+}
+
+func (node *Node) NewWalletChallenge(address string) (*WalletChallenge, error) {
+	address_bytes, err := decodeCardanoAddress(address)
+	if err != nil {
+		return nil, invalidInput("%s", err)
+	}
+
+	_, err = paymentKeyHash(address_bytes)
+	if err != nil {
+		return nil, invalidInput("%s", err)
+	}
+
+	nonce, err := randomSecret(16)
+	if err != nil {
+		return nil, err
+	}
+
+	bech32_address := encodeCardanoAddress(address_bytes)
+	expires := time.Now().UTC().Add(node.config.ChallengeTTL)
+
+	challenge := &WalletChallenge{
+		Address: bech32_address,
+		Nonce:   nonce,
+		Message: fmt.Sprintf("Sign in to dBranch\n\naddress: %s\nnonce: %s\nexpires: %s", bech32_address, nonce, expires.Format(time.RFC3339)),
+		Expires: expires,
+	}
+
+	node.challenges_lock.Lock()
+	defer node.challenges_lock.Unlock()
+
+	for key, pending := range node.challenges {
+		if time.Now().After(pending.Expires) {
+			delete(node.challenges, key)
+		}
+	}
+
+	if len(node.challenges) >= max_pending_challenges {
+		return nil, fmt.Errorf("%w: too many pending challenges, try again later", ErrTooManyRequests)
+	}
+
+	node.challenges[nonce] = challenge
+	return challenge, nil
+}
+
+func (node *Node) takeWalletChallenge(nonce string) (*WalletChallenge, error) {
+	// challenges can only be used once
+	node.challenges_lock.Lock()
+	defer node.challenges_lock.Unlock()
+
+	challenge, ok := node.challenges[nonce]
+	if !ok {
+		return nil, errors.New("unknown challenge")
+	}
+	delete(node.challenges, nonce)
+
+	if time.Now().After(challenge.Expires) {
+		return nil, errors.New("challenge expired")
+	}
+
+	return challenge, nil
+}
+
+func (node *Node) VerifyWalletSignature(nonce, signature, key string) (string, error) {
+	/*
+		verify a CIP-30 signData response for the challenge with nonce, signature is the hex COSE_Sign1 and key the hex COSE_Key
+		returns the bech32 address that signed in, every failure wraps ErrSignInFailed
+	*/
+	address, err := node.verifyWalletSignature(nonce, signature, key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrSignInFailed, err)
+	}
+	return address, nil
+}
+
+func (node *Node) verifyWalletSignature(nonce, signature, key string) (string, error) {
+	challenge, err := node.takeWalletChallenge(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	signature_bytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", errors.New("signature must be hex")
+	}
+
+	key_bytes, err := hex.DecodeString(key)
+	if err != nil {
+		return "", errors.New("key must be hex")
+	}
+
+	sign1, err := parseCoseSign1(signature_bytes)
+	if err != nil {
+		return "", err
+	}
+
+	public_key, err := parseCoseKey(key_bytes)
+	if err != nil {
+		return "", err
+	}
+
+	err = sign1.verify(public_key)
+	if err != nil {
+		return "", err
+	}
+
+	if string(sign1.Payload) != challenge.Message {
+		return "", errors.New("signed message does not match the challenge")
+	}
+
+	// the wallet puts the signing address in the protected header, it must be the one the challenge was issued for
+	address, _ := decodeCardanoAddress(challenge.Address)
+	signed_address, _ := sign1.Headers["address"].([]byte)
+	if !bytes.Equal(signed_address, address) {
+		return "", errors.New("signed address does not match the challenge")
+	}
+
+	key_hash, err := paymentKeyHash(address)
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.Equal(blake2b224(public_key), key_hash) {
+		return "", errors.New("key does not match the address payment key")
+	}
+
+	return challenge.Address, nil
+}
+
+func (node *Node) WalletSignIn(nonce, signature, key string) (string, *AdminClaims, error) {
+	/* verify the signed challenge and issue a session token, addresses listed in the wallet admins file get their roles */
+	address, err := node.VerifyWalletSignature(nonce, signature, key)
+	if err != nil {
+		node.audit(&AuditActor{Name: "wallet", Source: "api"}, "sign_in", nil, "", err)
+		return "", nil, err
+	}
+
+	address_bytes, _ := decodeCardanoAddress(address)
+	admin, err := node.walletAdmin(address_bytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, roles := address, []string{}
+	if admin != nil {
+		roles = admin.Roles
+		if admin.Name != "" {
+			name = admin.Name
+		}
+	}
+
+	claims := newAdminClaims(name, roles, node.config.SessionTTL)
+	claims.Address = address
+
+	token, err := node.signAdminClaims(claims)
+	node.audit(&AuditActor{Name: name, Source: "api"}, "sign_in", nil, address, err)
+	return token, claims, err
+}
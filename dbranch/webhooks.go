@@ -0,0 +1,522 @@
+package dbranch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//
+// outgoing webhooks for article events, deliveries are queued on disk and retried with backoff
+//
+
+const EventWebhookTest = "test"
+
+type Webhook struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret"`             // payloads are signed with hmac-sha256 of this secret
+	Events   []string `json:"events,omitempty"`   // event types to deliver, all if empty
+	Sections []string `json:"sections,omitempty"` // only article events in these sections
+	Authors  []string `json:"authors,omitempty"`  // only article events by these authors
+}
+
+type WebhookPayload struct {
+	Delivery string `json:"delivery"`
+	Webhook  string `json:"webhook"`
+	Event    *Event `json:"event"`
+}
+
+type WebhookDelivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Event       *Event    `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Created     time.Time `json:"created"`
+}
+
+type WebhookAttempt struct {
+	Time       time.Time     `json:"time"`
+	Delivery   string        `json:"delivery"`
+	Webhook    string        `json:"webhook"`
+	EventID    uint64        `json:"event_id"`
+	EventType  string        `json:"event_type"`
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Result     string        `json:"result"` // delivered, retrying or failed
+}
+
+// deliveries are dropped after this many failed attempts
+const MaxWebhookAttempts = 8
+
+// deliveries being sent are leased so another process doesn't send them at the same time
+const webhook_lease = 2 * time.Minute
+
+func (node *Node) webhooksPath() string {
+	return node.statePath(node.config.WebhooksFile, "webhooks.json")
+}
+
+func (node *Node) webhookQueuePath() string {
+	return path.Join(node.config.Dir, "webhook_queue.json")
+}
+
+func (node *Node) webhookHistoryPath() string {
+	return path.Join(node.config.Dir, "webhook_deliveries.jsonl")
+}
+
+func (node *Node) ListWebhooks() ([]Webhook, error) {
+	webhooks := []Webhook{}
+
+	data, err := os.ReadFile(node.webhooksPath())
+	if os.IsNotExist(err) {
+		return webhooks, nil
+	} else if err != nil {
+		return webhooks, err
+	}
+
+	err = json.Unmarshal(data, &webhooks)
+	if err != nil {
+		return webhooks, errors.New("error decoding webhooks file: " + err.Error())
+	}
+
+	return webhooks, nil
+}
+
+func (node *Node) findWebhook(name string) (*Webhook, error) {
+	webhooks, err := node.ListWebhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, webhook := range webhooks {
+		if webhook.Name == name {
+			return &webhook, nil
+		}
+	}
+
+	return nil, fmt.Errorf("webhook %w: %s", ErrNotFound, name)
+}
+
+func (webhook *Webhook) filter() *EventFilter {
+	return &EventFilter{Types: webhook.Events, Sections: webhook.Sections, Authors: webhook.Authors}
+}
+
+//
+// signing
+//
+
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	// the timestamp is signed with the body so old payloads can't be replayed
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyWebhookSignature(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) error {
+	/* check the X-Dbranch-Signature header of a received webhook, receivers should use this or an equivalent */
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid webhook timestamp: " + timestamp)
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return errors.New("webhook timestamp outside tolerance")
+	}
+
+	if !hmac.Equal([]byte(SignWebhookPayload(secret, timestamp, body)), []byte(signature)) {
+		return errors.New("invalid webhook signature")
+	}
+
+	return nil
+}
+
+//
+// queue
+//
+
+func (node *Node) withWebhookQueue(update func(queue []*WebhookDelivery) ([]*WebhookDelivery, error)) error {
+	node.webhook_queue_lock.Lock()
+	defer node.webhook_queue_lock.Unlock()
+
+	file, err := os.OpenFile(node.webhookQueuePath(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	queue := []*WebhookDelivery{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		err = json.Unmarshal(data, &queue)
+		if err != nil {
+			return errors.New("error decoding webhook queue: " + err.Error())
+		}
+	}
+
+	queue, err = update(queue)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(queue, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt(data, 0)
+	return err
+}
+
+func (node *Node) enqueueWebhooks(event *Event) {
+	webhooks, err := node.ListWebhooks()
+	if err != nil {
+		log.Printf("could not load webhooks: %s\n", err)
+		return
+	}
+
+	deliveries := []*WebhookDelivery{}
+	for _, webhook := range webhooks {
+		if !webhook.filter().match(event) {
+			continue
+		}
+
+		id, err := randomSecret(8)
+		if err != nil {
+			log.Printf("could not queue webhook: %s: %s\n", webhook.Name, err)
+			continue
+		}
+
+		now := time.Now().UTC()
+		deliveries = append(deliveries, &WebhookDelivery{ID: id, Webhook: webhook.Name, Event: event, NextAttempt: now, Created: now})
+	}
+
+	if len(deliveries) == 0 {
+		return
+	}
+
+	err = node.withWebhookQueue(func(queue []*WebhookDelivery) ([]*WebhookDelivery, error) {
+		return append(queue, deliveries...), nil
+	})
+	if err != nil {
+		log.Printf("could not queue webhooks for event: %d: %s\n", event.ID, err)
+		return
+	}
+
+	// the worker of a daemon or server in this process delivers right away, otherwise the daemon sends them
+	select {
+	case node.webhook_wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (node *Node) ListWebhookQueue() ([]*WebhookDelivery, error) {
+	var pending []*WebhookDelivery
+	err := node.withWebhookQueue(func(queue []*WebhookDelivery) ([]*WebhookDelivery, error) {
+		pending = queue
+		return queue, nil
+	})
+	return pending, err
+}
+
+//
+// delivery
+//
+
+// how often the worker checks for deliveries due to be retried
+const webhook_retry_interval = 30 * time.Second
+
+func (node *Node) runWebhookWorker(ctx context.Context) error {
+	/* deliver webhooks as they are queued and retry failed ones, until ctx is done */
+	for {
+		err := node.DeliverWebhooks(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("could not deliver webhooks: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-node.webhook_wakeup:
+		case <-time.After(webhook_retry_interval):
+		}
+	}
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	// 30s, 1m, 2m, 4m ... capped at an hour
+	backoff := 30 * time.Second << (attempts - 1)
+	if backoff > time.Hour || backoff <= 0 {
+		backoff = time.Hour
+	}
+	return backoff
+}
+
+func (node *Node) sendWebhook(ctx context.Context, webhook *Webhook, delivery *WebhookDelivery) *WebhookAttempt {
+	attempt := &WebhookAttempt{
+		Time:      time.Now().UTC(),
+		Delivery:  delivery.ID,
+		Webhook:   webhook.Name,
+		EventID:   delivery.Event.ID,
+		EventType: delivery.Event.Type,
+		Attempt:   delivery.Attempts + 1,
+	}
+
+	body, err := json.Marshal(&WebhookPayload{Delivery: delivery.ID, Webhook: webhook.Name, Event: delivery.Event})
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dbranch-curator")
+	req.Header.Set("X-Dbranch-Event", delivery.Event.Type)
+	req.Header.Set("X-Dbranch-Delivery", delivery.ID)
+	req.Header.Set("X-Dbranch-Timestamp", timestamp)
+	req.Header.Set("X-Dbranch-Signature", SignWebhookPayload(webhook.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := node.webhook_client.Do(req)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		attempt.Error = strings.TrimSpace(resp.Status + ": " + string(message))
+	}
+
+	return attempt
+}
+
+func (node *Node) recordWebhookAttempt(attempt *WebhookAttempt) {
+	file, err := os.OpenFile(node.webhookHistoryPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("can't open webhook history: %s\n", err)
+		return
+	}
+	defer file.Close()
+
+	err = json.NewEncoder(file).Encode(attempt)
+	if err != nil {
+		log.Printf("error writing to webhook history: %s\n", err)
+	}
+}
+
+func (node *Node) DeliverWebhooks(ctx context.Context) error {
+	/* send every queued delivery that is due, failures are retried with backoff until MaxWebhookAttempts */
+	now := time.Now().UTC()
+	due := []*WebhookDelivery{}
+
+	// lease due deliveries so they aren't sent twice while the request is in flight
+	err := node.withWebhookQueue(func(queue []*WebhookDelivery) ([]*WebhookDelivery, error) {
+		for _, delivery := range queue {
+			if !delivery.NextAttempt.After(now) {
+				delivery.NextAttempt = now.Add(webhook_lease)
+				due = append(due, delivery)
+			}
+		}
+		return queue, nil
+	})
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	attempts := map[string]*WebhookAttempt{}
+	for _, delivery := range due {
+		webhook, err := node.findWebhook(delivery.Webhook)
+		if err != nil {
+			// the webhook was removed from the config, drop its deliveries
+			attempts[delivery.ID] = &WebhookAttempt{Time: now, Delivery: delivery.ID, Webhook: delivery.Webhook, EventID: delivery.Event.ID, EventType: delivery.Event.Type, Attempt: delivery.Attempts + 1, Error: err.Error(), Result: "failed"}
+			continue
+		}
+
+		attempt := node.sendWebhook(ctx, webhook, delivery)
+		if ctx.Err() != nil {
+			// interrupted deliveries aren't counted as attempts, they are sent again once their lease expires
+			break
+		}
+
+		switch {
+		case attempt.Error == "":
+			attempt.Result = "delivered"
+		case attempt.Attempt >= MaxWebhookAttempts:
+			attempt.Result = "failed"
+			log.Printf("giving up on webhook: %s: delivery: %s: %s\n", webhook.Name, delivery.ID, attempt.Error)
+		default:
+			attempt.Result = "retrying"
+		}
+		attempts[delivery.ID] = attempt
+	}
+
+	for _, attempt := range attempts {
+		node.recordWebhookAttempt(attempt)
+	}
+
+	return node.withWebhookQueue(func(queue []*WebhookDelivery) ([]*WebhookDelivery, error) {
+		remaining := []*WebhookDelivery{}
+		for _, delivery := range queue {
+			attempt, ok := attempts[delivery.ID]
+			if !ok {
+				remaining = append(remaining, delivery)
+				continue
+			}
+
+			if attempt.Result == "retrying" {
+				delivery.Attempts = attempt.Attempt
+				delivery.NextAttempt = time.Now().UTC().Add(webhookBackoff(delivery.Attempts))
+				remaining = append(remaining, delivery)
+			}
+		}
+		return remaining, nil
+	})
+}
+
+func (node *Node) TestWebhook(ctx context.Context, name string) (*WebhookAttempt, error) {
+	/* send a test event to the named webhook right away, the attempt is recorded in the delivery history */
+	webhook, err := node.findWebhook(name)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomSecret(8)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		ID:       0,
+		Time:     time.Now().UTC(),
+		Type:     EventWebhookTest,
+		Section:  "curated",
+		Record:   &ArticleRecord{Name: "webhook-test", CID: "bafkqaaa", DateAdded: time.Now().UTC(), DatePublished: time.Now().UTC()},
+		Metadata: &ArticleMetadata{Type: "news", Title: "Webhook test", Author: "dBranch"},
+	}
+
+	attempt := node.sendWebhook(ctx, webhook, &WebhookDelivery{ID: id, Webhook: name, Event: event, Created: event.Time})
+	attempt.Result = "delivered"
+	if attempt.Error != "" {
+		attempt.Result = "failed"
+	}
+	node.recordWebhookAttempt(attempt)
+
+	if attempt.Error != "" {
+		return attempt, fmt.Errorf("%w: webhook test failed: %s", ErrUpstream, attempt.Error)
+	}
+	return attempt, nil
+}
+
+func (node *Node) ListWebhookDeliveries(webhook string, limit int) ([]*WebhookAttempt, error) {
+	/* most recent attempts last, filtered by webhook name if set */
+	attempts := []*WebhookAttempt{}
+
+	data, err := os.ReadFile(node.webhookHistoryPath())
+	if os.IsNotExist(err) {
+		return attempts, nil
+	} else if err != nil {
+		return attempts, err
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		attempt := &WebhookAttempt{}
+		err = json.Unmarshal(line, attempt)
+		if err != nil {
+			return attempts, errors.New("error decoding webhook history: " + err.Error())
+		}
+
+		if webhook == "" || attempt.Webhook == webhook {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	if limit > 0 && len(attempts) > limit {
+		attempts = attempts[len(attempts)-limit:]
+	}
+
+	return attempts, nil
+}
+
+//
+// local stand-in receiver
+//
+
+func WebhookListener(addr, secret string, out io.Writer) error {
+	/* receive webhooks on addr and print them to out, for testing a webhook config without a real service */
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		verified := "unsigned"
+		if secret != "" {
+			err = VerifyWebhookSignature(secret, r.Header.Get("X-Dbranch-Timestamp"), body, r.Header.Get("X-Dbranch-Signature"), 5*time.Minute)
+			if err != nil {
+				fmt.Fprintf(out, "%s rejected %s: %s\n", time.Now().Format(time.RFC3339), r.Header.Get("X-Dbranch-Delivery"), err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			verified = "verified"
+		}
+
+		indented := &bytes.Buffer{}
+		if json.Indent(indented, body, "", "    ") != nil {
+			indented = bytes.NewBuffer(body)
+		}
+
+		fmt.Fprintf(out, "%s %s %s delivery: %s\n%s\n", time.Now().Format(time.RFC3339), verified, r.Header.Get("X-Dbranch-Event"), r.Header.Get("X-Dbranch-Delivery"), indented)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("listening for webhooks on: %s\n", addr)
+	return http.ListenAndServe(addr, handler)
+}
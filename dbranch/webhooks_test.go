@@ -0,0 +1,314 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const test_webhook_secret = "webhook-secret"
+
+func newWebhookNode(t *testing.T, handler http.HandlerFunc) (*Node, *httptest.Server) {
+	/* a node with one webhook for all events sent to handler */
+	t.Helper()
+	receiver := httptest.NewServer(handler)
+	t.Cleanup(receiver.Close)
+
+	node := newTestNode(t, nil)
+	data, _ := json.Marshal([]Webhook{{Name: "receiver", URL: receiver.URL, Secret: test_webhook_secret}})
+	err := os.WriteFile(node.webhooksPath(), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node, receiver
+}
+
+func queueTestDelivery(t *testing.T, node *Node, delivery *WebhookDelivery) {
+	/* queued directly, enqueueWebhooks also starts a delivery in the background */
+	t.Helper()
+	if delivery.Event == nil {
+		delivery.Event = &Event{ID: 1, Time: time.Now().UTC(), Type: EventArticleAdded, Section: "curated"}
+	}
+	err := node.withWebhookQueue(func(queue []*WebhookDelivery) ([]*WebhookDelivery, error) {
+		return append(queue, delivery), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func webhookQueue(t *testing.T, node *Node) []*WebhookDelivery {
+	t.Helper()
+	queue, err := node.ListWebhookQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+func lastWebhookAttempt(t *testing.T, node *Node) *WebhookAttempt {
+	t.Helper()
+	attempts, err := node.ListWebhookDeliveries("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 {
+		t.Fatalf("expected an attempt in the history, got %d", len(attempts))
+	}
+	return attempts[0]
+}
+
+func TestWebhookSignature(t *testing.T) {
+	received := make(chan *WebhookPayload, 1)
+	node, _ := newWebhookNode(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := VerifyWebhookSignature(test_webhook_secret, r.Header.Get("X-Dbranch-Timestamp"), body, r.Header.Get("X-Dbranch-Signature"), time.Minute)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		payload := &WebhookPayload{}
+		json.Unmarshal(body, payload)
+		received <- payload
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	queueTestDelivery(t, node, &WebhookDelivery{ID: "d1", Webhook: "receiver", NextAttempt: time.Now().UTC()})
+	err := node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload.Delivery != "d1" || payload.Webhook != "receiver" || payload.Event.Type != EventArticleAdded {
+			t.Errorf("unexpected payload: %+v", payload)
+		}
+	default:
+		t.Fatal("webhook was not received")
+	}
+
+	if attempt := lastWebhookAttempt(t, node); attempt.Result != "delivered" || attempt.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected attempt: %+v", attempt)
+	}
+	if queue := webhookQueue(t, node); len(queue) != 0 {
+		t.Errorf("delivered webhook left in the queue: %d", len(queue))
+	}
+
+	// receivers reject other secrets, changed bodies and old timestamps
+	body := []byte(`{"delivery":"d1"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := SignWebhookPayload(test_webhook_secret, timestamp, body)
+	if VerifyWebhookSignature("other", timestamp, body, signature, time.Minute) == nil {
+		t.Error("signature verified with another secret")
+	}
+	if VerifyWebhookSignature(test_webhook_secret, timestamp, []byte(`{"delivery":"d2"}`), signature, time.Minute) == nil {
+		t.Error("signature verified for another body")
+	}
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if VerifyWebhookSignature(test_webhook_secret, old, body, SignWebhookPayload(test_webhook_secret, old, body), time.Minute) == nil {
+		t.Error("signature verified with an old timestamp")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	backoffs := map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 7: 32 * time.Minute, 8: time.Hour, 100: time.Hour}
+	for attempts, expected := range backoffs {
+		if backoff := webhookBackoff(attempts); backoff != expected {
+			t.Errorf("backoff after %d attempts: expected %s, got %s", attempts, expected, backoff)
+		}
+	}
+
+	var requests int32
+	node, _ := newWebhookNode(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	queueTestDelivery(t, node, &WebhookDelivery{ID: "d1", Webhook: "receiver", NextAttempt: time.Now().UTC()})
+	err := node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := webhookQueue(t, node)
+	if len(queue) != 1 || queue[0].Attempts != 1 {
+		t.Fatalf("expected the delivery to be queued again after one attempt: %+v", queue)
+	}
+	if wait := time.Until(queue[0].NextAttempt); wait < 25*time.Second || wait > 30*time.Second {
+		t.Errorf("expected the next attempt in 30s, got %s", wait)
+	}
+	if attempt := lastWebhookAttempt(t, node); attempt.Result != "retrying" || attempt.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected attempt: %+v", attempt)
+	}
+
+	// not due yet
+	err = node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt32(&requests); count != 1 {
+		t.Errorf("expected 1 request before the backoff, got %d", count)
+	}
+}
+
+func TestWebhookMaxAttempts(t *testing.T) {
+	node, _ := newWebhookNode(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	queueTestDelivery(t, node, &WebhookDelivery{ID: "d1", Webhook: "receiver", Attempts: MaxWebhookAttempts - 1, NextAttempt: time.Now().UTC()})
+	err := node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queue := webhookQueue(t, node); len(queue) != 0 {
+		t.Errorf("expected the delivery to be dropped after %d attempts: %+v", MaxWebhookAttempts, queue)
+	}
+	if attempt := lastWebhookAttempt(t, node); attempt.Result != "failed" || attempt.Attempt != MaxWebhookAttempts {
+		t.Errorf("unexpected attempt: %+v", attempt)
+	}
+
+	// deliveries for a webhook removed from the config are dropped without sending
+	queueTestDelivery(t, node, &WebhookDelivery{ID: "d2", Webhook: "removed", NextAttempt: time.Now().UTC()})
+	err = node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue := webhookQueue(t, node); len(queue) != 0 {
+		t.Errorf("expected the delivery for a removed webhook to be dropped: %+v", queue)
+	}
+	if attempt := lastWebhookAttempt(t, node); attempt.Result != "failed" || attempt.Webhook != "removed" {
+		t.Errorf("unexpected attempt: %+v", attempt)
+	}
+}
+
+func TestWebhookLease(t *testing.T) {
+	var requests int32
+	arrived, release := make(chan bool, 1), make(chan bool)
+	node, _ := newWebhookNode(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		arrived <- true
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// a delivery leased by another process isn't sent until its lease expires
+	queueTestDelivery(t, node, &WebhookDelivery{ID: "d1", Webhook: "receiver", NextAttempt: time.Now().UTC().Add(webhook_lease)})
+	err := node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt32(&requests); count != 0 {
+		t.Fatalf("leased delivery was sent")
+	}
+
+	// while a delivery is in flight it isn't sent again
+	node.withWebhookQueue(func(queue []*WebhookDelivery) ([]*WebhookDelivery, error) {
+		queue[0].NextAttempt = time.Now().UTC()
+		return queue, nil
+	})
+	done := make(chan error)
+	go func() { done <- node.DeliverWebhooks(context.Background()) }()
+	<-arrived
+
+	err = node.DeliverWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt32(&requests); count != 1 {
+		t.Errorf("expected 1 request, got %d", count)
+	}
+	if queue := webhookQueue(t, node); len(queue) != 0 {
+		t.Errorf("delivered webhook left in the queue: %d", len(queue))
+	}
+
+	// an interrupted delivery isn't counted as an attempt and keeps its lease
+	queueTestDelivery(t, node, &WebhookDelivery{ID: "d2", Webhook: "receiver", NextAttempt: time.Now().UTC()})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+	err = node.DeliverWebhooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := webhookQueue(t, node)
+	if len(queue) != 1 || queue[0].Attempts != 0 {
+		t.Fatalf("expected the interrupted delivery to be queued without an attempt: %+v", queue)
+	}
+	if wait := time.Until(queue[0].NextAttempt); wait < webhook_lease-10*time.Second || wait > webhook_lease {
+		t.Errorf("expected the delivery to stay leased, next attempt in %s", wait)
+	}
+}
+
+func TestWebhookWorker(t *testing.T) {
+	var in_flight, max_in_flight, delivered int32
+	node, _ := newWebhookNode(t, func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&in_flight, 1)
+		defer atomic.AddInt32(&in_flight, -1)
+		for {
+			max := atomic.LoadInt32(&max_in_flight)
+			if current <= max || atomic.CompareAndSwapInt32(&max_in_flight, max, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&delivered, 1)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// events published without a worker are only queued
+	node.events.Publish(&Event{Type: EventArticleAdded, Section: "curated"})
+	time.Sleep(20 * time.Millisecond)
+	if count := atomic.LoadInt32(&delivered); count != 0 {
+		t.Fatalf("expected no delivery without a worker, got %d", count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- node.runWebhookWorker(ctx) }()
+
+	// a burst of events is delivered by the one worker
+	for i := 0; i < 20; i++ {
+		node.events.Publish(&Event{Type: EventArticleAdded, Section: "curated"})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&delivered) < 21 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if count := atomic.LoadInt32(&delivered); count != 21 {
+		t.Errorf("expected 21 deliveries, got %d", count)
+	}
+	if max := atomic.LoadInt32(&max_in_flight); max != 1 {
+		t.Errorf("expected deliveries one at a time, got %d at once", max)
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("worker did not stop with its context")
+	}
+}
@@ -0,0 +1,116 @@
+package dbranch
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	ipfs "github.com/ipfs/go-ipfs-api"
+)
+
+//
+// listen for new articles announced on the ipfs pubsub wire channel
+//
+
+// actor recorded in the audit log for articles curated from the wire
+var wire_actor = &AuditActor{Name: "wire", Source: "daemon"}
+
+func (node *Node) runWireListener(ctx context.Context) error {
+	/* curate articles announced on the wire channel as soon as db-sync has their tx, until ctx is done */
+
+	// a subscription stays open until cancelled so it can't share the shell and its request timeout
+	wire_shell := ipfs.NewShellWithClient(node.config.IpfsHost, &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true},
+	})
+
+	subscription, err := wire_shell.PubSubSubscribe(node.config.WireChannel)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		subscription.Cancel()
+	}()
+
+	log.Printf("listening for articles on wire channel: %s\n", node.config.WireChannel)
+
+	for {
+		message, err := subscription.Next()
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		tx_hash := strings.TrimSpace(string(message.Data))
+		err = node.curateFromWire(ctx, tx_hash)
+		if err != nil {
+			log.Printf("did not curate from wire: %s from peer: %s: %s\n", tx_hash, message.From, err)
+			wire_messages.Inc("ignored")
+		} else {
+			wire_messages.Inc("curated")
+		}
+	}
+}
+
+func (node *Node) curateFromWire(ctx context.Context, tx_hash string) error {
+	decoded, err := hex.DecodeString(tx_hash)
+	if err != nil || len(decoded) != 32 {
+		return errors.New("message is not a tx hash")
+	}
+
+	records, err := node.ListCardanoRecords(ctx, TxHashFilter(tx_hash))
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		// the poller curates it once db-sync catches up
+		return errors.New("tx is not in db-sync yet")
+	}
+
+	addrs, err := node.ListCardanoAddresses()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if !containsFold(addrs, record.Address) {
+			return errors.New("address is not followed: " + record.Address)
+		}
+	}
+
+	node.curation_lock.Lock()
+	defer node.curation_lock.Unlock()
+
+	// announcements are repeated and the poller may have reached the tx first
+	pending := []CardanoArticleRecord{}
+	for _, record := range records {
+		added, err := node.cardanoRecordAdded(ctx, &record)
+		if err != nil {
+			return err
+		}
+		if !added {
+			pending = append(pending, record)
+		}
+	}
+	if len(pending) == 0 {
+		return errors.New("tx is already curated")
+	}
+
+	_, err = eachCardanoRecord(pending, func(record *CardanoArticleRecord) (*ArticleRecord, error) {
+		return node.addCardanoRecord(ctx, CuratedDir, record, true, wire_actor)
+	})
+	if err != nil {
+		return err
+	}
+
+	return node.RefreshArticleIndex(ctx, wire_actor)
+}
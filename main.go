@@ -1,26 +1,51 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
 
 	dbranch "github.com/b-rad-c/dbranch-backend/dbranch"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 //
 // cli interface
 //
 
+// the curator node used by every command, created from env vars before a command runs
+var node *dbranch.Node
+
 func main() {
 
 	app := &cli.App{
 		Name:    "dBranch Backend",
 		Usage:   "Curate articles from the dBranch news protocol!",
 		Version: "0.1.0",
+		Before: func(cli *cli.Context) error {
+			config, err := dbranch.ConfigFromEnv()
+			if err != nil {
+				return err
+			}
+			node, err = dbranch.NewNode(config, dbranch.WithAuditActor(dbranch.AuditActor{Name: cliActor(), Source: "cli"}))
+			return err
+		},
+		After: func(cli *cli.Context) error {
+			if node != nil {
+				return node.Close()
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "article",
@@ -35,7 +60,7 @@ func main() {
 							if path == "" {
 								return errors.New("missing article path")
 							}
-							article, err := dbranch.GetArticleByMFSPath(path)
+							article, err := node.GetArticleByMFSPath(cli.Context, path)
 							if err != nil {
 								return err
 							}
@@ -59,7 +84,7 @@ func main() {
 							if article_cid == "" {
 								return errors.New("missing article cid")
 							}
-							article, err := dbranch.GetArticleByCID(article_cid, cli.Bool("load_record"))
+							article, err := node.GetArticleByCID(cli.Context, article_cid, cli.Bool("load_record"))
 							if err != nil {
 								return err
 							}
@@ -68,6 +93,66 @@ func main() {
 							return nil
 						},
 					},
+					{
+						Name:      "render",
+						Usage:     "render an article by cid as html using the renderer for its type",
+						UsageText: "article render [cid]",
+						Action: func(cli *cli.Context) error {
+							article_cid := cli.Args().First()
+							if article_cid == "" {
+								return errors.New("missing article cid")
+							}
+							article, err := node.GetArticleByCID(cli.Context, article_cid, false)
+							if err != nil {
+								return err
+							}
+							rendered, err := dbranch.RenderArticle(article)
+							if err != nil {
+								return err
+							}
+							fmt.Println(rendered)
+							return nil
+						},
+					},
+					{
+						Name:      "text",
+						Usage:     "extract the plain text of an article by cid",
+						UsageText: "article text [cid]",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:    "summary",
+								Aliases: []string{"s"},
+								Usage:   "truncate text to a summary of at most this many characters",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							article_cid := cli.Args().First()
+							if article_cid == "" {
+								return errors.New("missing article cid")
+							}
+							article, err := node.GetArticleByCID(cli.Context, article_cid, false)
+							if err != nil {
+								return err
+							}
+							text, err := dbranch.ArticlePlainText(article)
+							if err != nil {
+								return err
+							}
+							if cli.Int("summary") > 0 {
+								text = dbranch.SummarizeText(text, cli.Int("summary"))
+							}
+							fmt.Println(text)
+							return nil
+						},
+					},
+					{
+						Name:  "types",
+						Usage: "list registered article types",
+						Action: func(cli *cli.Context) error {
+							printJSON(dbranch.ListArticleTypes())
+							return nil
+						},
+					},
 					{
 						Name:  "index",
 						Usage: "refresh or view the article index which lists curated and published (signed w cardano) articles",
@@ -76,7 +161,7 @@ func main() {
 								Name:  "show",
 								Usage: "show the article index",
 								Action: func(cli *cli.Context) error {
-									index, err := dbranch.LoadArticleIndex()
+									index, err := node.LoadArticleIndex(cli.Context)
 									if err != nil {
 										return err
 									}
@@ -89,7 +174,7 @@ func main() {
 								Name:  "refresh",
 								Usage: "refresh the article index",
 								Action: func(cli *cli.Context) error {
-									return dbranch.RefreshArticleIndex()
+									return node.RefreshArticleIndex(cli.Context, nil)
 								},
 							},
 						},
@@ -105,7 +190,7 @@ func main() {
 						Name:  "ping",
 						Usage: "ping postgres db",
 						Action: func(cli *cli.Context) error {
-							err := dbranch.CardanoDBPing()
+							err := node.CardanoDBPing(cli.Context)
 							if err != nil {
 								return err
 							} else {
@@ -118,7 +203,7 @@ func main() {
 						Name:  "overview",
 						Usage: "show db meta, sync and block data",
 						Action: func(cli *cli.Context) error {
-							overview, err := dbranch.CardanoDBOverview()
+							overview, err := node.CardanoDBOverview(cli.Context)
 							if err != nil {
 								return err
 							}
@@ -130,7 +215,7 @@ func main() {
 						Name:  "meta",
 						Usage: "show db metadata",
 						Action: func(cli *cli.Context) error {
-							db_meta, err := dbranch.CardanoDBMeta()
+							db_meta, err := node.CardanoDBMeta(cli.Context)
 							if err != nil {
 								return err
 							}
@@ -142,7 +227,7 @@ func main() {
 						Name:  "sync",
 						Usage: "show db sync status",
 						Action: func(cli *cli.Context) error {
-							db_status, err := dbranch.CardanoDBSyncStatus()
+							db_status, err := node.CardanoDBSyncStatus(cli.Context)
 							if err != nil {
 								return err
 							}
@@ -154,7 +239,7 @@ func main() {
 						Name:  "block",
 						Usage: "show current chain block and last block processed by daemon",
 						Action: func(cli *cli.Context) error {
-							block_status, err := dbranch.CardanoDBBlockStatus()
+							block_status, err := node.CardanoDBBlockStatus(cli.Context)
 							if err != nil {
 								return err
 							}
@@ -204,7 +289,7 @@ func main() {
 							var err error
 							var records []dbranch.CardanoArticleRecord
 
-							records, err = dbranch.ListCardanoRecords(args...)
+							records, err = node.ListCardanoRecords(cli.Context, args...)
 
 							if err != nil {
 								return err
@@ -222,11 +307,11 @@ func main() {
 							if tx_hash == "" {
 								return fmt.Errorf("missing tx_hash")
 							}
-							record, err := dbranch.CurateRecordByCardanoTxHash(tx_hash)
+							records, err := node.CurateRecordsByCardanoTxHash(cli.Context, tx_hash, nil)
 							if err != nil {
 								return err
 							}
-							printJSON(record)
+							printJSON(records)
 							return nil
 						},
 					},
@@ -239,11 +324,11 @@ func main() {
 							if tx_hash == "" {
 								return fmt.Errorf("missing tx_hash")
 							}
-							record, err := dbranch.PublishRecordByCardanoTxHash(tx_hash)
+							records, err := node.PublishRecordsByCardanoTxHash(cli.Context, tx_hash, nil)
 							if err != nil {
 								return err
 							}
-							printJSON(record)
+							printJSON(records)
 							return nil
 						},
 					},
@@ -258,7 +343,7 @@ func main() {
 						Name:  "status",
 						Usage: "show cardano node network status",
 						Action: func(cli *cli.Context) error {
-							status, err := dbranch.Status()
+							status, err := node.Status(cli.Context)
 							if err != nil {
 								return err
 							}
@@ -271,15 +356,14 @@ func main() {
 						Name:  "wait",
 						Usage: "wait for network to become ready",
 						Action: func(cli *cli.Context) error {
-							dbranch.WaitForCardanoWallet()
-							return nil
+							return node.WaitForCardanoWallet(cli.Context)
 						},
 					},
 					{
 						Name:  "list",
 						Usage: "list available wallets by id",
 						Action: func(cli *cli.Context) error {
-							wallets, err := dbranch.WalletIds()
+							wallets, err := node.WalletIds(cli.Context)
 							if err != nil {
 								return err
 							}
@@ -298,7 +382,7 @@ func main() {
 							if wallet_id == "" {
 								return fmt.Errorf("missing wallet id")
 							}
-							addresses, err := dbranch.WalletAddresses(wallet_id)
+							addresses, err := node.WalletAddresses(cli.Context, wallet_id)
 							if err != nil {
 								return err
 							}
@@ -312,15 +396,184 @@ func main() {
 					{
 						Name:      "sign",
 						Usage:     "sign an article by sending a transaction to your own wallet with metadata about the article",
-						UsageText: "sign [wallet_id] [address] [article_path]",
+						UsageText: "sign [--dry-run] [--amount lovelace] [--passphrase-file path | --passphrase-stdin] [wallet_id] [address] [article_path]",
+						Flags:     signFlags(),
 						Action: func(cli *cli.Context) error {
 							args := cli.Args().Slice()
-							record, err := dbranch.SignArticle(args[0], args[1], args[2])
+							if len(args) != 3 {
+								return fmt.Errorf("expected a wallet id, address and article path")
+							}
+
+							request := dbranch.SignRequest{WalletID: args[0], Address: args[1], Path: args[2], Amount: cli.Int64("amount")}
+							estimate, err := node.EstimateSignature(cli.Context, request)
+							if err != nil {
+								return err
+							}
+
+							if cli.Bool("dry-run") {
+								printJSON(estimate)
+								return nil
+							}
+
+							logSignatureEstimate(estimate)
+
+							passphrase, err := signPassphrase(cli, request.WalletID)
+							if err != nil {
+								return err
+							}
+
+							signature, err := node.SignArticle(cli.Context, request, passphrase, nil)
 							if err != nil {
 								return err
 							}
 
-							printJSON(record)
+							return waitForSignatures(cli, []*dbranch.PendingSignature{signature})
+						},
+					},
+					{
+						Name:      "sign-batch",
+						Usage:     "sign several articles with as few transactions as their metadata fits in",
+						UsageText: "sign-batch [--dry-run] [--amount lovelace] [--passphrase-file path | --passphrase-stdin] [wallet_id] [address] [article_path...]",
+						Flags:     signFlags(),
+						Action: func(cli *cli.Context) error {
+							args := cli.Args().Slice()
+							if len(args) < 3 {
+								return fmt.Errorf("expected a wallet id, address and at least one article path")
+							}
+
+							request := dbranch.BatchSignRequest{WalletID: args[0], Address: args[1], Paths: args[2:], Amount: cli.Int64("amount")}
+							estimates, err := node.EstimateBatchSignature(cli.Context, request)
+							if err != nil {
+								return err
+							}
+
+							if cli.Bool("dry-run") {
+								printJSON(estimates)
+								return nil
+							}
+
+							log.Printf("signing %d articles in %d txs\n", len(request.Paths), len(estimates))
+							for _, estimate := range estimates {
+								logSignatureEstimate(estimate)
+							}
+
+							passphrase, err := signPassphrase(cli, request.WalletID)
+							if err != nil {
+								return err
+							}
+
+							signatures, err := node.SignArticles(cli.Context, request, passphrase, nil)
+							if err != nil {
+								if len(signatures) > 0 {
+									log.Printf("%d of %d txs were submitted before the error, they are tracked as pending\n", len(signatures), len(estimates))
+									printJSON(signatures)
+								}
+								return err
+							}
+
+							return waitForSignatures(cli, signatures)
+						},
+					},
+					{
+						Name:      "export",
+						Usage:     "write the unsigned tx signing an article as a cardano-cli envelope, for wallets whose keys aren't in cardano-wallet",
+						UsageText: "export [--amount lovelace] [--out path] [wallet_id] [address] [article_path]",
+						Flags: []cli.Flag{
+							&cli.Int64Flag{
+								Name:  "amount",
+								Usage: "lovelace to send to the address, defaults to DBRANCH_SIGN_AMOUNT or 1000000",
+							},
+							&cli.StringFlag{
+								Name:  "out",
+								Usage: "write the envelope to this file instead of stdout",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							args := cli.Args().Slice()
+							if len(args) != 3 {
+								return fmt.Errorf("expected a wallet id, address and article path")
+							}
+
+							request := dbranch.SignRequest{WalletID: args[0], Address: args[1], Path: args[2], Amount: cli.Int64("amount")}
+							exported, err := node.ExportSignature(cli.Context, request, nil)
+							if err != nil {
+								return err
+							}
+							logSignatureEstimate(exported.Estimate)
+
+							if out := cli.String("out"); out != "" {
+								data, err := json.MarshalIndent(exported.Envelope, "", "    ")
+								if err != nil {
+									return err
+								}
+								err = os.WriteFile(out, data, 0644)
+								if err != nil {
+									return err
+								}
+							} else {
+								printJSON(exported.Envelope)
+							}
+
+							log.Printf("exported tx: %s, sign it with cardano-cli transaction sign --tx-file and return it with: cardano-wallet submit\n", exported.Signature.TxHash)
+							return nil
+						},
+					},
+					{
+						Name:      "submit",
+						Usage:     "submit a tx from export once it was signed with cardano-cli, its article is published like one from sign",
+						UsageText: "submit [--wait duration] [signed_tx_file]",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "wait",
+								Usage: "how long to wait for the tx to be in the ledger and db-sync before leaving it to the daemon, 0 to not wait",
+								Value: 5 * time.Minute,
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							if cli.Args().Len() != 1 {
+								return fmt.Errorf("expected the signed tx file written by cardano-cli transaction sign")
+							}
+
+							data, err := os.ReadFile(cli.Args().First())
+							if err != nil {
+								return err
+							}
+							envelope := &dbranch.TextEnvelope{}
+							err = json.Unmarshal(data, envelope)
+							if err != nil {
+								return errors.New("error decoding tx envelope: " + err.Error())
+							}
+
+							signature, err := node.SubmitSignedSignature(cli.Context, envelope, nil)
+							if err != nil {
+								return err
+							}
+
+							return waitForSignatures(cli, []*dbranch.PendingSignature{signature})
+						},
+					},
+					{
+						Name:  "pending",
+						Usage: "list signed articles waiting to be in the ledger and db-sync before they are published",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "check",
+								Usage: "check each one now and publish the ones db-sync has",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							if cli.Bool("check") {
+								err := node.ProcessPendingSignatures(cli.Context)
+								if err != nil {
+									return err
+								}
+							}
+
+							pending, err := node.ListPendingSignatures()
+							if err != nil {
+								return err
+							}
+							printJSON(pending)
 							return nil
 						},
 					},
@@ -329,7 +582,7 @@ func main() {
 						Usage: `list published articles for a wallet id; list may be incomplete as it will only store articles published singed by this wallet instance
 						use "cardano-db records" or "article index show" to see all articles`,
 						Action: func(cli *cli.Context) error {
-							articles, err := dbranch.ListSignedArticles(cli.Args().First())
+							articles, err := node.ListSignedArticles(cli.Context, cli.Args().First())
 							if err != nil {
 								return err
 							}
@@ -347,7 +600,7 @@ func main() {
 						Name:  "addresses",
 						Usage: "list addresses the curator daemon will pull published articles from",
 						Action: func(cli *cli.Context) error {
-							addrs, err := dbranch.ListCardanoAddresses()
+							addrs, err := node.ListCardanoAddresses()
 							if err != nil {
 								return err
 							}
@@ -355,19 +608,533 @@ func main() {
 							return nil
 						},
 					},
+					{
+						Name:  "rejected",
+						Usage: "list articles that were rejected because they failed validation",
+						Action: func(cli *cli.Context) error {
+							rejections, err := node.ListRejections()
+							if err != nil {
+								return err
+							}
+							printJSON(rejections)
+							return nil
+						},
+					},
+					{
+						Name:  "quarantined",
+						Usage: "list articles held in quarantine because their type is unknown",
+						Action: func(cli *cli.Context) error {
+							names, err := node.ListQuarantinedArticles(cli.Context)
+							if err != nil {
+								return err
+							}
+							printJSON(names)
+							return nil
+						},
+					},
+					{
+						Name:  "pending",
+						Usage: "review articles held in the moderation queue",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "list",
+								Usage: "list articles waiting for review",
+								Action: func(cli *cli.Context) error {
+									items, err := node.ListPendingArticles(cli.Context)
+									if err != nil {
+										return err
+									}
+									printJSON(items)
+									return nil
+								},
+							},
+							{
+								Name:      "add",
+								Usage:     "stage an article for review by cardano tx hash",
+								ArgsUsage: "add [tx_hash]",
+								Action: func(cli *cli.Context) error {
+									tx_hash := cli.Args().First()
+									if tx_hash == "" {
+										return fmt.Errorf("missing tx_hash")
+									}
+									records, err := node.StageRecordsByCardanoTxHash(cli.Context, tx_hash, nil)
+									if err != nil {
+										return err
+									}
+									printJSON(records)
+									return nil
+								},
+							},
+							{
+								Name:      "approve",
+								Usage:     "approve a pending article and add it to the curated list",
+								ArgsUsage: "approve [name]",
+								Action: func(cli *cli.Context) error {
+									name := cli.Args().First()
+									if name == "" {
+										return fmt.Errorf("missing article name")
+									}
+									record, err := node.ApprovePendingArticle(cli.Context, name, nil)
+									if err != nil {
+										return err
+									}
+									printJSON(record)
+									return nil
+								},
+							},
+							{
+								Name:      "reject",
+								Usage:     "reject a pending article",
+								ArgsUsage: "reject [name] [reason]",
+								Action: func(cli *cli.Context) error {
+									name := cli.Args().Get(0)
+									reason := cli.Args().Get(1)
+									if name == "" || reason == "" {
+										return fmt.Errorf("missing article name or reason")
+									}
+									record, err := node.RejectPendingArticle(cli.Context, name, nil, reason)
+									if err != nil {
+										return err
+									}
+									printJSON(record)
+									return nil
+								},
+							},
+							{
+								Name:  "log",
+								Usage: "show moderation decisions from the audit log",
+								Action: func(cli *cli.Context) error {
+									decisions, err := node.ListModerationDecisions()
+									if err != nil {
+										return err
+									}
+									printJSON(decisions)
+									return nil
+								},
+							},
+						},
+					},
+					{
+						Name:  "block",
+						Usage: "manage blocklists of cids, tx hashes and addresses that will never be curated",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "list",
+								Usage: "list blocked cids, tx hashes and addresses",
+								Action: func(cli *cli.Context) error {
+									entries, err := node.ListBlocks()
+									if err != nil {
+										return err
+									}
+									printJSON(entries)
+									return nil
+								},
+							},
+							{
+								Name:      "add",
+								Usage:     "block a cid, tx hash or address",
+								ArgsUsage: "add [cid|tx|address] [value]",
+								Flags: []cli.Flag{
+									&cli.StringFlag{
+										Name:    "reason",
+										Aliases: []string{"r"},
+										Usage:   "why the value is blocked",
+									},
+									&cli.StringFlag{
+										Name:    "expires",
+										Aliases: []string{"e"},
+										Usage:   "when the block expires as a duration from now (ex: 720h) or RFC3339 time",
+									},
+								},
+								Action: func(cli *cli.Context) error {
+									entry := &dbranch.BlockEntry{
+										Kind:   cli.Args().Get(0),
+										Value:  cli.Args().Get(1),
+										Reason: cli.String("reason"),
+										Source: cliActor(),
+									}
+
+									if cli.String("expires") != "" {
+										expires, err := parseExpiry(cli.String("expires"))
+										if err != nil {
+											return err
+										}
+										entry.Expires = &expires
+									}
+
+									err := node.AddBlock(entry, nil)
+									if err != nil {
+										return err
+									}
+									printJSON(entry)
+									return nil
+								},
+							},
+							{
+								Name:      "rm",
+								Usage:     "remove a block",
+								ArgsUsage: "rm [cid|tx|address] [value]",
+								Action: func(cli *cli.Context) error {
+									return node.RemoveBlock(cli.Args().Get(0), cli.Args().Get(1), nil)
+								},
+							},
+							{
+								Name:      "import",
+								Usage:     "import a blocklist shared by another curator from a file or url",
+								ArgsUsage: "import [path|url]",
+								Action: func(cli *cli.Context) error {
+									source := cli.Args().First()
+									if source == "" {
+										return fmt.Errorf("missing blocklist path or url")
+									}
+									count, err := node.ImportBlocklist(cli.Context, source, nil)
+									if err != nil {
+										return err
+									}
+									fmt.Printf("imported %d entries\n", count)
+									return nil
+								},
+							},
+						},
+					},
+					{
+						Name:  "admin",
+						Usage: "manage api keys and tokens for the admin endpoints",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "keys",
+								Usage: "list admin api keys",
+								Action: func(cli *cli.Context) error {
+									keys, err := node.ListAdminKeys()
+									if err != nil {
+										return err
+									}
+									printJSON(keys)
+									return nil
+								},
+							},
+							{
+								Name:      "add-key",
+								Usage:     "generate an api key, it is only shown once",
+								ArgsUsage: "add-key [name]",
+								Flags: []cli.Flag{
+									&cli.StringSliceFlag{
+										Name:    "role",
+										Aliases: []string{"r"},
+										Usage:   "moderator, operator or editor, may be repeated",
+										Value:   cli.NewStringSlice(dbranch.RoleModerator),
+									},
+								},
+								Action: func(cli *cli.Context) error {
+									key, err := node.AddAdminKey(cli.Args().First(), cli.StringSlice("role"))
+									if err != nil {
+										return err
+									}
+									fmt.Println(key)
+									return nil
+								},
+							},
+							{
+								Name:      "rm-key",
+								Usage:     "revoke an api key",
+								ArgsUsage: "rm-key [name]",
+								Action: func(cli *cli.Context) error {
+									return node.RemoveAdminKey(cli.Args().First())
+								},
+							},
+							{
+								Name:      "token",
+								Usage:     "issue a signed jwt",
+								ArgsUsage: "token [name]",
+								Flags: []cli.Flag{
+									&cli.StringSliceFlag{
+										Name:    "role",
+										Aliases: []string{"r"},
+										Usage:   "moderator, operator or editor, may be repeated",
+										Value:   cli.NewStringSlice(dbranch.RoleModerator),
+									},
+									&cli.DurationFlag{
+										Name:  "ttl",
+										Usage: "how long the token is valid",
+										Value: 24 * time.Hour,
+									},
+								},
+								Action: func(cli *cli.Context) error {
+									token, err := node.IssueAdminToken(cli.Args().First(), cli.StringSlice("role"), cli.Duration("ttl"))
+									if err != nil {
+										return err
+									}
+									fmt.Println(token)
+									return nil
+								},
+							},
+						},
+					},
+					{
+						Name:  "policy",
+						Usage: "inspect the curation policy",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "show",
+								Usage: "show the curation policy rules",
+								Action: func(cli *cli.Context) error {
+									policy, err := node.LoadPolicy()
+									if err != nil {
+										return err
+									}
+									printJSON(policy)
+									return nil
+								},
+							},
+							{
+								Name:      "test",
+								Usage:     "explain which policy rule matches the article in a cardano tx and why",
+								ArgsUsage: "test [--entry n] [tx_hash]",
+								Flags: []cli.Flag{
+									&cli.IntFlag{
+										Name:  "entry",
+										Usage: "article to explain for txs signing more than one, starting at 0",
+									},
+								},
+								Action: func(cli *cli.Context) error {
+									tx_hash := cli.Args().First()
+									if tx_hash == "" {
+										return fmt.Errorf("missing tx_hash")
+									}
+									input, decision, err := node.ExplainPolicyForCardanoTxHash(cli.Context, tx_hash, cli.Int("entry"))
+									if err != nil {
+										return err
+									}
+									printJSON(map[string]interface{}{"input": input, "decision": decision})
+									return nil
+								},
+							},
+						},
+					},
+					{
+						Name:  "replicas",
+						Usage: "show the replication state of curated articles on remote pinning services",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "sync",
+								Usage: "refresh pin statuses and retry failed pins before showing",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							if cli.Bool("sync") {
+								err := node.SyncReplicas(cli.Context)
+								if err != nil {
+									return err
+								}
+							}
+							replicas, err := node.ListReplicas()
+							if err != nil {
+								return err
+							}
+							printJSON(replicas)
+							return nil
+						},
+					},
 					{
 						Name:  "daemon",
 						Usage: "run the curator daemon which pulls articles from the cardano blockchain",
 						Action: func(cli *cli.Context) error {
-							dbranch.CuratorDaemon()
-							return nil
+							return node.CuratorDaemon(cli.Context)
 						},
 					},
 					{
 						Name:  "server",
 						Usage: "run curator web server",
 						Action: func(cli *cli.Context) error {
-							return dbranch.CuratorServer()
+							return node.CuratorServer(cli.Context)
+						},
+					},
+					{
+						Name:  "run",
+						Usage: "run the daemon, wire listener and web server in one process until interrupted",
+						Action: func(cli *cli.Context) error {
+							return node.CuratorRun(cli.Context)
+						},
+					},
+				},
+			},
+			{
+				Name:  "webhooks",
+				Usage: "Manage outgoing webhooks for article events",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list configured webhooks",
+						Action: func(cli *cli.Context) error {
+							webhooks, err := node.ListWebhooks()
+							if err != nil {
+								return err
+							}
+							printJSON(webhooks)
+							return nil
+						},
+					},
+					{
+						Name:      "test",
+						Usage:     "send a signed test event to a webhook",
+						ArgsUsage: "test [name]",
+						Action: func(cli *cli.Context) error {
+							name := cli.Args().First()
+							if name == "" {
+								return fmt.Errorf("missing webhook name")
+							}
+							attempt, err := node.TestWebhook(cli.Context, name)
+							if attempt != nil {
+								printJSON(attempt)
+							}
+							return err
+						},
+					},
+					{
+						Name:  "queue",
+						Usage: "list deliveries waiting to be sent or retried",
+						Action: func(cli *cli.Context) error {
+							queue, err := node.ListWebhookQueue()
+							if err != nil {
+								return err
+							}
+							printJSON(queue)
+							return nil
+						},
+					},
+					{
+						Name:  "deliver",
+						Usage: "send queued deliveries that are due now",
+						Action: func(cli *cli.Context) error {
+							return node.DeliverWebhooks(cli.Context)
+						},
+					},
+					{
+						Name:  "history",
+						Usage: "show recent delivery attempts",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "webhook",
+								Aliases: []string{"w"},
+								Usage:   "only show attempts for this webhook",
+							},
+							&cli.IntFlag{
+								Name:    "lines",
+								Aliases: []string{"n"},
+								Value:   20,
+								Usage:   "number of attempts to show",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							attempts, err := node.ListWebhookDeliveries(cli.String("webhook"), cli.Int("lines"))
+							if err != nil {
+								return err
+							}
+							printJSON(attempts)
+							return nil
+						},
+					},
+					{
+						Name:  "listen",
+						Usage: "run a local receiver that prints webhooks, for testing",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "addr",
+								Value: "localhost:8089",
+								Usage: "address to listen on",
+							},
+							&cli.StringFlag{
+								Name:  "secret",
+								Usage: "verify signatures with this secret",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							return dbranch.WebhookListener(cli.String("addr"), cli.String("secret"), os.Stdout)
+						},
+					},
+				},
+			},
+			{
+				Name:  "audit",
+				Usage: "Inspect the audit log of curation actions",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "tail",
+						Usage: "show the most recent audit entries",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:    "lines",
+								Aliases: []string{"n"},
+								Value:   20,
+								Usage:   "number of entries to show",
+							},
+						},
+						Action: func(cli *cli.Context) error {
+							entries, err := node.QueryAuditLog(&dbranch.AuditQuery{Limit: cli.Int("lines")})
+							if err != nil {
+								return err
+							}
+							printJSON(entries)
+							return nil
+						},
+					},
+					{
+						Name:  "query",
+						Usage: "search the audit log",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "action", Usage: "ex: curate, publish, remove, sign, policy, stage, approve, reject, block"},
+							&cli.StringFlag{Name: "actor", Usage: "user, api key name, daemon or server"},
+							&cli.StringFlag{Name: "source", Usage: "cli, api or daemon"},
+							&cli.StringFlag{Name: "name", Usage: "article name"},
+							&cli.StringFlag{Name: "cid", Usage: "article cid"},
+							&cli.StringFlag{Name: "tx", Usage: "cardano tx hash"},
+							&cli.StringFlag{Name: "since", Usage: "only entries after this duration ago (ex: 24h) or RFC3339 time"},
+							&cli.StringFlag{Name: "until", Usage: "only entries before this duration ago (ex: 1h) or RFC3339 time"},
+							&cli.IntFlag{Name: "limit", Usage: "show at most this many of the most recent matches"},
+						},
+						Action: func(cli *cli.Context) error {
+							query := &dbranch.AuditQuery{
+								Action: cli.String("action"),
+								Actor:  cli.String("actor"),
+								Source: cli.String("source"),
+								Name:   cli.String("name"),
+								CID:    cli.String("cid"),
+								TxHash: cli.String("tx"),
+								Limit:  cli.Int("limit"),
+							}
+
+							var err error
+							if cli.String("since") != "" {
+								query.Since, err = parseSince(cli.String("since"))
+								if err != nil {
+									return err
+								}
+							}
+							if cli.String("until") != "" {
+								query.Until, err = parseSince(cli.String("until"))
+								if err != nil {
+									return err
+								}
+							}
+
+							entries, err := node.QueryAuditLog(query)
+							if err != nil {
+								return err
+							}
+							printJSON(entries)
+							return nil
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "check the audit log hash chain for tampering",
+						Action: func(cli *cli.Context) error {
+							count, err := node.VerifyAuditLog()
+							if err != nil {
+								return err
+							}
+							fmt.Printf("verified %d entries\n", count)
+							return nil
 						},
 					},
 				},
@@ -375,11 +1142,187 @@ func main() {
 		},
 	}
 
-	err := app.Run(os.Args)
+	// commands are cancelled on interrupt so in-flight ipfs, db and wallet calls stop right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := app.RunContext(ctx, os.Args)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+
+}
+
+// exit codes so scripts can tell failures apart, any other error exits with 1
+var exit_codes = map[string]int{
+	dbranch.CodeInvalidRequest:     2,
+	dbranch.CodeArticleNotFound:    3,
+	dbranch.CodeRecordNotFound:     3,
+	dbranch.CodeNotFound:           3,
+	dbranch.CodeInvalidArticle:     4,
+	dbranch.CodeUnknownArticleType: 4,
+	dbranch.CodeInvalidLocation:    4,
+	dbranch.CodeBlocked:            5,
+	dbranch.CodeRejectedByPolicy:   5,
+	dbranch.CodeAlreadyExists:      6,
+	dbranch.CodeSignInFailed:       7,
+	dbranch.CodeWallet:             8,
+	dbranch.CodeUpstream:           9,
+	dbranch.CodeCuratorLocked:      10,
+	dbranch.CodeSignatureExpired:   11,
+}
+
+func exitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		// interrupted, same as a shell reports for SIGINT
+		return 130
+	}
+	if code, ok := exit_codes[dbranch.ErrorCode(err)]; ok {
+		return code
+	}
+	return 1
+}
+
+func signFlags() []cli.Flag {
+	// flags shared by sign and sign-batch
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "passphrase-file",
+			Usage: "read the wallet passphrase from this file",
+		},
+		&cli.BoolFlag{
+			Name:  "passphrase-stdin",
+			Usage: "read the wallet passphrase from the first line of stdin",
+		},
+		&cli.Int64Flag{
+			Name:  "amount",
+			Usage: "lovelace to send to the address, defaults to DBRANCH_SIGN_AMOUNT or 1000000",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the fee and the tx the wallet would submit without signing it",
+		},
+		&cli.DurationFlag{
+			Name:  "wait",
+			Usage: "how long to wait for the tx to be in the ledger and db-sync before leaving it to the daemon, 0 to not wait",
+			Value: 5 * time.Minute,
+		},
+	}
+}
+
+func logSignatureEstimate(estimate *dbranch.SignatureEstimate) {
+	for _, article := range estimate.Articles {
+		log.Printf("signing article: %s at %s\n", article.Name, article.Location)
+	}
+	log.Printf("sending %d lovelace to %s, fee: %d lovelace, deposit: %d lovelace, min-UTxO: %d lovelace\n",
+		estimate.Amount, estimate.Address, estimate.Fee, estimate.Deposit, estimate.MinUTxO)
+}
+
+func waitForSignatures(cli *cli.Context, signatures []*dbranch.PendingSignature) error {
+	/* wait up to --wait for submitted txs to be published, the ones still pending are left to the daemon */
+	if cli.Duration("wait") <= 0 {
+		printJSON(signatures)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(cli.Context, cli.Duration("wait"))
+	defer cancel()
+
+	records := []*dbranch.ArticleRecord{}
+	pending := []*dbranch.PendingSignature{}
+	for _, signature := range signatures {
+		log.Printf("submitted tx: %s, waiting for it to be in the ledger and db-sync\n", signature.TxHash)
+
+		published, err := node.WaitForSignature(ctx, signature.TxHash, 10*time.Second)
+		if errors.Is(err, context.DeadlineExceeded) && cli.Context.Err() == nil {
+			signature, err = node.GetPendingSignature(signature.TxHash)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, signature)
+			continue
+		} else if err != nil {
+			return err
+		}
+		records = append(records, published...)
 	}
 
+	if len(pending) > 0 {
+		log.Printf("%d txs are still pending, the daemon publishes them once db-sync has them, see: wallet pending\n", len(pending))
+		printJSON(pending)
+		return nil
+	}
+
+	printJSON(records)
+	return nil
+}
+
+func signPassphrase(cli *cli.Context, wallet_id string) (string, error) {
+	/* the passphrase from a flag, the env or keyring dir, or a prompt if none is given and stdin is a terminal */
+	if file := cli.String("passphrase-file"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", errors.New("error reading passphrase file: " + err.Error())
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	if cli.Bool("passphrase-stdin") {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("error reading passphrase from stdin: " + err.Error())
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	passphrase, err := node.WalletPassphrase(wallet_id)
+	if err != nil || passphrase != "" {
+		return passphrase, err
+	}
+
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return "", errors.New("no wallet passphrase, use --passphrase-file, --passphrase-stdin, DBRANCH_WALLET_PASSPHRASE or the keyring dir")
+	}
+
+	fmt.Println("To sign enter cardano wallet password: ")
+
+	password, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", errors.New("error reading password: " + err.Error())
+	}
+	fmt.Println() // needed to clear the password from the terminal
+	return string(password), nil
+}
+
+func cliActor() string {
+	// actions taken from the cli are attributed to the local user
+	current, err := user.Current()
+	if err != nil {
+		return "cli"
+	}
+	return current.Username
+}
+
+func parseExpiry(value string) (time.Time, error) {
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().UTC().Add(duration), nil
+	}
+
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return expires, errors.New("invalid expiry, must be a duration or RFC3339 time: " + value)
+	}
+	return expires, nil
+}
+
+func parseSince(value string) (time.Time, error) {
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().UTC().Add(-duration), nil
+	}
+	return time.Parse(time.RFC3339, value)
 }
 
 func printJSON(data interface{}) {